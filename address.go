@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	// addressGroups is the number of groups of the Alephium mainnet and testnet.
+	addressGroups = 4
+	hashLength    = 32
+)
+
+// addressType is the type of the lockup script of an address, its first byte.
+type addressType byte
+
+const (
+	addressTypeP2PKH  addressType = 0x00
+	addressTypeP2MPKH addressType = 0x01
+	addressTypeP2SH   addressType = 0x02
+	addressTypeP2C    addressType = 0x03
+)
+
+func (t addressType) String() string {
+	switch t {
+	case addressTypeP2PKH:
+		return "P2PKH"
+	case addressTypeP2MPKH:
+		return "P2MPKH"
+	case addressTypeP2SH:
+		return "P2SH"
+	case addressTypeP2C:
+		return "P2C"
+	}
+	return fmt.Sprintf("unknown type %d", byte(t))
+}
+
+// alephiumAddress is a decoded Alephium address.
+type alephiumAddress struct {
+	address     string
+	addressType addressType
+	group       int
+}
+
+// parseAddress decodes an Alephium address, the base58 encoding of its lockup script, checking that
+// the script is well formed for its type. Alephium addresses don't carry a checksum, the exact
+// length expected for the type catches most of the typos.
+func parseAddress(address string) (alephiumAddress, error) {
+	decoded, err := base58Decode(address)
+	if err != nil {
+		return alephiumAddress{}, fmt.Errorf("address %s is not valid base58: %w", address, err)
+	}
+	if len(decoded) == 0 {
+		return alephiumAddress{}, fmt.Errorf("address is empty")
+	}
+
+	parsed := alephiumAddress{address: address, addressType: addressType(decoded[0])}
+	script := decoded[1:]
+	switch parsed.addressType {
+	case addressTypeP2PKH, addressTypeP2SH:
+		if len(script) != hashLength {
+			return alephiumAddress{}, fmt.Errorf("%s address %s is %d bytes long instead of %d", parsed.addressType,
+				address, len(script), hashLength)
+		}
+		parsed.group = groupOfHash(script)
+	case addressTypeP2MPKH:
+		// Number of public key hashes, the hashes and the number of signatures required, the numbers being
+		// compact integers, a single byte below 64
+		if len(script) < 2 || script[0] == 0 || script[0] >= 0x40 || len(script) != 1+int(script[0])*hashLength+1 {
+			return alephiumAddress{}, fmt.Errorf("P2MPKH address %s is malformed", address)
+		}
+		keys := int(script[0])
+		required := int(script[len(script)-1])
+		if required == 0 || required > keys {
+			return alephiumAddress{}, fmt.Errorf("P2MPKH address %s requires %d signatures out of %d keys", address,
+				required, keys)
+		}
+		parsed.group = groupOfHash(script[1 : 1+hashLength])
+	case addressTypeP2C:
+		if len(script) != hashLength {
+			return alephiumAddress{}, fmt.Errorf("P2C address %s is %d bytes long instead of %d", address,
+				len(script), hashLength)
+		}
+		// The last byte of a contract id is its group
+		parsed.group = int(script[hashLength-1]) % addressGroups
+	default:
+		return alephiumAddress{}, fmt.Errorf("address %s is of %s", address, parsed.addressType)
+	}
+	return parsed, nil
+}
+
+// parsePayoutAddress parses an address receiving mining rewards, which can't be a contract.
+func parsePayoutAddress(address string) (alephiumAddress, error) {
+	parsed, err := parseAddress(address)
+	if err != nil {
+		return parsed, err
+	}
+	if parsed.addressType == addressTypeP2C {
+		return alephiumAddress{}, fmt.Errorf("address %s is a contract address, which can't receive payouts", address)
+	}
+	return parsed, nil
+}
+
+// groupOfHash returns the group of a public key or script hash: the xor of the bytes of its djb2 hash,
+// with the lowest bit set, modulo the number of groups.
+func groupOfHash(hash []byte) int {
+	hint := uint32(5381)
+	for _, b := range hash {
+		hint = (hint << 5) + hint + uint32(b)
+	}
+	hint |= 1
+	xor := byte(hint>>24) ^ byte(hint>>16) ^ byte(hint>>8) ^ byte(hint)
+	return int(xor) % addressGroups
+}
+
+func base58Decode(encoded string) ([]byte, error) {
+	value := new(big.Int)
+	radix := big.NewInt(int64(len(base58Alphabet)))
+	for i, c := range encoded {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid character %q at position %d", c, i)
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+	// Each leading 1 encodes a leading zero byte
+	zeros := len(encoded) - len(strings.TrimLeft(encoded, base58Alphabet[:1]))
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
@@ -2,59 +2,110 @@ package main
 
 import (
 	"context"
-	alephium "github.com/alephium/go-sdk"
 	"github.com/prometheus/client_golang/prometheus"
+	"sync"
 	"time"
 )
 
 type AddressBalanceStats struct {
-	alephiumClient *alephium.APIClient
+	alephiumClient nodeClient
 	addresses      []string
-	metrics        *metrics
+	addressesLock  *sync.RWMutex
+	// balances are the last balances read, by address, for the reports.
+	balances     map[string]addressBalance
+	balancesLock *sync.RWMutex
+	metrics      *metrics
 }
 
-func newAddressBalanceStats(alephiumClient *alephium.APIClient, addresses []string, metrics *metrics) (*AddressBalanceStats, error) {
+// addressBalance is the balance of a watched address, as last read.
+type addressBalance struct {
+	Address string
+	Balance ALPH
+	Locked  ALPH
+	Utxos   int
+}
+
+func newAddressBalanceStats(alephiumClient nodeClient, addresses []string, metrics *metrics) (*AddressBalanceStats, error) {
 	handler := &AddressBalanceStats{
 		alephiumClient: alephiumClient,
 		addresses:      addresses,
+		addressesLock:  &sync.RWMutex{},
+		balances:       make(map[string]addressBalance),
+		balancesLock:   &sync.RWMutex{},
 		metrics:        metrics,
 	}
 	return handler, nil
 }
 
+// setAddresses changes the watched addresses, from the next stats on.
+func (h *AddressBalanceStats) setAddresses(addresses []string) {
+	h.addressesLock.Lock()
+	defer h.addressesLock.Unlock()
+	h.addresses = addresses
+}
+
+func (h *AddressBalanceStats) getAddresses() []string {
+	h.addressesLock.RLock()
+	defer h.addressesLock.RUnlock()
+	return h.addresses
+}
+
+// getBalances returns the last balances of the watched addresses, in their order, the addresses not
+// read yet being left out.
+func (h *AddressBalanceStats) getBalances() []addressBalance {
+	addresses := h.getAddresses()
+	h.balancesLock.RLock()
+	defer h.balancesLock.RUnlock()
+	balances := make([]addressBalance, 0, len(addresses))
+	for _, address := range addresses {
+		if balance, ok := h.balances[address]; ok {
+			balances = append(balances, balance)
+		}
+	}
+	return balances
+}
+
 func (h *AddressBalanceStats) Stats(ctx context.Context) error {
 	err := h.doStats(ctx)
 	if err != nil {
 		return err
 	}
-	for range time.Tick(time.Minute) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		default:
+		case <-ticker.C:
 		}
 		err = h.doStats(ctx)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
 }
 
 func (h *AddressBalanceStats) doStats(ctx context.Context) error {
-	for _, address := range h.addresses {
-		addressBalanceReq := h.alephiumClient.AddressesApi.GetAddressesAddressBalance(ctx, address)
-		balance, _, err := addressBalanceReq.Execute()
+	for _, address := range h.getAddresses() {
+		balance, err := h.alephiumClient.GetAddressBalance(ctx, address)
 		if err != nil {
 			return err
 		}
-		if addressBalance, ok := ALPHFromCoinString(balance.Balance); ok {
-			h.metrics.addressTotalBalance.With(prometheus.Labels{"address": address}).Set(addressBalance.FloatALPH())
+		totalBalance, totalOk := ALPHFromCoinString(balance.Balance)
+		if totalOk {
+			h.metrics.addressTotalBalance.With(prometheus.Labels{"address": address}).Set(totalBalance.FloatALPH())
 		}
-		if addressLockedBalance, ok := ALPHFromCoinString(balance.LockedBalance); ok {
-			h.metrics.addressLockedBalance.With(prometheus.Labels{"address": address}).Set(addressLockedBalance.FloatALPH())
+		lockedBalance, lockedOk := ALPHFromCoinString(balance.LockedBalance)
+		if lockedOk {
+			h.metrics.addressLockedBalance.With(prometheus.Labels{"address": address}).Set(lockedBalance.FloatALPH())
 		}
 		h.metrics.addressUtxos.With(prometheus.Labels{"address": address}).Set(float64(balance.UtxoNum))
+		if totalOk && lockedOk {
+			h.balancesLock.Lock()
+			h.balances[address] = addressBalance{Address: address, Balance: totalBalance, Locked: lockedBalance,
+				Utxos: int(balance.UtxoNum)}
+			h.balancesLock.Unlock()
+		}
 	}
 	return nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	testAddress1 = "1HZ4e8LoUTvzn9fbyTzU4rPD1EdMtVutwuhi3Whf618Xd"
+	testAddress2 = "1DhFRi41f6DySUPoBaoRaZrLpkRQAhG5ybcjPchSzNLRV"
+)
+
+func TestParseAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address     string
+		addressType addressType
+		group       int
+	}{
+		{"1wxtF1t5gYFFUdDVWrMzdapwGdQ1MT8tu258onRXjCm3", addressTypeP2PKH, 1},
+		{testAddress1, addressTypeP2PKH, 3},
+		{testAddress2, addressTypeP2PKH, 1},
+		{"2jet637khXy6vwhdPbqPCPPKhCkZ7cVnSn3WJ8tZD7dQgBBg2nsGhJFFcpVsKZ1PbytnxDzaGQcfraVnJiRSyeFQrXz", addressTypeP2MPKH, 3},
+		{"ditqRsZjSMKenBJT1TVMfrRLA3FTJm7uUaQEMeG35UfS", addressTypeP2SH, 1},
+		{"28THFrocUzh2PKFa8w4CfTsMdKFQR4J8to327qicT6riA", addressTypeP2C, 3},
+	} {
+		address, err := parseAddress(tc.address)
+		assert.Nil(t, err, tc.address)
+		assert.Equal(t, tc.addressType, address.addressType, tc.address)
+		assert.Equal(t, tc.group, address.group, tc.address)
+	}
+
+	for _, address := range []string{
+		"",
+		"1dest",
+		"1HZ4e8LoUTvzn9fbyTzU4rPD1EdMtVutwuhi3Whf6",
+		"1HZ4e8LoUTvzn9fbyTzU4rPD1EdMtVutwuhi3Whf618Xd1",
+		"1HZ4e8LoUTvzn9fbyTzU4rPD1EdMtVutwuhi3Whf618X0",
+		"mining-address-0",
+	} {
+		_, err := parseAddress(address)
+		assert.NotNil(t, err, address)
+	}
+
+	_, err := parsePayoutAddress("28THFrocUzh2PKFa8w4CfTsMdKFQR4J8to327qicT6riA")
+	assert.NotNil(t, err)
+	payout, err := parsePayoutSpec(testAddress1 + ":50," + testAddress2 + ":50")
+	assert.Nil(t, err)
+	assert.Nil(t, payout.validate())
+}
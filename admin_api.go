@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminAPI serves the JSON API to inspect and control the companion, protected by a bearer token.
+// With a fleet, the node is selected with ?node=name.
+type adminAPI struct {
+	ctx   context.Context
+	token string
+	// runs tracks the transfers triggered through the API, which the shutdown waits for.
+	runs    *sync.WaitGroup
+	members []*adminMember
+	log     *logrus.Logger
+}
+
+// adminMember is a node and its mining wallet controlled through the admin API, transferHandler
+// and ledger being nil if transfers or the ledger are not enabled.
+type adminMember struct {
+	name            string
+	alephiumClient  nodeClient
+	walletName      string
+	transferHandler *transferHandler
+	ledger          *transferLedger
+}
+
+type walletStatus struct {
+	Name          string   `json:"name"`
+	Locked        bool     `json:"locked"`
+	ActiveAddress string   `json:"activeAddress"`
+	Addresses     []string `json:"addresses"`
+}
+
+type transferStatusResponse struct {
+	Enabled bool      `json:"enabled"`
+	Paused  bool      `json:"paused"`
+	Running bool      `json:"running"`
+	DryRun  bool      `json:"dryRun"`
+	NextRun time.Time `json:"nextRun,omitempty"`
+	LastRun time.Time `json:"lastRun,omitempty"`
+}
+
+type statusResponse struct {
+	Name           string                 `json:"name,omitempty"`
+	Wallet         *walletStatus          `json:"wallet,omitempty"`
+	MinerAddresses []string               `json:"minerAddresses"`
+	Synced         bool                   `json:"synced"`
+	Transfer       transferStatusResponse `json:"transfer"`
+	Errors         map[string]string      `json:"errors,omitempty"`
+}
+
+// newAdminAPI returns the admin API, without any node until added with addMember. ctx bounds the
+// transfers triggered through the API, which are tracked by runs.
+func newAdminAPI(ctx context.Context, token string, runs *sync.WaitGroup, log *logrus.Logger) *adminAPI {
+	return &adminAPI{
+		ctx:   ctx,
+		token: token,
+		runs:  runs,
+		log:   log,
+	}
+}
+
+// addMember adds a node and its mining wallet, name being empty when the companion handles a single node.
+func (a *adminAPI) addMember(name string, alephiumClient nodeClient, walletName string,
+	transferHandler *transferHandler, ledger *transferLedger) {
+
+	a.members = append(a.members, &adminMember{
+		name:            name,
+		alephiumClient:  alephiumClient,
+		walletName:      walletName,
+		transferHandler: transferHandler,
+		ledger:          ledger,
+	})
+}
+
+func (a *adminAPI) register(mux *http.ServeMux) {
+	mux.Handle("GET /api/status", a.authenticated(a.status))
+	mux.Handle("POST /api/transfer", a.authenticated(a.triggerTransfer))
+	mux.Handle("POST /api/pause", a.authenticated(a.pause))
+	mux.Handle("POST /api/resume", a.authenticated(a.resume))
+	mux.Handle("GET /api/transfers", a.authenticated(a.transfers))
+}
+
+// authenticated rejects the requests without the bearer token of the admin API.
+func (a *adminAPI) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="alephium-mining-companion"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// member returns the node selected with ?node=name, which can be omitted when there's a single one.
+// It writes the error response and returns false if there's no such node.
+func (a *adminAPI) member(w http.ResponseWriter, r *http.Request) (*adminMember, bool) {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		if len(a.members) == 1 {
+			return a.members[0], true
+		}
+		writeJSONError(w, http.StatusBadRequest, "the node must be selected with ?node=name")
+		return nil, false
+	}
+	for _, member := range a.members {
+		if member.name == name {
+			return member, true
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "unknown node "+name)
+	return nil, false
+}
+
+// status serves the status of the selected node, or the combined status of all the nodes of a fleet
+// if none is selected.
+func (a *adminAPI) status(w http.ResponseWriter, r *http.Request) {
+	if len(a.members) > 1 && r.URL.Query().Get("node") == "" {
+		statuses := make([]statusResponse, 0, len(a.members))
+		for _, member := range a.members {
+			statuses = append(statuses, a.memberStatus(r.Context(), member))
+		}
+		writeJSON(w, http.StatusOK, map[string][]statusResponse{"nodes": statuses})
+		return
+	}
+	member, ok := a.member(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.memberStatus(r.Context(), member))
+}
+
+func (a *adminAPI) memberStatus(ctx context.Context, m *adminMember) statusResponse {
+	log := logrus.NewEntry(a.log)
+	status := statusResponse{Name: m.name, MinerAddresses: []string{}, Errors: make(map[string]string)}
+
+	wallet, err := getWalletStatus(ctx, m.alephiumClient, m.walletName, log)
+	if err != nil {
+		status.Errors["wallet"] = err.Error()
+	} else {
+		status.Wallet = &walletStatus{Name: wallet.WalletName, Locked: wallet.Locked, Addresses: []string{}}
+		walletAddresses, err := getWalletAddresses(ctx, m.alephiumClient, m.walletName, log)
+		if err != nil {
+			status.Errors["walletAddresses"] = err.Error()
+		} else {
+			status.Wallet.ActiveAddress = walletAddresses.ActiveAddress
+			status.Wallet.Addresses = GetAddressesAsString(walletAddresses.Addresses)
+		}
+	}
+
+	minerAddresses, err := getMinersAddresses(ctx, m.alephiumClient, log)
+	if err != nil {
+		status.Errors["minerAddresses"] = err.Error()
+	} else {
+		status.MinerAddresses = minerAddresses.Addresses
+	}
+
+	status.Synced, err = IsSynced(ctx, m.alephiumClient, log)
+	if err != nil {
+		status.Errors["synced"] = err.Error()
+	}
+
+	if m.transferHandler != nil {
+		status.Transfer = transferStatusResponse{
+			Enabled: true,
+			Paused:  m.transferHandler.isPaused(),
+			Running: m.transferHandler.isRunning(),
+			DryRun:  m.transferHandler.dryRun,
+			NextRun: m.transferHandler.getNextRun(),
+		}
+	}
+	if m.ledger != nil {
+		status.Transfer.LastRun, err = m.ledger.lastTransferRun()
+		if err != nil {
+			status.Errors["lastRun"] = err.Error()
+		}
+	}
+	return status
+}
+
+// triggerTransfer starts a transfer run right away, whether the planned runs are paused or not.
+// The run happens in the background, its outcome being visible on /api/transfers, and gets the shutdown
+// grace period like the planned runs.
+func (a *adminAPI) triggerTransfer(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.member(w, r)
+	if !ok {
+		return
+	}
+	if m.transferHandler == nil {
+		writeJSONError(w, http.StatusConflict, "transfers are disabled, TRANSFER_ADDRESS is not set")
+		return
+	}
+	if m.transferHandler.isRunning() {
+		writeJSONError(w, http.StatusConflict, "a transfer run is already in progress")
+		return
+	}
+	if a.ctx.Err() != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "the companion is shutting down")
+		return
+	}
+	a.log.Infof("Transfer run of wallet %s triggered through the admin API", m.walletName)
+	a.runs.Add(1)
+	go func() {
+		defer a.runs.Done()
+		err := m.transferHandler.transfer(a.ctx, logrus.NewEntry(a.log))
+		if err != nil {
+			a.log.WithError(err).Warnf("Got an error while running the transfer triggered through the admin API")
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "transfer started"})
+}
+
+func (a *adminAPI) pause(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.member(w, r)
+	if !ok {
+		return
+	}
+	if m.transferHandler == nil {
+		writeJSONError(w, http.StatusConflict, "transfers are disabled, TRANSFER_ADDRESS is not set")
+		return
+	}
+	m.transferHandler.pause()
+	a.log.Infof("Transfers of wallet %s paused through the admin API", m.walletName)
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (a *adminAPI) resume(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.member(w, r)
+	if !ok {
+		return
+	}
+	if m.transferHandler == nil {
+		writeJSONError(w, http.StatusConflict, "transfers are disabled, TRANSFER_ADDRESS is not set")
+		return
+	}
+	m.transferHandler.resume()
+	a.log.Infof("Transfers of wallet %s resumed through the admin API", m.walletName)
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+// transfers serves the transfers recorded in the ledger, newest first, optionally limited with ?limit=n.
+func (a *adminAPI) transfers(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.member(w, r)
+	if !ok {
+		return
+	}
+	if m.ledger == nil {
+		writeJSONError(w, http.StatusNotFound, "transfer history is disabled, LEDGER_PATH is not set")
+		return
+	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	records, err := m.ledger.list()
+	if err != nil {
+		a.log.WithError(err).Debugf("Got an error while listing the transfers of the ledger")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	newestFirst := make([]transferRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0 && (limit == 0 || len(newestFirst) < limit); i-- {
+		newestFirst = append(newestFirst, records[i])
+	}
+	writeJSON(w, http.StatusOK, map[string][]transferRecord{"transfers": newestFirst})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
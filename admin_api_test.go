@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdminAPI(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25")
+	node.minerAddresses = []string{"mining-address-0", "mining-address-1", "mining-address-2", "mining-address-3"}
+	ledger, err := newTransferLedger(filepath.Join(t.TempDir(), "ledger.db"), time.UTC)
+	assert.Nil(t, err)
+	defer ledger.Close()
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	handler.ledger = ledger
+
+	mux := http.NewServeMux()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := &sync.WaitGroup{}
+	adminAPI := newAdminAPI(ctx, "s3cr3t", runs, handler.log)
+	adminAPI.addMember("", node.client(), "mining", handler, ledger)
+	adminAPI.register(mux)
+	call := func(method string, path string, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/status", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/api/status", "wrong").Code)
+
+	response := call(http.MethodGet, "/api/status", "s3cr3t")
+	assert.Equal(t, http.StatusOK, response.Code)
+	var status statusResponse
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Equal(t, "mining", status.Wallet.Name)
+	assert.Len(t, status.Wallet.Addresses, 4)
+	assert.Equal(t, node.minerAddresses, status.MinerAddresses)
+	assert.True(t, status.Synced)
+	assert.True(t, status.Transfer.Enabled)
+	assert.Empty(t, status.Errors)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/pause", "s3cr3t").Code)
+	assert.True(t, handler.isPaused())
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/api/resume", "s3cr3t").Code)
+	assert.False(t, handler.isPaused())
+
+	assert.Equal(t, http.StatusAccepted, call(http.MethodPost, "/api/transfer", "s3cr3t").Code)
+	// The triggered run is tracked like the loops, the shutdown waiting for it
+	runs.Wait()
+	var transfers map[string][]transferRecord
+	response = call(http.MethodGet, "/api/transfers?limit=10", "s3cr3t")
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &transfers))
+	assert.Len(t, transfers["transfers"], 1)
+	assert.Equal(t, transferStatusConfirmed, transfers["transfers"][0].Status)
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodGet, "/api/transfers?limit=abc", "s3cr3t").Code)
+
+	// No run is started once shutting down
+	cancel()
+	assert.Equal(t, http.StatusServiceUnavailable, call(http.MethodPost, "/api/transfer", "s3cr3t").Code)
+}
+
+func TestAdminAPIFleet(t *testing.T) {
+	nodes := []*fakeNode{newFakeNode(t), newFakeNode(t)}
+	mux := http.NewServeMux()
+	adminAPI := newAdminAPI(context.Background(), "s3cr3t", &sync.WaitGroup{}, newTestLogger())
+	for i, name := range []string{"node-1", "node-2"} {
+		nodes[i].addWallet("mining", "secret")
+		adminAPI.addMember(name, nodes[i].client(), "mining", nil, nil)
+	}
+	adminAPI.register(mux)
+	call := func(method string, path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		request.Header.Set("Authorization", "Bearer s3cr3t")
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := call(http.MethodGet, "/api/status")
+	assert.Equal(t, http.StatusOK, response.Code)
+	var statuses map[string][]statusResponse
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &statuses))
+	assert.Len(t, statuses["nodes"], 2)
+	assert.Equal(t, "node-1", statuses["nodes"][0].Name)
+	assert.Equal(t, "node-2", statuses["nodes"][1].Name)
+
+	response = call(http.MethodGet, "/api/status?node=node-2")
+	assert.Equal(t, http.StatusOK, response.Code)
+	var status statusResponse
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Equal(t, "node-2", status.Name)
+	assert.False(t, status.Transfer.Enabled)
+
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/api/status?node=node-3").Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/api/pause").Code)
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/api/pause?node=node-1").Code)
+}
@@ -96,6 +96,22 @@ func (alph ALPH) PrettyString() string {
 	return alph.Amount.String()
 }
 
+// DecimalString returns the exact amount in ALPH, i.e. 2.5, without the rounding of FloatALPH.
+func (alph ALPH) DecimalString() string {
+	if alph.Amount == nil {
+		return "0"
+	}
+	units, decimals := new(big.Int).QuoRem(new(big.Int).Abs(alph.Amount), CoinInOneALPH, new(big.Int))
+	s := units.String()
+	if decimals.Sign() != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%018s", decimals.String()), "0")
+	}
+	if alph.Amount.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
 func (alph ALPH) FloatALPH() float64 {
 	c := new(big.Int)
 	nanoAFL := c.Div(alph.Amount, CoinInNanoALPH).Int64()
@@ -119,8 +135,7 @@ func RandomNanoALPHAmount(upperLimit int) ALPH {
 
 func (alph ALPH) MarshalJSON() ([]byte, error) {
 	buffer := bytes.NewBufferString(`"`)
-	str := alph.Amount.String()
-	buffer.WriteString(str)
+	buffer.WriteString(alph.String())
 	buffer.WriteString(`"`)
 	return buffer.Bytes(), nil
 }
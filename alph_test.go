@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"math/rand"
 	"os"
 	"testing"
@@ -164,3 +165,13 @@ func TestJSON(t *testing.T) {
 
 	fmt.Printf("%s\n", j2.Amount.PrettyString())
 }
+
+func TestALPHDecimalString(t *testing.T) {
+	for amount, expected := range map[string]string{"0": "0", "2.5": "2.5", "1000": "1000", "0.000000000000000001": "0.000000000000000001"} {
+		alph, ok := ALPHFromALPHString(amount)
+		assert.True(t, ok)
+		assert.Equal(t, expected, alph.DecimalString())
+	}
+	assert.Equal(t, "-0.002", ALPH{Amount: big.NewInt(-2000000000000000)}.DecimalString())
+	assert.Equal(t, "0", ALPH{}.DecimalString())
+}
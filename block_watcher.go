@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// blockWatchOverlap is how far back each poll looks again, as a block is only served once the node
+	// knows it, which can be a while after its timestamp.
+	blockWatchOverlap = 2 * time.Minute
+	// blockWatchMaxSpan is the longest time range asked to the node at once, as it limits it.
+	blockWatchMaxSpan = 10 * time.Minute
+)
+
+// minedBlock is a block whose coinbase pays one of the miner addresses.
+type minedBlock struct {
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+	FromGroup int32     `json:"fromGroup"`
+	ToGroup   int32     `json:"toGroup"`
+	Height    int32     `json:"height"`
+	Target    string    `json:"target"`
+	// Group is the group of the miner address paid by the coinbase.
+	Group   int32  `json:"group"`
+	Address string `json:"address"`
+	Reward  ALPH   `json:"reward"`
+}
+
+// overdueBlocks tells that no block was mined to the miner addresses for much longer than expected.
+type overdueBlocks struct {
+	// Since is when the last block was mined, or when the watcher started if none.
+	Since                   time.Time `json:"since"`
+	ElapsedSeconds          float64   `json:"elapsedSeconds"`
+	ExpectedIntervalSeconds float64   `json:"expectedIntervalSeconds"`
+	Hashrate                float64   `json:"hashrate"`
+}
+
+// blockShare is the work done to mine a block to the miner addresses, to estimate their hashrate.
+type blockShare struct {
+	time       time.Time
+	group      int32
+	difficulty float64
+}
+
+func (o *overdueBlocks) expectedInterval() time.Duration {
+	return time.Duration(o.ExpectedIntervalSeconds * float64(time.Second)).Round(time.Second)
+}
+
+// blockWatcher follows the new blocks of all the chains and picks the ones whose coinbase pays the
+// miner addresses, to tell whether the miners actually produce blocks.
+type blockWatcher struct {
+	alephiumClient nodeClient
+	interval       time.Duration
+	// minerAddresses are the miner addresses, one per group, by address.
+	minerAddresses map[string]int32
+	groups         int32
+	started        time.Time
+	cursor         time.Time
+	// seen are the timestamps of the blocks already looked at, by hash, as the polls overlap.
+	seen      map[string]int64
+	lastBlock map[int32]time.Time
+	// shares are the blocks mined to the miner addresses over the last hashrateWindow, to estimate their hashrate.
+	shares         []blockShare
+	hashrateWindow time.Duration
+	// expectedHashrate is the hashrate of the miners in H/s, zero to not tell when the blocks are overdue,
+	// i.e. when no block was mined for alertMultiple times the expected interval.
+	expectedHashrate float64
+	alertMultiple    float64
+	// difficulties are the difficulties of the last block of each chain, by chain.
+	difficulties map[[2]int32]float64
+	overdue      *overdueBlocks
+	// ledger records the rewards of the blocks in the earnings, if not nil.
+	ledger *transferLedger
+	// summarizer accounts the blocks in the earnings summary, if not nil.
+	summarizer *earningsSummarizer
+	lock       *sync.RWMutex
+	metrics    *metrics
+	notifier   *notifier
+	log        *logrus.Logger
+}
+
+func newBlockWatcher(alephiumClient nodeClient, interval time.Duration, hashrateWindow time.Duration,
+	expectedHashrate float64, alertMultiple float64, ledger *transferLedger, summarizer *earningsSummarizer,
+	metrics *metrics, notifier *notifier, log *logrus.Logger) *blockWatcher {
+	return &blockWatcher{
+		alephiumClient:   alephiumClient,
+		interval:         interval,
+		minerAddresses:   make(map[string]int32),
+		seen:             make(map[string]int64),
+		lastBlock:        make(map[int32]time.Time),
+		hashrateWindow:   hashrateWindow,
+		expectedHashrate: expectedHashrate,
+		alertMultiple:    alertMultiple,
+		ledger:           ledger,
+		difficulties:     make(map[[2]int32]float64),
+		summarizer:       summarizer,
+		lock:             &sync.RWMutex{},
+		metrics:          metrics,
+		notifier:         notifier,
+		log:              log,
+	}
+}
+
+// setMinerAddresses changes the watched miner addresses, the one of each group in order.
+func (w *blockWatcher) setMinerAddresses(addresses []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.minerAddresses = make(map[string]int32)
+	for group, address := range addresses {
+		w.minerAddresses[address] = int32(group)
+	}
+	w.groups = int32(len(addresses))
+}
+
+// lastBlockMined returns when the last block paying the miner address of group was mined, zero if none
+// since the watcher started.
+func (w *blockWatcher) lastBlockMined(group int32) time.Time {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.lastBlock[group]
+}
+
+// watch polls the new blocks every interval until ctx is done.
+func (w *blockWatcher) watch(ctx context.Context) error {
+	w.lock.Lock()
+	if w.started.IsZero() {
+		w.started = time.Now()
+		w.cursor = w.started
+	}
+	w.lock.Unlock()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		err := w.poll(ctx, time.Now())
+		if err != nil {
+			w.log.WithError(err).Debugf("Got an error while polling the new blocks")
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll looks at the blocks since the previous poll, up to now.
+func (w *blockWatcher) poll(ctx context.Context, now time.Time) error {
+	w.lock.RLock()
+	from := w.cursor.Add(-blockWatchOverlap)
+	w.lock.RUnlock()
+	for from.Before(now) {
+		to := from.Add(blockWatchMaxSpan)
+		if to.After(now) {
+			to = now
+		}
+		blocks, err := w.alephiumClient.GetBlocks(ctx, from.UnixMilli(), to.UnixMilli())
+		if err != nil {
+			return fmt.Errorf("can't get the blocks from %s to %s: %w", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+		}
+		for _, chain := range blocks.Blocks {
+			for _, block := range chain {
+				w.processBlock(block)
+			}
+		}
+		from = to
+	}
+
+	w.lock.Lock()
+	w.cursor = now
+	// The blocks out of the overlap won't be served again
+	oldest := now.Add(-2 * blockWatchOverlap).UnixMilli()
+	for hash, timestamp := range w.seen {
+		if timestamp < oldest {
+			delete(w.seen, hash)
+		}
+	}
+	for group := int32(0); group < w.groups; group++ {
+		last := w.lastBlock[group]
+		if last.Before(w.started) {
+			last = w.started
+		}
+		w.metrics.sinceLastBlock.With(prometheus.Labels{"group": fmt.Sprint(group)}).Set(now.Sub(last).Seconds())
+	}
+	overdue := w.checkOverdue(now)
+	w.lock.Unlock()
+
+	if overdue != nil {
+		w.log.Warnf("No block mined to the miner addresses since %s, while one is expected every %s at %s",
+			overdue.Since.Format(time.RFC3339), overdue.expectedInterval(), formatHashrate(overdue.Hashrate))
+		w.notifier.notify(event{Type: eventBlocksOverdue, Endpoint: w.alephiumClient.Host(), Overdue: overdue})
+	}
+	w.updateHashrates(ctx, now)
+	return nil
+}
+
+// updateHashrates estimates the hashrate of the miners of each group over the window, or since the watcher
+// started if later, as the work of the blocks they mined, i.e. the number of hashes expected to mine them,
+// over the time it took. The estimates are compared with the hashrate of the network, which the node
+// computes the same way.
+func (w *blockWatcher) updateHashrates(ctx context.Context, now time.Time) {
+	w.lock.Lock()
+	oldest := now.Add(-w.hashrateWindow)
+	if oldest.Before(w.started) {
+		oldest = w.started
+	}
+	work := make([]float64, w.groups)
+	kept := w.shares[:0]
+	for _, share := range w.shares {
+		if share.time.Before(oldest) {
+			continue
+		}
+		kept = append(kept, share)
+		if share.group < w.groups {
+			work[share.group] += blockWork(share.difficulty, w.groups)
+		}
+	}
+	w.shares = kept
+	w.lock.Unlock()
+	span := now.Sub(oldest).Seconds()
+	if span <= 0 {
+		return
+	}
+
+	total := 0.0
+	for group, hashes := range work {
+		total += hashes / span
+		w.metrics.effectiveHashrate.With(prometheus.Labels{"group": fmt.Sprint(group)}).Set(hashes / span)
+	}
+	response, err := w.alephiumClient.GetCurrentHashrate(ctx, w.hashrateWindow.Milliseconds())
+	if err != nil {
+		w.log.WithError(err).Warnf("Hashrate of the network can't be read, the share of the miners is not updated")
+		return
+	}
+	network, err := parseHashrate(response.Hashrate)
+	if err != nil {
+		w.log.WithError(err).Warnf("Hashrate of the network can't be parsed, the share of the miners is not updated")
+		return
+	}
+	w.metrics.networkHashrate.Set(network)
+	if network > 0 {
+		w.metrics.networkShare.Set(total / network)
+	}
+}
+
+// expectedInterval returns the expected interval between two blocks mined at the expected hashrate, given
+// the work of the average difficulty of the last blocks of the chains, false if unknown. The lock is held.
+func (w *blockWatcher) expectedInterval() (time.Duration, bool) {
+	if w.expectedHashrate <= 0 || len(w.difficulties) == 0 {
+		return 0, false
+	}
+	difficulty := 0.0
+	for _, d := range w.difficulties {
+		difficulty += d
+	}
+	difficulty /= float64(len(w.difficulties))
+	return time.Duration(blockWork(difficulty, w.groups) / w.expectedHashrate * float64(time.Second)), true
+}
+
+// checkOverdue updates whether the blocks are overdue at now, returning them if they just became, the
+// lock being held.
+func (w *blockWatcher) checkOverdue(now time.Time) *overdueBlocks {
+	expected, ok := w.expectedInterval()
+	if !ok {
+		return nil
+	}
+	w.metrics.expectedInterval.Set(expected.Seconds())
+	since := w.started
+	for _, last := range w.lastBlock {
+		if last.After(since) {
+			since = last
+		}
+	}
+	elapsed := now.Sub(since)
+	if elapsed.Seconds() <= w.alertMultiple*expected.Seconds() {
+		w.metrics.blocksOverdue.Set(0)
+		w.overdue = nil
+		return nil
+	}
+	w.metrics.blocksOverdue.Set(1)
+	wasOverdue := w.overdue != nil
+	w.overdue = &overdueBlocks{Since: since, ElapsedSeconds: elapsed.Seconds(),
+		ExpectedIntervalSeconds: expected.Seconds(), Hashrate: w.expectedHashrate}
+	if wasOverdue {
+		return nil
+	}
+	return w.overdue
+}
+
+// checkBlocksMined is the health check failing while the blocks are overdue.
+func (w *blockWatcher) checkBlocksMined() error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.overdue == nil {
+		return nil
+	}
+	return fmt.Errorf("no block mined to the miner addresses since %s, %.1f times the expected interval of %s at %s",
+		w.overdue.Since.Format(time.RFC3339), w.overdue.ElapsedSeconds/w.overdue.ExpectedIntervalSeconds,
+		w.overdue.expectedInterval(), formatHashrate(w.overdue.Hashrate))
+}
+
+// processBlock accounts the block if its coinbase pays a miner address and it was not seen yet.
+func (w *blockWatcher) processBlock(block alephium.BlockEntry) {
+	w.lock.Lock()
+	if _, ok := w.seen[block.Hash]; ok {
+		w.lock.Unlock()
+		return
+	}
+	w.seen[block.Hash] = block.Timestamp
+	difficulty, err := targetDifficulty(block.Target)
+	if err == nil {
+		w.difficulties[[2]int32{block.ChainFrom, block.ChainTo}] = difficulty
+	} else {
+		w.log.WithError(err).Debugf("Got an error while reading the target of block %s", block.Hash)
+	}
+	mined, ok := w.coinbaseReward(block)
+	if ok && mined.Time.After(w.lastBlock[mined.Group]) {
+		w.lastBlock[mined.Group] = mined.Time
+	}
+	if ok && err == nil {
+		w.shares = append(w.shares, blockShare{time: mined.Time, group: mined.Group, difficulty: difficulty})
+	}
+	w.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	w.log.Infof("Block %s of chain %d->%d mined to %s, rewarding %s", mined.Hash, mined.FromGroup, mined.ToGroup,
+		mined.Address, mined.Reward.PrettyString())
+	chainLabels := prometheus.Labels{"from_group": fmt.Sprint(mined.FromGroup), "to_group": fmt.Sprint(mined.ToGroup)}
+	w.metrics.blocksMined.With(chainLabels).Inc()
+	w.metrics.coinbaseReward.With(chainLabels).Add(mined.Reward.FloatALPH())
+	w.notifier.notify(event{Type: eventBlockMined, Endpoint: w.alephiumClient.Host(), Addresses: []string{mined.Address},
+		Block: &mined})
+	w.summarizer.addBlock(mined)
+	if w.ledger != nil {
+		err := w.ledger.recordBlock(mined)
+		if err != nil {
+			w.log.WithError(err).Warnf("Reward of block %s can't be recorded in the earnings", mined.Hash)
+		}
+	}
+}
+
+// coinbaseReward returns the block as mined if its coinbase, the tx without inputs, pays a miner address,
+// the lock being held.
+func (w *blockWatcher) coinbaseReward(block alephium.BlockEntry) (minedBlock, bool) {
+	for _, tx := range block.Transactions {
+		if len(tx.Unsigned.Inputs) > 0 {
+			continue
+		}
+		mined := minedBlock{
+			Hash:      block.Hash,
+			Time:      time.UnixMilli(block.Timestamp).UTC(),
+			FromGroup: block.ChainFrom,
+			ToGroup:   block.ChainTo,
+			Height:    block.Height,
+			Target:    block.Target,
+			Reward:    ALPH{Amount: new(big.Int)},
+		}
+		paid := false
+		for _, output := range tx.Unsigned.FixedOutputs {
+			group, ok := w.minerAddresses[output.Address]
+			if !ok {
+				continue
+			}
+			amount, ok := ALPHFromCoinString(output.AttoAlphAmount)
+			if !ok {
+				w.log.Warnf("Coinbase amount %s of block %s can't be parsed", output.AttoAlphAmount, block.Hash)
+				continue
+			}
+			mined.Group = group
+			mined.Address = output.Address
+			mined.Reward = mined.Reward.Add(amount)
+			paid = true
+		}
+		return mined, paid
+	}
+	return minedBlock{}, false
+}
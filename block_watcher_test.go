@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBlockWatcher(t *testing.T) {
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret")
+	minerAddresses := wallet.minerAddresses()
+	sink := &recordingSink{}
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("recording", sink, nil)
+	m := newTestMetrics()
+	watcher := newBlockWatcher(node.client(), time.Second, time.Hour, 0, 5, nil, nil, m, n, newTestLogger())
+	watcher.setMinerAddresses(minerAddresses)
+	start := time.Now().Add(-time.Hour)
+	watcher.started, watcher.cursor = start, start
+
+	chainLabels := prometheus.Labels{"from_group": "1", "to_group": "3"}
+	minedBefore := testutil.ToFloat64(m.blocksMined.With(chainLabels))
+	rewardBefore := testutil.ToFloat64(m.coinbaseReward.With(chainLabels))
+
+	// Blocks paying the miner address, someone else and mined before the watcher started
+	mined := node.mineBlock(1, 3, minerAddresses[3], "2.5", start.Add(30*time.Minute))
+	node.mineBlock(1, 3, "someone-else", "2.5", start.Add(31*time.Minute))
+	node.mineBlock(0, 0, minerAddresses[0], "2.5", start.Add(-10*time.Minute))
+	ctx := context.Background()
+	assert.Nil(t, watcher.poll(ctx, start.Add(40*time.Minute)))
+	// The overlap of the next poll doesn't account the block twice, the late block is picked up
+	late := node.mineBlock(2, 3, minerAddresses[3], "2.75", start.Add(39*time.Minute))
+	assert.Nil(t, watcher.poll(ctx, start.Add(45*time.Minute)))
+	n.Close(5 * time.Second)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.blocksMined.With(chainLabels))-minedBefore)
+	assert.InDelta(t, 2.5, testutil.ToFloat64(m.coinbaseReward.With(chainLabels))-rewardBefore, 1e-9)
+	assert.Equal(t, time.UnixMilli(late.Timestamp).UTC(), watcher.lastBlockMined(3))
+	assert.True(t, watcher.lastBlockMined(0).IsZero())
+	assert.InDelta(t, 6*60.0, testutil.ToFloat64(m.sinceLastBlock.With(prometheus.Labels{"group": "3"})), 0.01)
+	// The groups without block count from the start of the watcher
+	assert.InDelta(t, 45*60.0, testutil.ToFloat64(m.sinceLastBlock.With(prometheus.Labels{"group": "0"})), 0.01)
+
+	assert.Equal(t, []eventType{eventBlockMined, eventBlockMined}, sink.types())
+	assert.Equal(t, mined.Hash, sink.events[0].Block.Hash)
+	assert.Equal(t, int32(3), sink.events[0].Block.Group)
+	assert.Equal(t, "2.5ALPH", sink.events[0].Block.Reward.PrettyString())
+	assert.Equal(t, late.Hash, sink.events[1].Block.Hash)
+}
+
+func TestBlocksOverdue(t *testing.T) {
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret")
+	sink := &recordingSink{}
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("recording", sink, nil)
+	m := newTestMetrics()
+	// The blocks of the fake node are mined with a difficulty of about 2^32 on 16 chains, one every minute at
+	// this hashrate
+	hashrate, err := parseHashrate("1145.32MH/s")
+	assert.Nil(t, err)
+	watcher := newBlockWatcher(node.client(), time.Second, time.Hour, hashrate, 5, nil, nil, m, n, newTestLogger())
+	watcher.setMinerAddresses(wallet.minerAddresses())
+	start := time.Now().Add(-time.Hour)
+	watcher.started, watcher.cursor = start, start
+
+	ctx := context.Background()
+	node.mineBlock(0, 1, "someone-else", "2.5", start.Add(time.Minute))
+	node.mineBlock(0, 0, wallet.addresses[0].Address, "2.5", start.Add(10*time.Minute))
+	assert.Nil(t, watcher.poll(ctx, start.Add(14*time.Minute)))
+	assert.InDelta(t, 60.0, testutil.ToFloat64(m.expectedInterval), 0.01)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.blocksOverdue))
+	assert.Nil(t, watcher.checkBlocksMined())
+
+	// Overdue past 5 times the expected interval, notified once
+	assert.Nil(t, watcher.poll(ctx, start.Add(16*time.Minute)))
+	assert.Nil(t, watcher.poll(ctx, start.Add(17*time.Minute)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.blocksOverdue))
+	assert.NotNil(t, watcher.checkBlocksMined())
+
+	// Back to normal with the next block
+	node.mineBlock(0, 0, wallet.addresses[0].Address, "2.5", start.Add(16*time.Minute+30*time.Second))
+	assert.Nil(t, watcher.poll(ctx, start.Add(18*time.Minute)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.blocksOverdue))
+	assert.Nil(t, watcher.checkBlocksMined())
+	n.Close(5 * time.Second)
+
+	assert.Equal(t, []eventType{eventBlockMined, eventBlocksOverdue, eventBlockMined}, sink.types())
+	overdue := sink.events[1].Overdue
+	assert.Equal(t, start.Add(10*time.Minute).Truncate(time.Millisecond).UTC(), overdue.Since)
+	assert.InDelta(t, 6*60.0, overdue.ElapsedSeconds, 0.01)
+}
+
+func TestExpectedInterval(t *testing.T) {
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret")
+	// The hashrate of the network the node computes when each chain mines a block every 64s at the difficulty of
+	// the fake node
+	network, err := parseHashrate("1073.76MH/s")
+	assert.Nil(t, err)
+	watcher := newBlockWatcher(node.client(), time.Second, time.Hour, network, 5, nil, nil, newTestMetrics(), nil,
+		newTestLogger())
+	watcher.setMinerAddresses(wallet.minerAddresses())
+	start := time.Now().Add(-time.Hour)
+	watcher.started, watcher.cursor = start, start
+	node.mineBlock(2, 1, "someone-else", "2.5", start.Add(time.Minute))
+	assert.Nil(t, watcher.poll(context.Background(), start.Add(2*time.Minute)))
+
+	// Miners having the hashrate of the network mine a block every 64s, half of it one every 128s
+	expected, ok := watcher.expectedInterval()
+	assert.True(t, ok)
+	assert.InDelta(t, 64.0, expected.Seconds(), 0.01)
+	watcher.expectedHashrate = network / 2
+	expected, _ = watcher.expectedInterval()
+	assert.InDelta(t, 128.0, expected.Seconds(), 0.01)
+}
+
+func TestEffectiveHashrate(t *testing.T) {
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret")
+	node.mu.Lock()
+	node.hashrate = "100 MH/s"
+	node.mu.Unlock()
+	m := newTestMetrics()
+	watcher := newBlockWatcher(node.client(), time.Second, 30*time.Minute, 0, 5, nil, nil, m, nil, newTestLogger())
+	watcher.setMinerAddresses(wallet.minerAddresses())
+	start := time.Now().Add(-2 * time.Hour)
+	watcher.started, watcher.cursor = start, start
+
+	// Only the blocks of the last 30 minutes count
+	node.mineBlock(1, 2, wallet.addresses[1].Address, "2.5", start.Add(40*time.Minute))
+	node.mineBlock(0, 1, wallet.addresses[0].Address, "2.5", start.Add(70*time.Minute))
+	node.mineBlock(0, 2, wallet.addresses[0].Address, "2.5", start.Add(85*time.Minute))
+	node.mineBlock(0, 3, wallet.addresses[0].Address, "2.5", start.Add(100*time.Minute))
+	node.mineBlock(2, 3, "someone-else", "2.5", start.Add(105*time.Minute))
+	assert.Nil(t, watcher.poll(context.Background(), start.Add(110*time.Minute)))
+
+	difficulty, err := targetDifficulty("1d00ffff")
+	assert.Nil(t, err)
+	group0 := testutil.ToFloat64(m.effectiveHashrate.With(prometheus.Labels{"group": "0"}))
+	// Each block takes the difficulty times the 16 chains hashes to mine
+	assert.InDelta(t, 2*16*difficulty/1800, group0, 1)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.effectiveHashrate.With(prometheus.Labels{"group": "1"})))
+	assert.Equal(t, 100e6, testutil.ToFloat64(m.networkHashrate))
+	assert.InDelta(t, group0/100e6, testutil.ToFloat64(m.networkShare), 1e-9)
+
+	// A watcher started recently estimates over the time it ran
+	watcher.started = start.Add(95 * time.Minute)
+	watcher.updateHashrates(context.Background(), start.Add(110*time.Minute))
+	group0 = testutil.ToFloat64(m.effectiveHashrate.With(prometheus.Labels{"group": "0"}))
+	assert.InDelta(t, 16*difficulty/900, group0, 1)
+}
+
+func TestNetworkShare(t *testing.T) {
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret")
+	// The hashrate of the network the node computes when each chain mines a block every 64s at the difficulty of
+	// the fake node
+	node.mu.Lock()
+	node.hashrate = "1073.76 MH/s"
+	node.mu.Unlock()
+	m := newTestMetrics()
+	watcher := newBlockWatcher(node.client(), time.Second, 32*time.Minute, 0, 5, nil, nil, m, nil, newTestLogger())
+	watcher.setMinerAddresses(wallet.minerAddresses())
+	start := time.Now().Add(-time.Hour)
+	watcher.started, watcher.cursor = start, start
+
+	// Miners mining a block every 128s over the window have half the hashrate of the network
+	window := start.Add(28 * time.Minute)
+	for i := 0; i < 15; i++ {
+		group := int32(i % int(fakeGroups))
+		node.mineBlock(group, 0, wallet.addresses[group].Address, "2.5", window.Add(time.Duration(i*128+64)*time.Second))
+	}
+	assert.Nil(t, watcher.poll(context.Background(), window.Add(32*time.Minute)))
+	assert.InDelta(t, 0.5, testutil.ToFloat64(m.networkShare), 1e-4)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chatDiscord  = "discord"
+	chatSlack    = "slack"
+	chatTelegram = "telegram"
+
+	defaultTelegramAPIURL = "https://api.telegram.org"
+	// defaultChatMaxPerMinute stays below the limits of the chat services, i.e. 30 messages per minute
+	// for a Discord webhook or 20 for a Telegram group.
+	defaultChatMaxPerMinute = 20
+)
+
+// defaultChatEvents are the events worth a chat message, the channels not listing their events getting
+// these ones.
+var defaultChatEvents = []eventType{eventTransferConfirmed, eventTransferFailed, eventNodeOutOfSync, eventNodeSynced,
+	eventEarningsSummary, eventBlocksOverdue}
+
+// chatConfig is a chat channel of the notifications config: a Discord or Slack incoming webhook, or a
+// Telegram chat along with the token of the bot posting to it.
+type chatConfig struct {
+	Name         string      `json:"name"`
+	URL          string      `json:"url"`
+	URLFile      string      `json:"urlFile"`
+	BotToken     string      `json:"botToken"`
+	BotTokenFile string      `json:"botTokenFile"`
+	ChatID       string      `json:"chatId"`
+	APIURL       string      `json:"apiUrl"`
+	Events       []eventType `json:"events"`
+	MaxPerMinute int         `json:"maxPerMinute"`
+}
+
+// validate checks the channel of the given platform, setting the defaults.
+func (c *chatConfig) validate(platform string) error {
+	if c.Name == "" {
+		c.Name = platform
+	}
+	if len(c.Events) == 0 {
+		c.Events = defaultChatEvents
+	}
+	if c.MaxPerMinute == 0 {
+		c.MaxPerMinute = defaultChatMaxPerMinute
+	}
+	if c.MaxPerMinute < 0 {
+		return fmt.Errorf("%s: maxPerMinute %d must be positive", c.Name, c.MaxPerMinute)
+	}
+	err := checkEventTypes(c.Events)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Name, err)
+	}
+	if platform == chatTelegram {
+		if c.BotToken == "" && c.BotTokenFile == "" {
+			return fmt.Errorf("%s: botToken or botTokenFile is mandatory", c.Name)
+		}
+		if c.ChatID == "" {
+			return fmt.Errorf("%s: chatId is mandatory", c.Name)
+		}
+		if c.APIURL == "" {
+			c.APIURL = defaultTelegramAPIURL
+		}
+		return nil
+	}
+	if c.URL == "" && c.URLFile == "" {
+		return fmt.Errorf("%s: url or urlFile is mandatory", c.Name)
+	}
+	if c.URL != "" {
+		if parseHTTPURL("url", c.URL) != nil {
+			// The url of an incoming webhook is a secret, it's not logged
+			return fmt.Errorf("%s: url is not an http(s) url", c.Name)
+		}
+	}
+	return nil
+}
+
+// chatMessage returns the title and the text of the chat message telling about e.
+func chatMessage(e event) (string, string) {
+	where := ""
+	if e.Node != "" {
+		where = fmt.Sprintf(" [%s]", e.Node)
+	}
+	switch e.Type {
+	case eventTransferSubmitted:
+		return "Transfer submitted" + where, fmt.Sprintf("Submitted tx %s sweeping address %s, group %d to %d.",
+			e.Transfer.TxId, e.Transfer.FromAddress, e.Transfer.FromGroup, e.Transfer.ToGroup)
+	case eventTransferConfirmed:
+		return "Transfer confirmed" + where, fmt.Sprintf("Swept %s from group %d to %s in block %s, for a fee of %s.",
+			e.Transfer.Amount.PrettyString(), e.Transfer.FromGroup, strings.Join(e.Transfer.ToAddresses, ", "),
+			e.Transfer.BlockHash, e.Transfer.Fee.PrettyString())
+	case eventTransferFailed:
+		if e.Transfer != nil {
+			return "Transfer failed" + where, fmt.Sprintf("Tx %s sweeping address %s failed: %s.", e.Transfer.TxId,
+				e.Transfer.FromAddress, e.Error)
+		}
+		return "Transfer failed" + where, fmt.Sprintf("Transfer from wallet %s failed: %s.", e.Wallet, e.Error)
+	case eventNodeOutOfSync:
+		return "Node out of sync" + where, fmt.Sprintf("Node %s is not in sync with its peers anymore, "+
+			"the blocks it mines may be lost.", e.Endpoint)
+	case eventNodeSynced:
+		return "Node in sync" + where, fmt.Sprintf("Node %s is back in sync with its peers.", e.Endpoint)
+	case eventMinerAddressesChanged:
+		return "Miner addresses changed" + where, fmt.Sprintf("Node %s now mines to the addresses %s of wallet %s.",
+			e.Endpoint, strings.Join(e.Addresses, ", "), e.Wallet)
+	case eventWalletCreated:
+		return "Wallet created" + where, fmt.Sprintf("Mining wallet %s was created on node %s.", e.Wallet, e.Endpoint)
+	case eventBlockMined:
+		return "Block mined" + where, fmt.Sprintf("Mined block %s of chain %d->%d at height %d, rewarding %s to %s.",
+			e.Block.Hash, e.Block.FromGroup, e.Block.ToGroup, e.Block.Height, e.Block.Reward.PrettyString(),
+			e.Block.Address)
+	case eventBlocksOverdue:
+		return "No block mined" + where, fmt.Sprintf("No block mined to the miner addresses since %s, while one is "+
+			"expected every %s at %s. Check the miners and their addresses.", e.Overdue.Since.Format(time.RFC1123),
+			e.Overdue.expectedInterval(), formatHashrate(e.Overdue.Hashrate))
+	case eventEarningsSummary:
+		text := fmt.Sprintf("Mined %d blocks rewarding %s and swept %s in %d transfers since %s, for %s of fees.",
+			e.Summary.Blocks, e.Summary.Rewards.PrettyString(), e.Summary.Amount.PrettyString(), e.Summary.Transfers,
+			e.Summary.From.Format(time.RFC1123), e.Summary.Fees.PrettyString())
+		if e.Summary.Balance != nil {
+			text += fmt.Sprintf(" Wallet %s holds %s.", e.Wallet, e.Summary.Balance.PrettyString())
+		}
+		return "Earnings summary" + where, text
+	}
+	return "", ""
+}
+
+// rateLimiter spaces out the messages of a chat channel.
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	lock     *sync.Mutex
+}
+
+func newRateLimiter(maxPerMinute int) *rateLimiter {
+	return &rateLimiter{interval: time.Minute / time.Duration(maxPerMinute), lock: &sync.Mutex{}}
+}
+
+// wait blocks until the next message can be sent, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.lock.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// chatSink posts human-readable messages to a chat channel, with the format of its platform.
+type chatSink struct {
+	platform   string
+	url        *secret
+	botToken   *secret
+	chatID     string
+	apiURL     string
+	limiter    *rateLimiter
+	httpClient *http.Client
+}
+
+func newChatSink(platform string, config chatConfig) *chatSink {
+	return &chatSink{
+		platform:   platform,
+		url:        newSecret(config.Name+" url", config.URL, config.URLFile),
+		botToken:   newSecret(config.Name+" bot token", config.BotToken, config.BotTokenFile),
+		chatID:     config.ChatID,
+		apiURL:     strings.TrimSuffix(config.APIURL, "/"),
+		limiter:    newRateLimiter(config.MaxPerMinute),
+		httpClient: &http.Client{Timeout: notificationTimeout},
+	}
+}
+
+// payload returns the url to post the message to, and the message in the format of the platform.
+func (s *chatSink) payload(title string, text string) (string, interface{}, error) {
+	switch s.platform {
+	case chatDiscord:
+		endpoint, err := s.url.revealString()
+		return endpoint, map[string]string{"content": "**" + title + "**\n" + text}, err
+	case chatSlack:
+		// Slack only requires &, < and > to be escaped
+		escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+		endpoint, err := s.url.revealString()
+		return endpoint, map[string]string{"text": "*" + escape(title) + "*\n" + escape(text)}, err
+	case chatTelegram:
+		token, err := s.botToken.revealString()
+		return s.apiURL + "/bot" + token + "/sendMessage", map[string]interface{}{
+			"chat_id":                  s.chatID,
+			"text":                     "<b>" + html.EscapeString(title) + "</b>\n" + html.EscapeString(text),
+			"parse_mode":               "HTML",
+			"disable_web_page_preview": true,
+		}, err
+	}
+	return "", nil, fmt.Errorf("unknown chat platform %s", s.platform)
+}
+
+func (s *chatSink) deliver(ctx context.Context, e event) error {
+	title, text := chatMessage(e)
+	if title == "" {
+		return nil
+	}
+	endpoint, payload, err := s.payload(title, text)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanent(err)
+	}
+	err = s.limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		// Not wrapping the error, which would give the url and its token
+		return permanent(fmt.Errorf("%s message can't be sent, check the url", s.platform))
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s message can't be sent: %w", s.platform, unwrapURLError(err))
+	}
+	defer response.Body.Close()
+	reason, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	return httpDeliveryError(response.StatusCode, strings.TrimSpace(string(reason)))
+}
+
+// unwrapURLError drops the url from the errors of the http client, as the urls of the chat services
+// hold their token.
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
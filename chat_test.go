@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadChatConfig(t *testing.T) {
+	config, err := loadNotificationsConfig(writeNotificationsConfig(t, `{
+		"discord": [{"url": "https://discord.com/api/webhooks/1/token"}],
+		"telegram": [{"name": "ops", "botToken": "123:abc", "chatId": "-100", "events": ["node.out_of_sync"], "maxPerMinute": 5}]
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "discord", config.Discord[0].Name)
+	assert.Equal(t, defaultChatEvents, config.Discord[0].Events)
+	assert.Equal(t, defaultChatMaxPerMinute, config.Discord[0].MaxPerMinute)
+	assert.Equal(t, defaultTelegramAPIURL, config.Telegram[0].APIURL)
+	assert.Equal(t, []eventType{eventNodeOutOfSync}, config.Telegram[0].Events)
+
+	for _, content := range []string{
+		`{"slack": [{}]}`,
+		`{"slack": [{"url": "hooks.slack.com/services/x"}]}`,
+		`{"discord": [{"url": "https://discord.com/api/webhooks/1/token", "maxPerMinute": -1}]}`,
+		`{"telegram": [{"botToken": "123:abc"}]}`,
+		`{"telegram": [{"chatId": "-100"}]}`,
+		`{"telegram": [{"botToken": "123:abc", "chatId": "-100", "events": ["transfer.lost"]}]}`,
+	} {
+		_, err = loadNotificationsConfig(writeNotificationsConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestChatMessage(t *testing.T) {
+	amount, _ := ALPHFromALPHString("123.45")
+	fee, _ := ALPHFromALPHString("0.002")
+	title, text := chatMessage(event{Type: eventTransferConfirmed, Node: "node-1", Transfer: &transferRecord{
+		TxId: "tx-1", FromGroup: 2, ToAddresses: []string{"1dest"}, BlockHash: "block-1", Amount: amount, Fee: fee}})
+	assert.Equal(t, "Transfer confirmed [node-1]", title)
+	assert.Equal(t, "Swept 123.45ALPH from group 2 to 1dest in block block-1, for a fee of 0.002ALPH.", text)
+
+	title, _ = chatMessage(event{Type: eventNodeOutOfSync, Endpoint: "http://alephium:12973"})
+	assert.Equal(t, "Node out of sync", title)
+	title, _ = chatMessage(event{Type: "unknown"})
+	assert.Empty(t, title)
+}
+
+func TestChatSinks(t *testing.T) {
+	requests := make(map[string]map[string]interface{})
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		var body map[string]interface{}
+		if json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests[r.URL.Path] = body
+	}))
+	defer server.Close()
+
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("discord", newChatSink(chatDiscord, chatConfig{URL: server.URL + "/discord", MaxPerMinute: 60}), nil)
+	n.addSink("slack", newChatSink(chatSlack, chatConfig{URL: server.URL + "/slack", MaxPerMinute: 60}), nil)
+	n.addSink("telegram", newChatSink(chatTelegram, chatConfig{BotToken: "123:abc", ChatID: "-100",
+		APIURL: server.URL + "/", MaxPerMinute: 60}), nil)
+	n.notify(event{Type: eventTransferFailed, Wallet: "mining", Error: "balance < fees"})
+	n.Close(5 * time.Second)
+
+	assert.Equal(t, map[string]interface{}{"content": "**Transfer failed**\nTransfer from wallet mining failed: balance < fees."},
+		requests["/discord"])
+	assert.Equal(t, map[string]interface{}{"text": "*Transfer failed*\nTransfer from wallet mining failed: balance &lt; fees."},
+		requests["/slack"])
+	telegram := requests["/bot123:abc/sendMessage"]
+	assert.Equal(t, "-100", telegram["chat_id"])
+	assert.Equal(t, "HTML", telegram["parse_mode"])
+	assert.Equal(t, "<b>Transfer failed</b>\nTransfer from wallet mining failed: balance &lt; fees.", telegram["text"])
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(600)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, limiter.wait(context.Background()))
+	}
+	// The first message goes right away, the next ones 100ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotNil(t, limiter.wait(ctx))
+}
+
+func TestEarningsSummary(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25")
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	sink := &recordingSink{}
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("recording", sink, []eventType{eventEarningsSummary})
+	schedule, err := newTransferSchedule("", "UTC", time.Hour)
+	assert.Nil(t, err)
+	summarizer := newEarningsSummarizer(node.client(), "mining", schedule, nil, n, newTestLogger())
+	handler.summarizer = summarizer
+
+	log := logrus.NewEntry(handler.log)
+	reward, _ := ALPHFromALPHString("2.5")
+	summarizer.addBlock(minedBlock{Hash: "block-1", Time: time.Now().UTC(), Reward: reward})
+	assert.Nil(t, handler.transfer(context.Background(), log))
+	summarizer.notifySummary(context.Background(), time.Now().UTC(), log)
+	summarizer.notifySummary(context.Background(), time.Now().UTC(), log)
+	n.Close(5 * time.Second)
+
+	assert.Len(t, sink.events, 2)
+	summary := sink.events[0].Summary
+	assert.Equal(t, 1, summary.Blocks)
+	assert.Equal(t, "2500000000000000000", summary.Rewards.String())
+	assert.Equal(t, 1, summary.Transfers)
+	assert.Equal(t, 1, summary.Amount.Cmp(ALPH{Amount: new(big.Int)}))
+	assert.NotNil(t, summary.Balance)
+	// The second summary starts where the first one ends
+	assert.Equal(t, 0, sink.events[1].Summary.Blocks)
+	assert.Equal(t, 0, sink.events[1].Summary.Transfers)
+	assert.Equal(t, summary.To, sink.events[1].Summary.From)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/docker/distribution/health"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// companion manages a node and its mining wallet: the wallet and the miner addresses, the transfers
+// and the balance watcher. Several of them run side by side when a fleet is configured, each with
+// its own name.
+type companion struct {
+	name                string
+	env                 envConfig
+	alephiumClient      nodeClient
+	failoverClient      *failoverNodeClient
+	secrets             secretProvider
+	walletSecrets       envSecretProvider
+	miningHandler       *miningHandler
+	transferHandler     *transferHandler
+	addressBalanceStats *AddressBalanceStats
+	blockWatcher        *blockWatcher
+	ledger              *transferLedger
+	summarizer          *earningsSummarizer
+	metrics             *metrics
+	supervisor          *supervisor
+	log                 *logrus.Logger
+	// payout and minersAddresses make the addresses watched by addressBalanceStats, payout changing
+	// when the config is reloaded.
+	payout          payoutSpec
+	minersAddresses []string
+	addressesLock   *sync.Mutex
+}
+
+// newCompanion returns the companion of the node and wallet of env, the wallet secrets being read from
+// vault if not nil.
+func newCompanion(name string, env envConfig, vault *vaultClient, notifier *notifier, metrics *metrics,
+	log *logrus.Logger) (*companion, error) {
+	if env.WalletName == "" {
+		return nil, fmt.Errorf("some mandatory configuration parameters are missing, wallet name is required")
+	}
+	err := env.validateSecrets()
+	if err != nil {
+		return nil, err
+	}
+	walletSecrets := env.walletSecrets()
+	var secrets secretProvider = walletSecrets
+	if vault != nil {
+		secrets = newVaultSecretProvider(vault, env.VaultSecretPath, secrets)
+	}
+	var payout payoutSpec
+	if env.TransferAddress != "" {
+		payout, err = parsePayoutSpec(env.TransferAddress)
+		if err != nil {
+			return nil, fmt.Errorf("transfer address %s is not valid: %w", env.TransferAddress, err)
+		}
+	}
+	transferSchedule, err := newTransferSchedule(env.TransferSchedule, env.TransferScheduleTimezone, env.TransferFrequency)
+	if err != nil {
+		return nil, fmt.Errorf("the transfer schedule is not valid: %w", err)
+	}
+	if env.TransferCatchUp && env.LedgerPath == "" {
+		log.Warnf("TRANSFER_CATCH_UP requires LEDGER_PATH to remember the last transfer run, missed runs won't be caught up.")
+	}
+
+	var summarySchedule cron.Schedule
+	if notifier != nil && env.EarningsSummarySchedule != "" {
+		summarySchedule, err = newTransferSchedule(env.EarningsSummarySchedule, env.TransferScheduleTimezone, 0)
+		if err != nil {
+			return nil, fmt.Errorf("the earnings summary schedule is not valid: %w", err)
+		}
+	}
+
+	expectedHashrate := 0.0
+	if env.ExpectedHashrate != "" {
+		expectedHashrate, err = parseHashrate(env.ExpectedHashrate)
+		if err != nil {
+			return nil, fmt.Errorf("expected hashrate %s is not valid: %w", env.ExpectedHashrate, err)
+		}
+	}
+
+	alephiumClient, failoverClient, err := newNodeClients(env.AlephiumEndpoint, env.apiKeySecret(),
+		log.Level >= logrus.TraceLevel, metrics, log)
+	if err != nil {
+		return nil, fmt.Errorf("alephium endpoint %s is not valid: %w", env.AlephiumEndpoint, err)
+	}
+
+	miningHandler, err := newMiningHandler(alephiumClient, env.WalletName, secrets, env.PrintMnemonic, notifier, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the wallet handler: %w", err)
+	}
+
+	var ledger *transferLedger
+	if env.LedgerPath != "" {
+		// Already validated with the transfer schedule
+		location, _ := time.LoadLocation(env.TransferScheduleTimezone)
+		ledger, err = newTransferLedger(env.LedgerPath, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var summarizer *earningsSummarizer
+	if summarySchedule != nil {
+		summarizer = newEarningsSummarizer(alephiumClient, env.WalletName, summarySchedule, ledger, notifier, log)
+	}
+	addressBalanceStats, _ := newAddressBalanceStats(alephiumClient, payout.addresses(), metrics)
+	blockWatcher := newBlockWatcher(alephiumClient, env.BlockWatchInterval, env.HashrateWindow, expectedHashrate,
+		env.NoBlockAlertMultiple, ledger, summarizer, metrics, notifier, log)
+
+	// The secrets are only kept in their secret from now on
+	env.clearSecrets()
+	c := &companion{
+		name:                name,
+		env:                 env,
+		alephiumClient:      alephiumClient,
+		failoverClient:      failoverClient,
+		secrets:             secrets,
+		walletSecrets:       walletSecrets,
+		miningHandler:       miningHandler,
+		addressBalanceStats: addressBalanceStats,
+		blockWatcher:        blockWatcher,
+		ledger:              ledger,
+		summarizer:          summarizer,
+		metrics:             metrics,
+		supervisor:          newSupervisor(env.RestartInitialBackoff, env.RestartMaxBackoff, metrics, log),
+		log:                 log,
+		payout:              payout,
+		addressesLock:       &sync.Mutex{},
+	}
+
+	if env.TransferAddress != "" {
+		c.transferHandler, err = newTransferHandler(alephiumClient, env.WalletName, secrets, payout, env.TransferMinAmount, env.TransferKeepReserve,
+			env.TransferKeepReserveScope, transferSchedule, env.TransferCatchUp, env.ImmediateTransfer,
+			env.DryRun, env.TransferAddressCheckNode, env.ShutdownGracePeriod, metrics, c.ledger, summarizer, notifier, log)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to create the transfer handler: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// qualify prefixes name, i.e. of a loop or a health check, with the name of the companion in a fleet.
+func (c *companion) qualify(name string) string {
+	if c.name == "" {
+		return name
+	}
+	return c.name + "/" + name
+}
+
+// start makes sure the mining wallet exists and is unlocked, that the node mines to its addresses
+// and that the node is in sync, returning the miner addresses.
+func (c *companion) start(ctx context.Context) (*alephium.MinerAddresses, error) {
+	log := logrus.NewEntry(c.log)
+	if c.name != "" {
+		log = log.WithField("node", c.name)
+	}
+
+	// The password kept in vault is only known now
+	err := checkWalletPassword(ctx, c.secrets, c.env.AllowDefaultWalletPassword)
+	if err != nil {
+		var vaultErr *vaultError
+		if !errors.As(err, &vaultErr) {
+			err = fatal(err)
+		}
+		c.log.WithError(err).Debugf("Got an error while checking the password of wallet %s", c.env.WalletName)
+		return nil, err
+	}
+
+	wallet, err := c.miningHandler.createAndUnlockWallet(ctx, log)
+	if err != nil {
+		c.log.WithError(err).Debugf("Got an error while creating and/or unlocking the wallet %s", c.env.WalletName)
+		return nil, err
+	}
+
+	err = c.miningHandler.updateMinersAddresses(ctx, log)
+	if err != nil {
+		c.log.WithError(err).Debugf("Got an error while updating miners addresses")
+		return nil, err
+	}
+
+	minersAddresses, err := c.alephiumClient.GetMinersAddresses(ctx)
+	if err != nil {
+		c.log.WithError(err).Debugf("Got an error calling miners addresses")
+		return nil, err
+	}
+	log.Infof("Mining wallet %s (with addresses %v) is ready to be used, now waiting for the node to become in sync if needed.",
+		wallet.WalletName, minersAddresses.Addresses)
+
+	err = c.miningHandler.waitForNodeInSync(ctx, log)
+	if err != nil {
+		c.log.WithError(err).Debugf("Got an error while waiting for the node to be in sync with peers")
+		return nil, err
+	}
+	return minersAddresses, nil
+}
+
+// run starts the background loops of the companion with runLoop, once started.
+func (c *companion) run(minersAddresses *alephium.MinerAddresses, healthChecks *healthChecks, mux *http.ServeMux,
+	runLoop func(s *supervisor, name string, loop func(ctx context.Context) error)) {
+
+	log := logrus.NewEntry(c.log)
+	if c.name != "" {
+		log = log.WithField("node", c.name)
+	}
+
+	runLoop(c.supervisor, c.qualify("mining"), func(ctx context.Context) error {
+		return c.miningHandler.ensureMiningWalletAndNodeMining(ctx, log)
+	})
+
+	c.addressesLock.Lock()
+	c.minersAddresses = minersAddresses.Addresses
+	c.addressBalanceStats.setAddresses(c.watchedAddresses())
+	payout := c.payout
+	c.addressesLock.Unlock()
+	runLoop(c.supervisor, c.qualify("address-balance-stats"), c.addressBalanceStats.Stats)
+	c.blockWatcher.setMinerAddresses(minersAddresses.Addresses)
+	runLoop(c.supervisor, c.qualify("block-watcher"), c.blockWatcher.watch)
+	if c.ledger != nil {
+		if c.name == "" {
+			mux.HandleFunc("/earnings", c.ledger.earningsHandler)
+		} else {
+			mux.HandleFunc("/earnings/"+c.name, c.ledger.earningsHandler)
+		}
+	}
+	if c.blockWatcher.expectedHashrate > 0 {
+		healthChecks.registerReadiness(c.qualify("blocks-mined"), health.CheckFunc(c.blockWatcher.checkBlocksMined))
+	}
+	if c.summarizer != nil {
+		runLoop(c.supervisor, c.qualify("earnings-summary"), func(ctx context.Context) error {
+			return c.summarizer.run(ctx, log)
+		})
+	}
+
+	if c.transferHandler == nil {
+		return
+	}
+	if c.env.TransferSchedule != "" {
+		log.Infof("We will transfer to %s the mining reward on schedule %s (%s).", payout, c.env.TransferSchedule,
+			c.env.TransferScheduleTimezone)
+	} else {
+		log.Infof("We will transfer to %s the mining reward every %s.", payout, c.env.TransferFrequency)
+	}
+	if c.env.DryRun {
+		log.Warnf("Dry run mode enabled, the transfers are built and logged but never signed nor submitted.")
+	}
+	if c.name == "" {
+		mux.HandleFunc("/transfer/next", c.transferHandler.nextRunHandler)
+	} else {
+		mux.HandleFunc("/transfer/next/"+c.name, c.transferHandler.nextRunHandler)
+	}
+	healthChecks.registerLiveness(c.qualify("transfer-loop"), health.CheckFunc(c.transferHandler.checkLiveness))
+
+	runLoop(c.supervisor, c.qualify("transfer"), func(ctx context.Context) error {
+		return c.transferHandler.handle(ctx, log)
+	})
+}
+
+// watchedAddresses returns the miner addresses and the payout addresses, addressesLock being held.
+func (c *companion) watchedAddresses() []string {
+	addresses := make([]string, 0, len(c.minersAddresses)+len(c.payout))
+	addresses = append(addresses, c.minersAddresses...)
+	return append(addresses, c.payout.addresses()...)
+}
+
+// reconfigure applies the transfer and balance watcher settings of env, the other settings requiring
+// a restart. Transfers can't be enabled nor disabled without a restart either.
+func (c *companion) reconfigure(env envConfig) error {
+	log := logrus.NewEntry(c.log)
+	if c.name != "" {
+		log = log.WithField("node", c.name)
+	}
+	if env.TransferAddress == "" {
+		if c.transferHandler != nil {
+			log.Warnf("TRANSFER_ADDRESS is no longer set, restart the companion to disable the transfers.")
+		}
+		return nil
+	}
+	if c.transferHandler == nil {
+		log.Warnf("TRANSFER_ADDRESS is now set, restart the companion to enable the transfers.")
+		return nil
+	}
+
+	payout, err := parsePayoutSpec(env.TransferAddress)
+	if err != nil {
+		return fmt.Errorf("transfer address %s is not valid: %w", env.TransferAddress, err)
+	}
+	schedule, err := newTransferSchedule(env.TransferSchedule, env.TransferScheduleTimezone, env.TransferFrequency)
+	if err != nil {
+		return fmt.Errorf("the transfer schedule is not valid: %w", err)
+	}
+	if c.transferHandler.isRunning() {
+		log.Infof("A transfer run is in progress, the new transfer settings apply once it's done.")
+	}
+	err = c.transferHandler.reconfigure(payout, env.TransferMinAmount, env.TransferKeepReserve,
+		env.TransferKeepReserveScope, schedule)
+	if err != nil {
+		return err
+	}
+
+	c.addressesLock.Lock()
+	c.payout = payout
+	c.addressBalanceStats.setAddresses(c.watchedAddresses())
+	c.addressesLock.Unlock()
+	log.Infof("Transfer settings reloaded, transferring to %s.", payout)
+	return nil
+}
+
+func (c *companion) Close() error {
+	c.walletSecrets.wipe()
+	if c.ledger == nil {
+		return nil
+	}
+	return c.ledger.Close()
+}
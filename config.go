@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/sirupsen/logrus"
+	"github.com/sqooba/go-common/logging"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configReloadDelay groups the events of a config file being written in several steps in a single reload.
+const configReloadDelay = time.Second
+
+// reloadableSettings are the settings applied when the config file is reloaded, the other ones
+// requiring a restart. TRANSFER_ADDRESS is applied when changed only, setting or unsetting it to enable
+// or disable the transfers requiring a restart as well.
+var reloadableSettings = map[string]bool{
+	"LOG_LEVEL":                   true,
+	"TRANSFER_ADDRESS":            true,
+	"TRANSFER_MIN_AMOUNT":         true,
+	"TRANSFER_KEEP_RESERVE":       true,
+	"TRANSFER_KEEP_RESERVE_SCOPE": true,
+	"TRANSFER_FREQUENCY":          true,
+	"TRANSFER_SCHEDULE":           true,
+	"TRANSFER_SCHEDULE_TIMEZONE":  true,
+}
+
+// loadEnvConfig reads the settings from the env vars and, if CONFIG_FILE is set, from the config file,
+// the env vars overriding the config file.
+func loadEnvConfig() (envConfig, error) {
+	var env envConfig
+	err := envconfig.Process("", &env)
+	if err != nil {
+		return env, err
+	}
+	if env.ConfigFile == "" {
+		return env, nil
+	}
+
+	config := env
+	err = readConfigFile(env.ConfigFile, &config)
+	if err != nil {
+		return env, err
+	}
+	envValue := reflect.ValueOf(env)
+	configValue := reflect.ValueOf(&config).Elem()
+	for i := 0; i < envValue.NumField(); i++ {
+		if _, ok := os.LookupEnv(envValue.Type().Field(i).Tag.Get("envconfig")); ok {
+			configValue.Field(i).Set(envValue.Field(i))
+		}
+	}
+	return config, nil
+}
+
+// readConfigFile sets the settings of the yaml config file at path on env. The keys are the names of
+// the env vars in lower case, i.e. transfer_address, unknown keys being rejected.
+func readConfigFile(path string, env *envConfig) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]yaml.Node
+	err = yaml.Unmarshal(content, &settings)
+	if err != nil {
+		return fmt.Errorf("config file %s is not valid: %w", path, err)
+	}
+
+	fields := make(map[string]reflect.Value)
+	value := reflect.ValueOf(env).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("envconfig")
+		if name != "" && name != "CONFIG_FILE" {
+			fields[strings.ToLower(name)] = value.Field(i)
+		}
+	}
+	for key, node := range settings {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %s", path, key)
+		}
+		err = node.Decode(field.Addr().Interface())
+		if err != nil {
+			return fmt.Errorf("config file %s: setting %s is not valid: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// validate checks all the settings, returning all the invalid ones at once.
+func (env envConfig) validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if env.WalletName == "" {
+		check(fmt.Errorf("WALLET_NAME is mandatory"))
+	}
+	check(env.validateSecrets())
+	check(env.validateVault())
+	check(env.validateSmtp())
+	for _, endpoint := range strings.Split(env.AlephiumEndpoint, ",") {
+		check(parseHTTPURL("ALEPHIUM_ENDPOINT", strings.TrimSpace(endpoint)))
+	}
+	_, err := logrus.ParseLevel(env.LogLevel)
+	check(err)
+	if env.TransferAddress != "" {
+		payout, err := parsePayoutSpec(env.TransferAddress)
+		if err == nil {
+			err = payout.validate()
+		}
+		if err != nil {
+			check(fmt.Errorf("TRANSFER_ADDRESS is not valid: %w", err))
+		}
+	}
+	_, _, err = parseTransferAmounts(env.TransferMinAmount, env.TransferKeepReserve, env.TransferKeepReserveScope)
+	check(err)
+	_, err = newTransferSchedule(env.TransferSchedule, env.TransferScheduleTimezone, env.TransferFrequency)
+	check(err)
+	if env.EarningsSummarySchedule != "" {
+		_, err = newTransferSchedule(env.EarningsSummarySchedule, env.TransferScheduleTimezone, 0)
+		if err != nil {
+			check(fmt.Errorf("EARNINGS_SUMMARY_SCHEDULE is not valid: %w", err))
+		}
+	}
+
+	if env.ExpectedHashrate != "" {
+		_, err = parseHashrate(env.ExpectedHashrate)
+		if err != nil {
+			check(fmt.Errorf("EXPECTED_HASHRATE is not valid: %w", err))
+		}
+	}
+	if env.NoBlockAlertMultiple < 1 {
+		check(fmt.Errorf("NO_BLOCK_ALERT_MULTIPLE %g must be at least 1", env.NoBlockAlertMultiple))
+	}
+
+	positive := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"NODE_PROBE_INTERVAL", env.NodeProbeInterval},
+		{"HEALTH_CHECK_PERIOD", env.HealthCheckPeriod},
+		{"BLOCK_WATCH_INTERVAL", env.BlockWatchInterval},
+		{"HASHRATE_WINDOW", env.HashrateWindow},
+		{"RESTART_INITIAL_BACKOFF", env.RestartInitialBackoff},
+		{"NOTIFICATION_RETRY_BACKOFF", env.NotificationRetryBackoff},
+	}
+	for _, setting := range positive {
+		if setting.duration <= 0 {
+			check(fmt.Errorf("%s %s must be positive", setting.name, setting.duration))
+		}
+	}
+	if env.RestartMaxBackoff < env.RestartInitialBackoff {
+		check(fmt.Errorf("RESTART_MAX_BACKOFF %s must not be lower than RESTART_INITIAL_BACKOFF %s",
+			env.RestartMaxBackoff, env.RestartInitialBackoff))
+	}
+	if env.NotificationRetries < 0 {
+		check(fmt.Errorf("NOTIFICATION_RETRIES %d must not be negative", env.NotificationRetries))
+	}
+	if env.ShutdownGracePeriod < 0 {
+		check(fmt.Errorf("SHUTDOWN_GRACE_PERIOD %s must not be negative", env.ShutdownGracePeriod))
+	}
+	return errors.Join(errs...)
+}
+
+// parseHTTPURL checks that raw, the value of the setting name, is an absolute http(s) url.
+func parseHTTPURL(name string, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %s is not an http(s) url", name, raw)
+	}
+	return nil
+}
+
+// changedSettings returns the names of the settings which differ between env and other.
+func (env envConfig) changedSettings(other envConfig) []string {
+	changed := make([]string, 0)
+	envValue := reflect.ValueOf(env)
+	otherValue := reflect.ValueOf(other)
+	for i := 0; i < envValue.NumField(); i++ {
+		if !reflect.DeepEqual(envValue.Field(i).Interface(), otherValue.Field(i).Interface()) {
+			changed = append(changed, envValue.Type().Field(i).Tag.Get("envconfig"))
+		}
+	}
+	return changed
+}
+
+// reloadConfig reads the settings again, along with the fleet config if any, and applies the reloadable ones
+// to the companions, one per node of the fleet, returning the new settings without their secrets. Nothing is
+// applied if the new settings are not valid.
+func reloadConfig(current envConfig, companions []*companion, log *logrus.Logger) (envConfig, error) {
+	env, err := loadEnvConfig()
+	if err != nil {
+		return current, err
+	}
+	envs := []envConfig{env}
+	if current.FleetConfig != "" {
+		// The fleet config is read again as its secrets are not kept
+		fleet, err := loadFleetConfig(current.FleetConfig)
+		if err != nil {
+			return current, err
+		}
+		names := make([]string, 0, len(companions))
+		for _, c := range companions {
+			names = append(names, c.name)
+		}
+		if !slices.Equal(names, fleet.names()) {
+			return current, fmt.Errorf("the nodes of fleet config %s changed, restart the companion to apply it",
+				current.FleetConfig)
+		}
+		envs, err = fleet.envConfigs(env)
+		if err != nil {
+			return current, err
+		}
+	}
+	for _, memberEnv := range envs {
+		err = memberEnv.validate()
+		if err != nil {
+			return current, err
+		}
+	}
+	env.clearSecrets()
+
+	for _, name := range current.changedSettings(env) {
+		if !reloadableSettings[name] {
+			log.Warnf("Setting %s changed, restart the companion to apply it.", name)
+		}
+	}
+	err = logging.SetLogLevel(log, env.LogLevel)
+	if err != nil {
+		return current, err
+	}
+	// The settings of a companion which can't be applied are kept, and so the current config to compare the
+	// next reload with
+	var errs []error
+	for i, c := range companions {
+		err = c.reconfigure(envs[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("the new transfer settings of wallet %s can't be applied: %w",
+				c.env.WalletName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return current, errors.Join(errs...)
+	}
+	return env, nil
+}
+
+// watchConfigFile calls reload when the config file at path changes or on SIGHUP, until ctx is done.
+// The directory of the file is watched, so that the file can be replaced, i.e. by kubernetes when
+// it comes from a config map.
+func watchConfigFile(ctx context.Context, path string, reload func(), log *logrus.Logger) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(path))
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+	if err != nil {
+		log.WithError(err).Warnf("Can't watch config file %s, reload it with SIGHUP instead", path)
+	}
+
+	var changed <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Infof("SIGHUP received, reloading config file %s", path)
+			reload()
+		case event := <-events:
+			if filepath.Clean(event.Name) == filepath.Clean(path) || filepath.Base(event.Name) == "..data" {
+				changed = time.After(configReloadDelay)
+			}
+		case err := <-errs:
+			log.WithError(err).Warnf("Got an error while watching config file %s", path)
+		case <-changed:
+			changed = nil
+			log.Infof("Config file %s changed, reloading it", path)
+			reload()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func TestLoadEnvConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+wallet_name: mining-1
+transfer_address: 1dest
+transfer_frequency: 1h
+transfer_min_amount: 5000000000000000000
+dry_run: true
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TRANSFER_FREQUENCY", "2h")
+
+	env, err := loadEnvConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "mining-1", env.WalletName)
+	assert.Equal(t, "1dest", env.TransferAddress)
+	assert.Equal(t, "5000000000000000000", env.TransferMinAmount)
+	assert.True(t, env.DryRun)
+	// The env vars override the config file, the defaults apply to the settings set nowhere
+	assert.Equal(t, 2*time.Hour, env.TransferFrequency)
+	assert.Equal(t, "address", env.TransferKeepReserveScope)
+
+	other := env
+	other.WalletName = "mining"
+	other.DryRun = false
+	assert.Equal(t, []string{"WALLET_NAME", "DRY_RUN"}, env.changedSettings(other))
+
+	for _, content := range []string{
+		"unknown_setting: 1",
+		"config_file: other.yaml",
+		"transfer_frequency: often",
+		"transfer_address: [a, b]",
+	} {
+		writeConfigFile(t, path, content)
+		_, err = loadEnvConfig()
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestValidateEnvConfig(t *testing.T) {
+	env, err := loadEnvConfig()
+	assert.Nil(t, err)
+	env.TransferAddress = testAddress1 + ":70," + testAddress2 + ":30"
+	// The default wallet password is refused unless explicitly allowed
+	assert.NotNil(t, env.validate())
+	env.AllowDefaultWalletPassword = true
+	assert.Nil(t, env.validate())
+
+	env.AlephiumEndpoint = "http://node-1:12973,node-2"
+	env.TransferAddress = testAddress1 + ":70,1dest:30"
+	env.TransferKeepReserve = "-1"
+	env.TransferSchedule = "0 2 * *"
+	env.RestartMaxBackoff = time.Second
+	env.WalletPasswordFile = filepath.Join(t.TempDir(), "missing")
+	env.ExpectedHashrate = "fast"
+	env.NoBlockAlertMultiple = 0.5
+	err = env.validate()
+	assert.NotNil(t, err)
+	for _, setting := range []string{"ALEPHIUM_ENDPOINT", "WALLET_PASSWORD", "TRANSFER_ADDRESS", "keepReserve", "schedule",
+		"RESTART_MAX_BACKOFF", "EXPECTED_HASHRATE", "NO_BLOCK_ALERT_MULTIPLE"} {
+		assert.Contains(t, err.Error(), setting)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "transfer_frequency: 1h")
+	reloaded := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfigFile(ctx, path, func() { reloaded <- struct{}{} }, newTestLogger())
+
+	// Give the watcher some time to start
+	time.Sleep(100 * time.Millisecond)
+	writeConfigFile(t, path, "transfer_frequency: 2h")
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config file not reloaded after it changed")
+	}
+}
+
+func TestReloadConfigFleet(t *testing.T) {
+	nodes := []*fakeNode{newFakeNode(t), newFakeNode(t)}
+	fleetPath := writeFleetConfig(t, fmt.Sprintf(`{"nodes": [
+		{"name": "node-1", "alephiumEndpoint": %q, "walletName": "mining", "walletPassword": "secret-1"},
+		{"name": "node-2", "alephiumEndpoint": %q, "walletName": "mining", "walletPassword": "secret-2"}
+	]}`, nodes[0].server.URL, nodes[1].server.URL))
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "fleet_config: "+fleetPath+"\ntransfer_address: "+testAddress1+"\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	env, err := loadEnvConfig()
+	assert.Nil(t, err)
+	fleet, err := loadFleetConfig(env.FleetConfig)
+	assert.Nil(t, err)
+	envs, err := fleet.envConfigs(env)
+	assert.Nil(t, err)
+	companions := make([]*companion, 0, len(envs))
+	for i, memberEnv := range envs {
+		c, err := newCompanion(fleet.names()[i], memberEnv, nil, nil, newTestMetrics(), newTestLogger())
+		assert.Nil(t, err)
+		// The secrets are only kept in their secret
+		assert.Empty(t, c.env.WalletPassword)
+		password, err := c.secrets.secret(context.Background(), walletPasswordSecret)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("secret-%d", i+1), string(password))
+		companions = append(companions, c)
+	}
+	env.clearSecrets()
+
+	// The members keep their own password, read again from the fleet config
+	writeConfigFile(t, path, "fleet_config: "+fleetPath+"\ntransfer_address: "+testAddress2+"\n")
+	reloaded, err := reloadConfig(env, companions, newTestLogger())
+	assert.Nil(t, err)
+	assert.Empty(t, reloaded.WalletPassword)
+	for _, c := range companions {
+		assert.Equal(t, []string{testAddress2}, c.transferHandler.settings.payout.addresses())
+	}
+
+	writeConfigFile(t, fleetPath, fmt.Sprintf(`{"nodes": [
+		{"name": "node-1", "alephiumEndpoint": %q, "walletName": "mining", "walletPassword": "secret-1"}
+	]}`, nodes[0].server.URL))
+	_, err = reloadConfig(reloaded, companions, newTestLogger())
+	assert.NotNil(t, err)
+
+	for _, c := range companions {
+		assert.Nil(t, c.Close())
+		password, err := c.secrets.secret(context.Background(), walletPasswordSecret)
+		assert.Nil(t, err)
+		assert.Equal(t, make([]byte, len("secret-1")), password)
+	}
+}
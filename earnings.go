@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// earningsPeriod is the length of the periods the earnings are summed up over.
+type earningsPeriod string
+
+const (
+	earningsDaily   earningsPeriod = "daily"
+	earningsWeekly  earningsPeriod = "weekly"
+	earningsMonthly earningsPeriod = "monthly"
+)
+
+var earningsPeriods = []earningsPeriod{earningsDaily, earningsWeekly, earningsMonthly}
+
+var (
+	earningsBucket     = []byte("earnings")
+	earnedBlocksBucket = []byte("earnedBlocks")
+	// earningsSummaryKey and earningsReportKey are the keys of the earnings since the last earnings summary
+	// and since the last email report, outside of the periods.
+	earningsSummaryKey = []byte("summary")
+	earningsReportKey  = []byte("report")
+)
+
+// earningsSinceKeys are the keys of the earnings since the last time they were taken.
+var earningsSinceKeys = [][]byte{earningsSummaryKey, earningsReportKey}
+
+// earnings is what the node earned over a period: the coinbase rewards of the blocks mined to the miner
+// addresses, and the sweeps confirmed along with the fees they paid.
+type earnings struct {
+	Period    earningsPeriod `json:"period"`
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	Blocks    int            `json:"blocks"`
+	Rewards   ALPH           `json:"rewards"`
+	Transfers int            `json:"transfers"`
+	Swept     ALPH           `json:"swept"`
+	Fees      ALPH           `json:"fees"`
+	// Net is the rewards minus the fees.
+	Net ALPH `json:"net"`
+}
+
+func newEarnings(period earningsPeriod, start time.Time, end time.Time) *earnings {
+	return &earnings{Period: period, Start: start, End: end, Rewards: ALPH{Amount: new(big.Int)},
+		Swept: ALPH{Amount: new(big.Int)}, Fees: ALPH{Amount: new(big.Int)}, Net: ALPH{Amount: new(big.Int)}}
+}
+
+// periodBounds returns the start and end of the period of t in location, the weeks starting on Monday.
+func periodBounds(period earningsPeriod, t time.Time, location *time.Location) (time.Time, time.Time) {
+	t = t.In(location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	switch period {
+	case earningsWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case earningsMonthly:
+		start := day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// earningsKey is the key of the earnings of a period, sorting them by start within the period.
+func earningsKey(period earningsPeriod, start time.Time) []byte {
+	return []byte(string(period) + "/" + start.UTC().Format(time.RFC3339))
+}
+
+// addEarnings adds to the earnings of the periods of at and to the earnings since the last summary and the
+// last report, within the ledger tx.
+func (l *transferLedger) addEarnings(tx *bolt.Tx, at time.Time, add func(e *earnings)) error {
+	bucket := tx.Bucket(earningsBucket)
+	for _, period := range earningsPeriods {
+		start, end := periodBounds(period, at, l.location)
+		err := updateEarnings(bucket, earningsKey(period, start), newEarnings(period, start, end), add)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range earningsSinceKeys {
+		err := updateEarnings(bucket, key, newEarnings("", time.Now().UTC(), time.Time{}), add)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateEarnings adds to the earnings saved at key in bucket, e being the earnings if none are saved yet.
+func updateEarnings(bucket *bolt.Bucket, key []byte, e *earnings, add func(e *earnings)) error {
+	if value := bucket.Get(key); value != nil {
+		err := json.Unmarshal(value, e)
+		if err != nil {
+			return fmt.Errorf("earnings %s are not valid: %w", key, err)
+		}
+	}
+	add(e)
+	e.Net = e.Rewards.Subtract(e.Fees)
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+// takeEarningsSince returns the earnings saved at key, one of earningsSinceKeys, since they were last taken,
+// or since the ledger was created, until now, and starts the next ones at now.
+func (l *transferLedger) takeEarningsSince(key []byte, now time.Time) (earnings, error) {
+	since := newEarnings("", now, now)
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(earningsBucket)
+		if value := bucket.Get(key); value != nil {
+			err := json.Unmarshal(value, since)
+			if err != nil {
+				return fmt.Errorf("earnings %s are not valid: %w", key, err)
+			}
+		}
+		since.End = now
+		value, err := json.Marshal(newEarnings("", now, time.Time{}))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+	return *since, err
+}
+
+// recordBlock adds the reward of a block mined to the miner addresses to the earnings, once.
+func (l *transferLedger) recordBlock(block minedBlock) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket(earnedBlocksBucket)
+		if blocks.Get([]byte(block.Hash)) != nil {
+			return nil
+		}
+		err := blocks.Put([]byte(block.Hash), []byte(block.Time.UTC().Format(time.RFC3339)))
+		if err != nil {
+			return err
+		}
+		return l.addEarnings(tx, block.Time, func(e *earnings) {
+			e.Blocks++
+			e.Rewards = e.Rewards.Add(block.Reward)
+		})
+	})
+}
+
+// earnings returns the earnings of the periods starting between from and to, oldest first. A zero from
+// or to doesn't bound the periods.
+func (l *transferLedger) earnings(period earningsPeriod, from time.Time, to time.Time) ([]earnings, error) {
+	list := make([]earnings, 0)
+	prefix := []byte(string(period) + "/")
+	err := l.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(earningsBucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, value = cursor.Next() {
+			var e earnings
+			err := json.Unmarshal(value, &e)
+			if err != nil {
+				return fmt.Errorf("earnings %s are not valid: %w", key, err)
+			}
+			if (!from.IsZero() && e.Start.Before(from)) || (!to.IsZero() && !e.Start.Before(to)) {
+				continue
+			}
+			e.Start, e.End = e.Start.In(l.location), e.End.In(l.location)
+			list = append(list, e)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// earningsHandler serves the earnings of ?period=daily (the default), weekly or monthly, as JSON or as CSV
+// with ?format=csv, optionally limited to the periods starting from and before ?from= and ?to= dates.
+func (l *transferLedger) earningsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	period := earningsPeriod(query.Get("period"))
+	if period == "" {
+		period = earningsDaily
+	}
+	valid := false
+	for _, p := range earningsPeriods {
+		valid = valid || p == period
+	}
+	if !valid {
+		writeJSONError(w, http.StatusBadRequest, "period must be daily, weekly or monthly")
+		return
+	}
+	bounds := make([]time.Time, 2)
+	for i, name := range []string{"from", "to"} {
+		if query.Get(name) == "" {
+			continue
+		}
+		date, err := time.ParseInLocation(time.DateOnly, query.Get(name), l.location)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, name+" must be a date, i.e. 2024-01-31")
+			return
+		}
+		bounds[i] = date
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	list, err := l.earnings(period, bounds[0], bounds[1])
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, map[string][]earnings{"earnings": list})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=earnings-%s.csv", period))
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"period", "start", "end", "blocks", "rewards", "transfers", "swept", "fees", "net"})
+	for _, e := range list {
+		writer.Write([]string{string(e.Period), e.Start.Format(time.RFC3339), e.End.Format(time.RFC3339),
+			strconv.Itoa(e.Blocks), e.Rewards.DecimalString(), strconv.Itoa(e.Transfers), e.Swept.DecimalString(),
+			e.Fees.DecimalString(), e.Net.DecimalString()})
+	}
+	writer.Flush()
+}
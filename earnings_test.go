@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodBounds(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	// Sunday evening in location, Monday already in UTC
+	at := time.Date(2026, time.October, 18, 23, 30, 0, 0, location)
+	for period, expected := range map[earningsPeriod][2]time.Time{
+		earningsDaily: {time.Date(2026, time.October, 18, 0, 0, 0, 0, location),
+			time.Date(2026, time.October, 19, 0, 0, 0, 0, location)},
+		earningsWeekly: {time.Date(2026, time.October, 12, 0, 0, 0, 0, location),
+			time.Date(2026, time.October, 19, 0, 0, 0, 0, location)},
+		earningsMonthly: {time.Date(2026, time.October, 1, 0, 0, 0, 0, location),
+			time.Date(2026, time.November, 1, 0, 0, 0, 0, location)},
+	} {
+		start, end := periodBounds(period, at.UTC(), location)
+		assert.True(t, expected[0].Equal(start), string(period))
+		assert.True(t, expected[1].Equal(end), string(period))
+	}
+}
+
+func TestEarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	location := time.FixedZone("UTC+2", 2*60*60)
+	ledger, err := newTransferLedger(path, location)
+	assert.Nil(t, err)
+
+	day := time.Date(2026, time.October, 14, 12, 0, 0, 0, location)
+	reward, _ := ALPHFromALPHString("2.5")
+	block := minedBlock{Hash: "block-1", Time: day, Reward: reward}
+	assert.Nil(t, ledger.recordBlock(block))
+	// The blocks seen again after a restart are not counted twice
+	assert.Nil(t, ledger.recordBlock(block))
+	assert.Nil(t, ledger.recordBlock(minedBlock{Hash: "block-2", Time: day.AddDate(0, 0, 1), Reward: reward}))
+
+	amount, _ := ALPHFromALPHString("4.998")
+	fee, _ := ALPHFromALPHString("0.002")
+	record := transferRecord{TxId: "tx-1", Amount: amount, Fee: fee, SubmittedAt: day, Status: transferStatusSubmitted}
+	assert.Nil(t, ledger.save(record))
+	record.Status, record.ConfirmedAt = transferStatusConfirmed, day.Add(time.Minute)
+	assert.Nil(t, ledger.save(record))
+	assert.Nil(t, ledger.save(record))
+	assert.Nil(t, ledger.Close())
+
+	// The earnings survive a restart
+	ledger, err = newTransferLedger(path, location)
+	assert.Nil(t, err)
+	defer ledger.Close()
+	daily, err := ledger.earnings(earningsDaily, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Len(t, daily, 2)
+	assert.Equal(t, time.Date(2026, time.October, 14, 0, 0, 0, 0, location), daily[0].Start)
+	assert.Equal(t, 1, daily[0].Blocks)
+	assert.Equal(t, "2.5", daily[0].Rewards.DecimalString())
+	assert.Equal(t, 1, daily[0].Transfers)
+	assert.Equal(t, "4.998", daily[0].Swept.DecimalString())
+	assert.Equal(t, "2.498", daily[0].Net.DecimalString())
+	assert.Equal(t, 0, daily[1].Transfers)
+	weekly, err := ledger.earnings(earningsWeekly, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Len(t, weekly, 1)
+	assert.Equal(t, 2, weekly[0].Blocks)
+	assert.Equal(t, "5", weekly[0].Rewards.DecimalString())
+
+	recorder := httptest.NewRecorder()
+	ledger.earningsHandler(recorder, httptest.NewRequest(http.MethodGet, "/earnings?from=2026-10-15", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response map[string][]earnings
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Len(t, response["earnings"], 1)
+	assert.Equal(t, 0, response["earnings"][0].Rewards.Cmp(reward))
+
+	recorder = httptest.NewRecorder()
+	ledger.earningsHandler(recorder, httptest.NewRequest(http.MethodGet, "/earnings?period=monthly&format=csv", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/csv", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "period,start,end,blocks,rewards,transfers,swept,fees,net\n"+
+		"monthly,2026-10-01T00:00:00+02:00,2026-11-01T00:00:00+02:00,2,5,1,4.998,0.002,4.998\n", recorder.Body.String())
+
+	for _, query := range []string{"period=yearly", "format=xml", "from=yesterday"} {
+		recorder = httptest.NewRecorder()
+		ledger.earningsHandler(recorder, httptest.NewRequest(http.MethodGet, "/earnings?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json"), query)
+	}
+}
+
+func TestEarningsSummaryLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	ledger, err := newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+	created := time.Now().UTC()
+	schedule, err := newTransferSchedule("", "UTC", time.Hour)
+	assert.Nil(t, err)
+	summarizer := newEarningsSummarizer(nil, "mining", schedule, ledger, nil, newTestLogger())
+
+	reward, _ := ALPHFromALPHString("2.5")
+	block := minedBlock{Hash: "block-1", Time: created, Reward: reward}
+	// The ledger accounts the blocks and the transfers, not the summarizer
+	summarizer.addBlock(block)
+	assert.Nil(t, ledger.recordBlock(block))
+	amount, _ := ALPHFromALPHString("4.998")
+	fee, _ := ALPHFromALPHString("0.002")
+	record := transferRecord{TxId: "tx-1", Amount: amount, Fee: fee, SubmittedAt: created,
+		Status: transferStatusConfirmed, ConfirmedAt: created}
+	summarizer.addTransfer(&record)
+	assert.Nil(t, ledger.save(record))
+	assert.Nil(t, ledger.Close())
+
+	// The earnings since the last summary survive a restart
+	ledger, err = newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+	defer ledger.Close()
+	summarizer.ledger = ledger
+	now := created.Add(time.Hour)
+	summary, err := summarizer.takeSummary(now)
+	assert.Nil(t, err)
+	assert.False(t, summary.From.After(created))
+	assert.Equal(t, now, summary.To)
+	assert.Equal(t, 1, summary.Blocks)
+	assert.Equal(t, "2.5", summary.Rewards.DecimalString())
+	assert.Equal(t, 1, summary.Transfers)
+	assert.Equal(t, "4.998", summary.Amount.DecimalString())
+	assert.Equal(t, "0.002", summary.Fees.DecimalString())
+
+	summary, err = summarizer.takeSummary(now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, now, summary.From)
+	assert.Equal(t, 0, summary.Blocks)
+	assert.Equal(t, 0, summary.Transfers)
+}
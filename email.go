@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	htmltemplate "html/template"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// emailTimeout bounds the whole SMTP conversation of an email.
+const emailTimeout = 30 * time.Second
+
+// mailer sends emails through an SMTP server, upgrading the connection with STARTTLS unless disabled.
+type mailer struct {
+	host      string
+	port      int
+	username  string
+	password  *secret
+	from      string
+	to        []string
+	startTLS  bool
+	tlsConfig *tls.Config
+}
+
+func newMailer(host string, port int, username string, password *secret, from string, to []string,
+	startTLS bool) *mailer {
+	recipients := make([]string, 0, len(to))
+	for _, recipient := range to {
+		recipients = append(recipients, strings.TrimSpace(recipient))
+	}
+	return &mailer{
+		host:      host,
+		port:      port,
+		username:  username,
+		password:  password,
+		from:      from,
+		to:        recipients,
+		startTLS:  startTLS,
+		tlsConfig: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12},
+	}
+}
+
+// send sends an email with both a plain text and an html body, the mail clients showing the one they prefer.
+func (m *mailer) send(ctx context.Context, subject string, text string, html string) error {
+	message, err := m.message(subject, text, html)
+	if err != nil {
+		return permanent(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	address := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("can't connect to smtp server %s: %w", address, err)
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp server %s is not answering as expected: %w", address, err)
+	}
+	defer client.Close()
+
+	if m.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			// Not falling back to plain text, which would send the credentials and the report in clear
+			return permanent(fmt.Errorf("smtp server %s doesn't support STARTTLS, set SMTP_STARTTLS=false to "+
+				"send the emails unencrypted", address))
+		}
+		err = client.StartTLS(m.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("got an error while starting TLS with smtp server %s: %w", address, err)
+		}
+	}
+	if m.username != "" {
+		password, err := m.password.revealString()
+		if err != nil {
+			return err
+		}
+		err = client.Auth(smtp.PlainAuth("", m.username, password, m.host))
+		if err != nil {
+			return smtpError(fmt.Errorf("smtp server %s refused the credentials of %s: %w", address, m.username, err))
+		}
+	}
+	err = client.Mail(envelopeAddress(m.from))
+	if err != nil {
+		return smtpError(fmt.Errorf("smtp server %s refused sender %s: %w", address, m.from, err))
+	}
+	for _, to := range m.to {
+		err = client.Rcpt(envelopeAddress(to))
+		if err != nil {
+			return smtpError(fmt.Errorf("smtp server %s refused recipient %s: %w", address, to, err))
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	_, err = writer.Write(message)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return smtpError(fmt.Errorf("smtp server %s didn't accept the email: %w", address, err))
+	}
+	return client.Quit()
+}
+
+// envelopeAddress returns the bare address of an email address which may come with a display name,
+// i.e. "Mining <mining@example.org>", for the smtp envelope.
+func envelopeAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.Address
+}
+
+// smtpError makes the permanent failures of the smtp server, 5xx replies, permanent errors.
+func smtpError(err error) error {
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) && protocolErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
+
+// message returns the multipart/alternative email, the bodies being quoted-printable encoded.
+func (m *mailer) message(subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{{"text/plain; charset=utf-8", text}, {"text/html; charset=utf-8", html}} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		_, err = encoder.Write([]byte(part.content))
+		if err == nil {
+			err = encoder.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := parts.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", m.from},
+		{"To", strings.Join(m.to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, header := range headers {
+		if strings.ContainsAny(header[1], "\r\n") {
+			return nil, fmt.Errorf("email header %s can't span several lines", header[0])
+		}
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// nodeReport is the part of the email report about a node.
+type nodeReport struct {
+	Name      string
+	Balances  []addressBalance
+	Transfers int
+	Amount    ALPH
+	Fees      ALPH
+	Blocks    int
+	Rewards   ALPH
+}
+
+func newNodeReport(name string) *nodeReport {
+	return &nodeReport{Name: name, Amount: ALPH{Amount: new(big.Int)}, Fees: ALPH{Amount: new(big.Int)},
+		Rewards: ALPH{Amount: new(big.Int)}}
+}
+
+// emailReport is the periodic email report, i.e. weekly for the accounting.
+type emailReport struct {
+	From  time.Time
+	To    time.Time
+	Nodes []nodeReport
+}
+
+var emailReportText = template.Must(template.New("report").Parse(`Mining report from {{ .From.Format "2006-01-02 15:04 MST" }} to {{ .To.Format "2006-01-02 15:04 MST" }}
+{{ range .Nodes }}
+{{ .Name }}
+  Blocks mined: {{ .Blocks }}, rewarding {{ .Rewards.PrettyString }}
+  Sweeps: {{ .Transfers }}, {{ .Amount.PrettyString }} transferred for {{ .Fees.PrettyString }} of fees
+{{- range .Balances }}
+  {{ .Address }}: {{ .Balance.PrettyString }} ({{ .Locked.PrettyString }} locked)
+{{- end }}
+{{ end }}`))
+
+var emailReportHTML = htmltemplate.Must(htmltemplate.New("report").Parse(`<html><body>
+<h2>Mining report</h2>
+<p>From {{ .From.Format "2006-01-02 15:04 MST" }} to {{ .To.Format "2006-01-02 15:04 MST" }}</p>
+{{ range .Nodes }}<h3>{{ .Name }}</h3>
+<p>Blocks mined: {{ .Blocks }}, rewarding {{ .Rewards.PrettyString }}</p>
+<p>Sweeps: {{ .Transfers }}, {{ .Amount.PrettyString }} transferred for {{ .Fees.PrettyString }} of fees</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Address</th><th>Balance</th><th>Locked</th></tr>
+{{ range .Balances }}<tr><td><code>{{ .Address }}</code></td><td>{{ .Balance.PrettyString }}</td><td>{{ .Locked.PrettyString }}</td></tr>
+{{ end }}</table>
+{{ end }}</body></html>
+`))
+
+// emailReporter emails a report of the balances, the blocks mined, the sweeps and their fees on schedule,
+// and an alert for each failed transfer right away. It's the sink of the block and transfer events, which
+// it sums up per node, unless the node has a ledger which sums them up across the restarts.
+type emailReporter struct {
+	mailer   *mailer
+	schedule cron.Schedule
+	retries  int
+	backoff  time.Duration
+	// nodes lists the balance watcher of each node, by name, and endpoint names them in a single node setup.
+	nodes map[string]*AddressBalanceStats
+	// ledgers are the ledgers of the nodes having one, by name.
+	ledgers  map[string]*transferLedger
+	endpoint string
+	from     time.Time
+	periods  map[string]*nodeReport
+	lock     *sync.Mutex
+	log      *logrus.Logger
+}
+
+// emailReportEvents are the events an emailReporter is interested in.
+var emailReportEvents = []eventType{eventBlockMined, eventBlocksOverdue, eventTransferConfirmed, eventTransferFailed}
+
+// initEmailReporter returns the email reporter of the SMTP_* settings, nil if SMTP_HOST is not set.
+func initEmailReporter(env envConfig, log *logrus.Logger) (*emailReporter, error) {
+	if env.SmtpHost == "" {
+		return nil, nil
+	}
+	var schedule cron.Schedule
+	if env.EmailReportSchedule != "" {
+		var err error
+		schedule, err = newTransferSchedule(env.EmailReportSchedule, env.TransferScheduleTimezone, 0)
+		if err != nil {
+			return nil, fmt.Errorf("EMAIL_REPORT_SCHEDULE is not valid: %w", err)
+		}
+	}
+	m := newMailer(env.SmtpHost, env.SmtpPort, env.SmtpUsername, env.smtpPasswordSecret(), env.SmtpFrom, env.SmtpTo,
+		env.SmtpStartTLS)
+	return newEmailReporter(m, schedule, env.NotificationRetries, env.NotificationRetryBackoff, env.AlephiumEndpoint, log), nil
+}
+
+func newEmailReporter(mailer *mailer, schedule cron.Schedule, retries int, backoff time.Duration, endpoint string,
+	log *logrus.Logger) *emailReporter {
+	return &emailReporter{
+		mailer:   mailer,
+		schedule: schedule,
+		retries:  retries,
+		backoff:  backoff,
+		nodes:    make(map[string]*AddressBalanceStats),
+		ledgers:  make(map[string]*transferLedger),
+		endpoint: endpoint,
+		from:     time.Now().UTC(),
+		periods:  make(map[string]*nodeReport),
+		lock:     &sync.Mutex{},
+		log:      log,
+	}
+}
+
+// watch adds the balances of the node to the reports, and its earnings from its ledger if not nil.
+func (r *emailReporter) watch(node string, balances *AddressBalanceStats, ledger *transferLedger) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nodes[node] = balances
+	if ledger != nil {
+		r.ledgers[node] = ledger
+	}
+}
+
+func (r *emailReporter) deliver(ctx context.Context, e event) error {
+	switch e.Type {
+	case eventBlockMined:
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.ledgers[e.Node] != nil {
+			return nil
+		}
+		period := r.period(e.Node)
+		period.Blocks++
+		period.Rewards = period.Rewards.Add(e.Block.Reward)
+		return nil
+	case eventTransferConfirmed:
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.ledgers[e.Node] != nil {
+			return nil
+		}
+		period := r.period(e.Node)
+		period.Transfers++
+		period.Amount = period.Amount.Add(e.Transfer.Amount)
+		period.Fees = period.Fees.Add(e.Transfer.Fee)
+		return nil
+	case eventTransferFailed, eventBlocksOverdue:
+		title, text := chatMessage(e)
+		return r.mailer.send(ctx, "[Alephium mining] "+title, text+"\n",
+			"<html><body><p>"+htmltemplate.HTMLEscapeString(text)+"</p></body></html>\n")
+	}
+	return nil
+}
+
+// period returns what the node did in the current period, the lock being held.
+func (r *emailReporter) period(node string) *nodeReport {
+	period, ok := r.periods[node]
+	if !ok {
+		period = newNodeReport(node)
+		r.periods[node] = period
+	}
+	return period
+}
+
+// run emails the report on schedule until ctx is done.
+func (r *emailReporter) run(ctx context.Context) error {
+	for {
+		next := r.schedule.Next(time.Now())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+		r.sendReport(ctx, time.Now().UTC())
+	}
+}
+
+// takeReport returns the report of the period ending now and starts a new one. The period of the nodes
+// having a ledger starts with their previous report, which may be before a restart.
+func (r *emailReporter) takeReport(now time.Time) emailReport {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	report := emailReport{From: r.from, To: now}
+	for name, balances := range r.nodes {
+		node := *r.period(name)
+		if ledger, ok := r.ledgers[name]; ok {
+			e, err := ledger.takeEarningsSince(earningsReportKey, now)
+			if err != nil {
+				r.log.WithError(err).Errorf("Earnings of node %s can't be read from the ledger, they are missing "+
+					"from the email report", name)
+			} else {
+				node.Blocks, node.Rewards, node.Transfers, node.Amount, node.Fees = e.Blocks, e.Rewards, e.Transfers,
+					e.Swept, e.Fees
+				if e.Start.Before(report.From) {
+					report.From = e.Start
+				}
+			}
+		}
+		node.Balances = balances.getBalances()
+		if name == "" {
+			node.Name = r.endpoint
+		}
+		report.Nodes = append(report.Nodes, node)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].Name < report.Nodes[j].Name
+	})
+	r.from = now
+	r.periods = make(map[string]*nodeReport)
+	return report
+}
+
+// sendReport emails the report of the period ending now, retrying with exponential backoff.
+func (r *emailReporter) sendReport(ctx context.Context, now time.Time) {
+	report := r.takeReport(now)
+	var text, html bytes.Buffer
+	err := emailReportText.Execute(&text, report)
+	if err == nil {
+		err = emailReportHTML.Execute(&html, report)
+	}
+	if err != nil {
+		r.log.WithError(err).Errorf("Got an error while rendering the email report")
+		return
+	}
+
+	subject := fmt.Sprintf("[Alephium mining] Report from %s to %s", report.From.Format("2006-01-02"),
+		report.To.Format("2006-01-02"))
+	backoff := r.backoff
+	for attempt := 0; ; attempt++ {
+		err = r.mailer.send(ctx, subject, text.String(), html.String())
+		if err == nil {
+			r.log.Infof("Email report sent to %v", r.mailer.to)
+			return
+		}
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) || attempt >= r.retries {
+			r.log.WithError(err).Errorf("Email report couldn't be sent to %v", r.mailer.to)
+			return
+		}
+		r.log.WithError(err).Debugf("Got an error while sending the email report, retrying in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// validateSmtp checks the SMTP_* settings, if SMTP_HOST is set.
+func (env envConfig) validateSmtp() error {
+	if env.SmtpHost == "" {
+		return nil
+	}
+	if env.SmtpPort < 1 || env.SmtpPort > 65535 {
+		return fmt.Errorf("SMTP_PORT %d is not a valid port", env.SmtpPort)
+	}
+	if env.SmtpFrom == "" || len(env.SmtpTo) == 0 {
+		return fmt.Errorf("SMTP_FROM and SMTP_TO are mandatory with SMTP_HOST")
+	}
+	for _, address := range append([]string{env.SmtpFrom}, env.SmtpTo...) {
+		_, err := mail.ParseAddress(strings.TrimSpace(address))
+		if err != nil {
+			return fmt.Errorf("email address %s is not valid: %w", address, err)
+		}
+	}
+	if env.SmtpUsername == "" && (env.SmtpPassword != "" || env.SmtpPasswordFile != "") {
+		return fmt.Errorf("SMTP_USERNAME is mandatory with SMTP_PASSWORD")
+	}
+	if env.EmailReportSchedule != "" {
+		_, err := newTransferSchedule(env.EmailReportSchedule, env.TransferScheduleTimezone, 0)
+		if err != nil {
+			return fmt.Errorf("EMAIL_REPORT_SCHEDULE is not valid: %w", err)
+		}
+	}
+	return nil
+}
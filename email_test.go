@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSink is a local SMTP server keeping the emails it receives, offering STARTTLS with a self-signed
+// certificate and requiring AUTH PLAIN before accepting an email.
+type smtpSink struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	roots     *x509.CertPool
+	username  string
+	password  string
+	messages  []smtpMessage
+	lock      sync.Mutex
+}
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func newSMTPSink(t *testing.T, startTLS bool) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := &smtpSink{listener: listener, username: "mining", password: "smtp-secret"}
+	if startTLS {
+		s.tlsConfig, s.roots = newTestCertificate(t)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return s
+}
+
+func newTestCertificate(t *testing.T) (*tls.Config, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, roots
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 sink ESMTP")
+	secure, authenticated := false, false
+	message := smtpMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			_ = text.PrintfLine("250-sink")
+			if s.tlsConfig != nil && !secure {
+				_ = text.PrintfLine("250-STARTTLS")
+			}
+			_ = text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			_ = text.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, text, secure = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(argument, "PLAIN "))
+			if string(credentials) != "\x00"+s.username+"\x00"+s.password {
+				_ = text.PrintfLine("535 authentication failed")
+				continue
+			}
+			authenticated = true
+			_ = text.PrintfLine("235 authenticated")
+		case "MAIL":
+			if !authenticated {
+				_ = text.PrintfLine("530 authentication required")
+				continue
+			}
+			message = smtpMessage{from: strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")}
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			message.to = append(message.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			message.data, err = text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.messages = append(s.messages, message)
+			s.lock.Unlock()
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpSink) received() []smtpMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpSink) mailer(password string) *mailer {
+	m := newMailer("127.0.0.1", s.port(), s.username, newSecret("SMTP_PASSWORD", password, ""),
+		"Mining <mining@example.org>", []string{"accounting@example.org", " ops@example.org"}, s.tlsConfig != nil)
+	m.tlsConfig.RootCAs = s.roots
+	return m
+}
+
+// readEmail returns the subject and the plain text and html bodies of an email.
+func readEmail(t *testing.T, data []byte) (string, string, string) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.Nil(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	assert.Nil(t, err)
+	_, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	bodies := make([]string, 0, 2)
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		// The quoted-printable encoding is transparently decoded
+		body, err := io.ReadAll(part)
+		assert.Nil(t, err)
+		bodies = append(bodies, string(body))
+	}
+	assert.Len(t, bodies, 2)
+	return subject, bodies[0], bodies[1]
+}
+
+func TestEmailReport(t *testing.T) {
+	sink := newSMTPSink(t, true)
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret", "25", "1.5")
+	stats, _ := newAddressBalanceStats(node.client(), []string{wallet.addresses[0].Address,
+		wallet.addresses[1].Address}, newTestMetrics())
+	assert.Nil(t, stats.doStats(context.Background()))
+
+	reporter := newEmailReporter(sink.mailer("smtp-secret"), nil, 0, time.Millisecond, "http://alephium:12973",
+		newTestLogger())
+	reporter.watch("", stats, nil)
+	amount, _ := ALPHFromALPHString("24.998")
+	fee, _ := ALPHFromALPHString("0.002")
+	ctx := context.Background()
+	assert.Nil(t, reporter.deliver(ctx, event{Type: eventTransferConfirmed, Transfer: &transferRecord{Amount: amount, Fee: fee}}))
+	assert.Nil(t, reporter.deliver(ctx, event{Type: eventTransferConfirmed, Transfer: &transferRecord{Amount: amount, Fee: fee}}))
+	reward, _ := ALPHFromALPHString("2.5")
+	assert.Nil(t, reporter.deliver(ctx, event{Type: eventBlockMined, Block: &minedBlock{Reward: reward}}))
+	reporter.sendReport(ctx, time.Now().UTC())
+
+	messages := sink.received()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "mining@example.org", messages[0].from)
+	assert.Equal(t, []string{"accounting@example.org", "ops@example.org"}, messages[0].to)
+	subject, text, html := readEmail(t, messages[0].data)
+	assert.Contains(t, subject, "[Alephium mining] Report from")
+	assert.Contains(t, text, "http://alephium:12973\n  Blocks mined: 1, rewarding 2.5ALPH\n"+
+		"  Sweeps: 2, 49.996ALPH transferred for 0.004ALPH of fees")
+	assert.Contains(t, text, wallet.addresses[0].Address+": 25ALPH (0 locked)")
+	assert.Contains(t, text, wallet.addresses[1].Address+": 1.5ALPH (0 locked)")
+	assert.Contains(t, html, "<td><code>"+wallet.addresses[0].Address+"</code></td><td>25ALPH</td>")
+
+	// The next report starts afresh
+	reporter.sendReport(ctx, time.Now().UTC())
+	_, text, _ = readEmail(t, sink.received()[1].data)
+	assert.Contains(t, text, "Sweeps: 0, 0 transferred for 0 of fees")
+}
+
+func TestEmailReportLedger(t *testing.T) {
+	sink := newSMTPSink(t, true)
+	node := newFakeNode(t)
+	wallet := node.addWallet("mining", "secret", "25")
+	stats, _ := newAddressBalanceStats(node.client(), []string{wallet.addresses[0].Address}, newTestMetrics())
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	ledger, err := newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+	reward, _ := ALPHFromALPHString("2.5")
+	assert.Nil(t, ledger.recordBlock(minedBlock{Hash: "block-1", Time: time.Now().UTC(), Reward: reward}))
+	assert.Nil(t, ledger.Close())
+
+	// The earnings recorded before a restart are part of the next report
+	ledger, err = newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+	defer ledger.Close()
+	reporter := newEmailReporter(sink.mailer("smtp-secret"), nil, 0, time.Millisecond, "http://alephium:12973",
+		newTestLogger())
+	reporter.watch("", stats, ledger)
+	ctx := context.Background()
+	// The ledger sums up the blocks, the events are not counted twice
+	assert.Nil(t, reporter.deliver(ctx, event{Type: eventBlockMined, Block: &minedBlock{Reward: reward}}))
+	reporter.sendReport(ctx, time.Now().UTC())
+	_, text, _ := readEmail(t, sink.received()[0].data)
+	assert.Contains(t, text, "Blocks mined: 1, rewarding 2.5ALPH")
+
+	reporter.sendReport(ctx, time.Now().UTC())
+	_, text, _ = readEmail(t, sink.received()[1].data)
+	assert.Contains(t, text, "Blocks mined: 0, rewarding 0\n")
+	// The earnings summary is taken apart from the report
+	summary, err := ledger.takeEarningsSince(earningsSummaryKey, time.Now().UTC())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, summary.Blocks)
+}
+
+func TestEmailAlert(t *testing.T) {
+	sink := newSMTPSink(t, true)
+	reporter := newEmailReporter(sink.mailer("smtp-secret"), nil, 0, time.Millisecond, "", newTestLogger())
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("email", reporter, emailReportEvents)
+	n.withNode("node-1").notify(event{Type: eventTransferFailed, Wallet: "mining", Error: "not enough <balance>"})
+	n.Close(5 * time.Second)
+
+	messages := sink.received()
+	assert.Len(t, messages, 1)
+	subject, text, html := readEmail(t, messages[0].data)
+	assert.Equal(t, "[Alephium mining] Transfer failed [node-1]", subject)
+	assert.Equal(t, "Transfer from wallet mining failed: not enough <balance>.\n", text)
+	assert.Contains(t, html, "not enough &lt;balance&gt;")
+
+	// Wrong credentials are not worth retrying
+	err := sink.mailer("wrong").send(context.Background(), "subject", "text", "html")
+	assert.NotNil(t, err)
+	var permanentErr *permanentError
+	assert.ErrorAs(t, err, &permanentErr)
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	sink := newSMTPSink(t, false)
+	m := sink.mailer("smtp-secret")
+	m.startTLS = true
+	err := m.send(context.Background(), "subject", "text", "html")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+	assert.Empty(t, sink.received())
+}
+
+func TestValidateSmtp(t *testing.T) {
+	env := envConfig{SmtpHost: "smtp.example.org", SmtpPort: 587, SmtpFrom: "Mining <mining@example.org>",
+		SmtpTo: []string{"accounting@example.org"}, EmailReportSchedule: "0 8 * * MON", TransferScheduleTimezone: "UTC"}
+	assert.Nil(t, env.validateSmtp())
+	for i, invalid := range []func(env envConfig) envConfig{
+		func(env envConfig) envConfig { env.SmtpPort = 0; return env },
+		func(env envConfig) envConfig { env.SmtpTo = nil; return env },
+		func(env envConfig) envConfig { env.SmtpFrom = "mining"; return env },
+		func(env envConfig) envConfig { env.SmtpPassword = "secret"; return env },
+		func(env envConfig) envConfig { env.EmailReportSchedule = "weekly"; return env },
+	} {
+		assert.NotNil(t, invalid(env).validateSmtp(), strconv.Itoa(i))
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeGroups    = int32(4)
+	fakeGasAmount = int32(20000)
+	fakeGasPrice  = "100000000000"
+)
+
+// fakeNode is an in-process Alephium node, serving the subset of the REST API used by the companion
+// out of scripted wallets, balances, sync state and tx confirmations.
+type fakeNode struct {
+	t      *testing.T
+	server *httptest.Server
+	mu     sync.Mutex
+
+	wallets        map[string]*fakeWallet
+	minerAddresses []string
+	synced         bool
+	// syncedAfter is the number of peer info calls after which the node becomes in sync, if not synced.
+	syncedAfter int
+	peerCalls   int
+	// confirmAfter is the number of status calls after which a tx is confirmed.
+	confirmAfter int
+	txs          map[string]*fakeTx
+	blocks       map[string]alephium.BlockEntry
+	submitted    []string
+	// signed is the number of txs the wallets were asked to sign, whether they got submitted or not.
+	signed int
+	// groups overrides the group of addresses, computed from the address otherwise.
+	groups map[string]int32
+	// hashrate is the hashrate of the network, as formatted by the node.
+	hashrate string
+	// built is the destinations of the txs the node was asked to build.
+	built [][]alephium.Destination
+	// gasAmounts is the gas amount of the next txs built, fakeGasAmount afterwards.
+	gasAmounts []int32
+}
+
+type fakeWallet struct {
+	name          string
+	password      string
+	locked        bool
+	activeAddress string
+	addresses     []alephium.AddressInfo
+	balances      map[string]ALPH
+	lockedBalance map[string]ALPH
+}
+
+type fakeTx struct {
+	result  alephium.TransferResult
+	tx      alephium.Transaction
+	polls   int
+	dropped bool
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	n := &fakeNode{
+		t:        t,
+		wallets:  make(map[string]*fakeWallet),
+		synced:   true,
+		txs:      make(map[string]*fakeTx),
+		blocks:   make(map[string]alephium.BlockEntry),
+		groups:   make(map[string]int32),
+		hashrate: "0 MH/s",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /wallets/{name}", n.getWallet)
+	mux.HandleFunc("POST /wallets", n.createWallet)
+	mux.HandleFunc("PUT /wallets", n.restoreWallet)
+	mux.HandleFunc("POST /wallets/{name}/unlock", n.unlockWallet)
+	mux.HandleFunc("GET /wallets/{name}/addresses", n.getWalletAddresses)
+	mux.HandleFunc("GET /wallets/{name}/balances", n.getWalletBalances)
+	mux.HandleFunc("POST /wallets/{name}/change-active-address", n.changeActiveAddress)
+	mux.HandleFunc("POST /wallets/{name}/sweep-active-address", n.sweepActiveAddress)
+	mux.HandleFunc("POST /wallets/{name}/transfer", n.transfer)
+	mux.HandleFunc("GET /miners/addresses", n.getMinersAddresses)
+	mux.HandleFunc("PUT /miners/addresses", n.putMinersAddresses)
+	mux.HandleFunc("GET /addresses/{address}/balance", n.getAddressBalance)
+	mux.HandleFunc("GET /addresses/{address}/group", n.getAddressGroup)
+	mux.HandleFunc("GET /infos/inter-clique-peer-info", n.getInterCliquePeerInfo)
+	mux.HandleFunc("GET /infos/current-hashrate", n.getCurrentHashrate)
+	mux.HandleFunc("GET /transactions/status", n.getTransactionStatus)
+	mux.HandleFunc("POST /transactions/build", n.buildTransaction)
+	mux.HandleFunc("POST /transactions/sweep-address/build", n.buildSweepAddressTransactions)
+	mux.HandleFunc("GET /blockflow/blocks/{hash}", n.getBlock)
+	mux.HandleFunc("GET /blockflow/blocks", n.getBlocks)
+
+	n.server = httptest.NewServer(mux)
+	t.Cleanup(n.server.Close)
+	return n
+}
+
+func (n *fakeNode) client() nodeClient {
+	return newNodeClient(n.server.URL, nil, false)
+}
+
+// addWallet scripts an unlocked wallet with 4 addresses, one per group, and their balances in ALPH.
+func (n *fakeNode) addWallet(name string, password string, balances ...string) *fakeWallet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wallet := n.newWallet(name, password)
+	for i, balance := range balances {
+		amount, ok := ALPHFromALPHString(balance)
+		if !ok {
+			n.t.Fatalf("balance %s is not valid", balance)
+		}
+		wallet.balances[wallet.addresses[i].Address] = amount
+	}
+	return wallet
+}
+
+func (n *fakeNode) newWallet(name string, password string) *fakeWallet {
+	wallet := &fakeWallet{
+		name:          name,
+		password:      password,
+		balances:      make(map[string]ALPH),
+		lockedBalance: make(map[string]ALPH),
+	}
+	for group := int32(0); group < fakeGroups; group++ {
+		address := fmt.Sprintf("%s-address-%d", name, group)
+		wallet.addresses = append(wallet.addresses, alephium.AddressInfo{
+			Address:   address,
+			PublicKey: fmt.Sprintf("%s-pk-%d", name, group),
+			Group:     group,
+		})
+		wallet.balances[address] = ALPH{Amount: new(big.Int)}
+		wallet.lockedBalance[address] = ALPH{Amount: new(big.Int)}
+	}
+	wallet.activeAddress = wallet.addresses[0].Address
+	n.wallets[name] = wallet
+	return wallet
+}
+
+// minerAddresses returns the addresses of the wallet, one per group, as the miner addresses.
+func (w *fakeWallet) minerAddresses() []string {
+	addresses := make([]string, 0, len(w.addresses))
+	for _, address := range w.addresses {
+		addresses = append(addresses, address.Address)
+	}
+	return addresses
+}
+
+func (n *fakeNode) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		n.t.Errorf("failed to encode response: %v", err)
+	}
+}
+
+func (n *fakeNode) writeError(w http.ResponseWriter, status int, detail string) {
+	n.writeJSON(w, status, map[string]string{"detail": detail})
+}
+
+func (n *fakeNode) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		n.writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// wallet returns the wallet of the request, writing a not found error if it doesn't exist.
+func (n *fakeNode) wallet(w http.ResponseWriter, r *http.Request, unlocked bool) *fakeWallet {
+	wallet, ok := n.wallets[r.PathValue("name")]
+	if !ok {
+		n.writeError(w, http.StatusNotFound, fmt.Sprintf("Wallet %s not found", r.PathValue("name")))
+		return nil
+	}
+	if unlocked && wallet.locked {
+		n.writeError(w, http.StatusUnauthorized, "Wallet is locked")
+		return nil
+	}
+	return wallet
+}
+
+func (n *fakeNode) getWallet(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if wallet := n.wallet(w, r, false); wallet != nil {
+		n.writeJSON(w, http.StatusOK, alephium.WalletStatus{WalletName: wallet.name, Locked: wallet.locked})
+	}
+}
+
+func (n *fakeNode) createWallet(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var walletCreation alephium.WalletCreation
+	if !n.readJSON(w, r, &walletCreation) {
+		return
+	}
+	wallet := n.newWallet(walletCreation.WalletName, walletCreation.Password)
+	n.writeJSON(w, http.StatusOK, alephium.WalletCreationResult{WalletName: wallet.name, Mnemonic: "fake mnemonic"})
+}
+
+func (n *fakeNode) restoreWallet(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var walletRestore alephium.WalletRestore
+	if !n.readJSON(w, r, &walletRestore) {
+		return
+	}
+	wallet := n.newWallet(walletRestore.WalletName, walletRestore.Password)
+	n.writeJSON(w, http.StatusOK, alephium.WalletRestoreResult{WalletName: wallet.name})
+}
+
+func (n *fakeNode) unlockWallet(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wallet := n.wallet(w, r, false)
+	if wallet == nil {
+		return
+	}
+	var walletUnlock alephium.WalletUnlock
+	if !n.readJSON(w, r, &walletUnlock) {
+		return
+	}
+	if walletUnlock.Password != wallet.password {
+		n.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+	wallet.locked = false
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *fakeNode) getWalletAddresses(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if wallet := n.wallet(w, r, false); wallet != nil {
+		n.writeJSON(w, http.StatusOK, alephium.Addresses{ActiveAddress: wallet.activeAddress, Addresses: wallet.addresses})
+	}
+}
+
+func (n *fakeNode) getWalletBalances(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wallet := n.wallet(w, r, false)
+	if wallet == nil {
+		return
+	}
+	total := ALPH{Amount: new(big.Int)}
+	balances := alephium.Balances{}
+	for _, addressInfo := range wallet.addresses {
+		balance := wallet.balances[addressInfo.Address]
+		total = total.Add(balance)
+		balances.Balances = append(balances.Balances, alephium.AddressBalance{
+			Address:       addressInfo.Address,
+			Balance:       balance.String(),
+			LockedBalance: wallet.lockedBalance[addressInfo.Address].String(),
+		})
+	}
+	balances.TotalBalance = total.String()
+	n.writeJSON(w, http.StatusOK, balances)
+}
+
+func (n *fakeNode) changeActiveAddress(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wallet := n.wallet(w, r, true)
+	if wallet == nil {
+		return
+	}
+	var changeActiveAddress alephium.ChangeActiveAddress
+	if !n.readJSON(w, r, &changeActiveAddress) {
+		return
+	}
+	wallet.activeAddress = changeActiveAddress.Address
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *fakeNode) sweepActiveAddress(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.signed++
+	wallet := n.wallet(w, r, true)
+	if wallet == nil {
+		return
+	}
+	var sweep alephium.Sweep
+	if !n.readJSON(w, r, &sweep) {
+		return
+	}
+	fee, _ := getFee(fakeGasAmount, fakeGasPrice)
+	available := wallet.balances[wallet.activeAddress].Subtract(wallet.lockedBalance[wallet.activeAddress])
+	amount := available.Subtract(fee)
+	if amount.Amount.Sign() <= 0 {
+		n.writeError(w, http.StatusBadRequest, "Not enough balance")
+		return
+	}
+	tx := n.submitTx(wallet, []alephium.Destination{*alephium.NewDestination(sweep.ToAddress, amount.String())},
+		fakeGasAmount, fakeGasPrice)
+	n.writeJSON(w, http.StatusOK, alephium.TransferResults{Results: []alephium.TransferResult{tx.result}})
+}
+
+func (n *fakeNode) transfer(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.signed++
+	wallet := n.wallet(w, r, true)
+	if wallet == nil {
+		return
+	}
+	var transfer alephium.Transfer
+	if !n.readJSON(w, r, &transfer) {
+		return
+	}
+	gasAmount, gasPrice := fakeGasAmount, fakeGasPrice
+	if transfer.GasAmount != nil {
+		gasAmount = *transfer.GasAmount
+	}
+	if transfer.GasPrice != nil {
+		gasPrice = *transfer.GasPrice
+	}
+	fee, _ := getFee(gasAmount, gasPrice)
+	total := fee
+	for _, destination := range transfer.Destinations {
+		amount, _ := ALPHFromCoinString(destination.AttoAlphAmount)
+		total = total.Add(amount)
+	}
+	available := wallet.balances[wallet.activeAddress].Subtract(wallet.lockedBalance[wallet.activeAddress])
+	if total.Cmp(available) > 0 {
+		n.writeError(w, http.StatusBadRequest, "Not enough balance")
+		return
+	}
+	tx := n.submitTx(wallet, transfer.Destinations, gasAmount, gasPrice)
+	n.writeJSON(w, http.StatusOK, tx.result)
+}
+
+// submitTx debits the active address of the wallet and records the tx to be confirmed later on.
+func (n *fakeNode) submitTx(wallet *fakeWallet, destinations []alephium.Destination, gasAmount int32,
+	gasPrice string) *fakeTx {
+
+	txId := fmt.Sprintf("tx-%d", len(n.submitted)+1)
+	from := wallet.activeAddress
+	fee, _ := getFee(gasAmount, gasPrice)
+	wallet.balances[from] = wallet.balances[from].Subtract(fee)
+	outputs := make([]alephium.FixedAssetOutput, 0, len(destinations))
+	for _, destination := range destinations {
+		amount, _ := ALPHFromCoinString(destination.AttoAlphAmount)
+		wallet.balances[from] = wallet.balances[from].Subtract(amount)
+		outputs = append(outputs, alephium.FixedAssetOutput{Address: destination.Address, AttoAlphAmount: amount.String()})
+	}
+	var fromGroup int32
+	for _, addressInfo := range wallet.addresses {
+		if addressInfo.Address == from {
+			fromGroup = addressInfo.Group
+		}
+	}
+	tx := &fakeTx{
+		result: alephium.TransferResult{TxId: txId, FromGroup: fromGroup, ToGroup: 0},
+		tx: alephium.Transaction{Unsigned: alephium.UnsignedTx{
+			TxId:         txId,
+			GasAmount:    gasAmount,
+			GasPrice:     gasPrice,
+			FixedOutputs: outputs,
+		}},
+	}
+	n.txs[txId] = tx
+	n.submitted = append(n.submitted, txId)
+	return tx
+}
+
+func (n *fakeNode) getMinersAddresses(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.writeJSON(w, http.StatusOK, alephium.MinerAddresses{Addresses: n.minerAddresses})
+}
+
+func (n *fakeNode) putMinersAddresses(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var minerAddresses alephium.MinerAddresses
+	if !n.readJSON(w, r, &minerAddresses) {
+		return
+	}
+	n.minerAddresses = minerAddresses.Addresses
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *fakeNode) getAddressBalance(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	address := r.PathValue("address")
+	balance := alephium.Balance{Balance: "0", LockedBalance: "0"}
+	for _, wallet := range n.wallets {
+		if amount, ok := wallet.balances[address]; ok {
+			balance.Balance = amount.String()
+			balance.LockedBalance = wallet.lockedBalance[address].String()
+			balance.UtxoNum = 1
+		}
+	}
+	n.writeJSON(w, http.StatusOK, balance)
+}
+
+func (n *fakeNode) getAddressGroup(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	address := r.PathValue("address")
+	if group, ok := n.groups[address]; ok {
+		n.writeJSON(w, http.StatusOK, alephium.Group{Group: group})
+		return
+	}
+	parsed, err := parseAddress(address)
+	if err != nil {
+		n.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	n.writeJSON(w, http.StatusOK, alephium.Group{Group: int32(parsed.group)})
+}
+
+func (n *fakeNode) getInterCliquePeerInfo(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peerCalls++
+	if !n.synced && n.syncedAfter > 0 && n.peerCalls > n.syncedAfter {
+		n.synced = true
+	}
+	n.writeJSON(w, http.StatusOK, []alephium.InterCliquePeerInfo{{CliqueId: "peer-1", IsSynced: n.synced}})
+}
+
+func (n *fakeNode) getCurrentHashrate(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.writeJSON(w, http.StatusOK, alephium.HashRateResponse{Hashrate: n.hashrate})
+}
+
+func (n *fakeNode) getTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tx, ok := n.txs[r.URL.Query().Get("txId")]
+	if !ok || tx.dropped {
+		n.writeJSON(w, http.StatusOK, map[string]string{"type": "TxNotFound"})
+		return
+	}
+	tx.polls++
+	if tx.polls <= n.confirmAfter {
+		n.writeJSON(w, http.StatusOK, map[string]string{"type": "MemPooled"})
+		return
+	}
+	blockHash := "block-" + tx.result.TxId
+	n.blocks[blockHash] = alephium.BlockEntry{
+		Hash:         blockHash,
+		Timestamp:    1675650000000,
+		ChainFrom:    tx.result.FromGroup,
+		ChainTo:      tx.result.ToGroup,
+		Transactions: []alephium.Transaction{tx.tx},
+	}
+	n.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type":                   "Confirmed",
+		"blockHash":              blockHash,
+		"txIndex":                0,
+		"chainConfirmations":     1,
+		"fromGroupConfirmations": 1,
+		"toGroupConfirmations":   1,
+	})
+}
+
+func (n *fakeNode) addressOfPublicKey(publicKey string) (string, int32, bool) {
+	for _, wallet := range n.wallets {
+		for _, addressInfo := range wallet.addresses {
+			if addressInfo.PublicKey == publicKey {
+				return addressInfo.Address, addressInfo.Group, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+func (n *fakeNode) buildTransaction(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var buildTx alephium.BuildTransaction
+	if !n.readJSON(w, r, &buildTx) {
+		return
+	}
+	_, group, ok := n.addressOfPublicKey(buildTx.FromPublicKey)
+	if !ok {
+		n.writeError(w, http.StatusBadRequest, "Unknown public key")
+		return
+	}
+	n.built = append(n.built, buildTx.Destinations)
+	gasAmount := fakeGasAmount
+	if len(n.gasAmounts) > 0 {
+		gasAmount, n.gasAmounts = n.gasAmounts[0], n.gasAmounts[1:]
+	}
+	n.writeJSON(w, http.StatusOK, alephium.BuildTransactionResult{
+		UnsignedTx: "unsigned",
+		GasAmount:  gasAmount,
+		GasPrice:   fakeGasPrice,
+		TxId:       "built-" + buildTx.FromPublicKey,
+		FromGroup:  group,
+		ToGroup:    0,
+	})
+}
+
+func (n *fakeNode) buildSweepAddressTransactions(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var buildSweep alephium.BuildSweepAddressTransactions
+	if !n.readJSON(w, r, &buildSweep) {
+		return
+	}
+	_, group, ok := n.addressOfPublicKey(buildSweep.FromPublicKey)
+	if !ok {
+		n.writeError(w, http.StatusBadRequest, "Unknown public key")
+		return
+	}
+	n.writeJSON(w, http.StatusOK, alephium.BuildSweepAddressTransactionsResult{
+		UnsignedTxs: []alephium.SweepAddressTransaction{{
+			TxId:       "built-sweep-" + buildSweep.FromPublicKey,
+			UnsignedTx: "unsigned",
+			GasAmount:  fakeGasAmount,
+			GasPrice:   fakeGasPrice,
+		}},
+		FromGroup: group,
+		ToGroup:   0,
+	})
+}
+
+func (n *fakeNode) getBlock(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	block, ok := n.blocks[r.PathValue("hash")]
+	if !ok {
+		n.writeError(w, http.StatusNotFound, "Block not found")
+		return
+	}
+	n.writeJSON(w, http.StatusOK, block)
+}
+
+func (n *fakeNode) getBlocks(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	fromTs, errFrom := strconv.ParseInt(r.URL.Query().Get("fromTs"), 10, 64)
+	toTs, errTo := strconv.ParseInt(r.URL.Query().Get("toTs"), 10, 64)
+	if errFrom != nil || errTo != nil || toTs < fromTs {
+		n.writeError(w, http.StatusBadRequest, "Invalid time range")
+		return
+	}
+	chains := make([][]alephium.BlockEntry, fakeGroups*fakeGroups)
+	for _, block := range n.blocks {
+		if block.Timestamp >= fromTs && block.Timestamp <= toTs {
+			chain := block.ChainFrom*fakeGroups + block.ChainTo
+			chains[chain] = append(chains[chain], block)
+		}
+	}
+	for _, chain := range chains {
+		sort.Slice(chain, func(i, j int) bool {
+			return chain[i].Height < chain[j].Height
+		})
+	}
+	n.writeJSON(w, http.StatusOK, alephium.BlocksPerTimeStampRange{Blocks: chains})
+}
+
+// mineBlock scripts a block of the chain from->to mined at the given time, its coinbase paying reward
+// in ALPH to address.
+func (n *fakeNode) mineBlock(from int32, to int32, address string, reward string, at time.Time) alephium.BlockEntry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	amount, ok := ALPHFromALPHString(reward)
+	assert.True(n.t, ok)
+	height := int32(len(n.blocks) + 1)
+	block := alephium.BlockEntry{
+		Hash:      fmt.Sprintf("block-%d-%d-%d", from, to, height),
+		Timestamp: at.UnixMilli(),
+		ChainFrom: from,
+		ChainTo:   to,
+		Height:    height,
+		Target:    "1d00ffff",
+		Transactions: []alephium.Transaction{{Unsigned: alephium.UnsignedTx{
+			TxId:         fmt.Sprintf("coinbase-%d", height),
+			FixedOutputs: []alephium.FixedAssetOutput{{Address: address, AttoAlphAmount: amount.String()}},
+		}}},
+	}
+	n.blocks[block.Hash] = block
+	return block
+}
+
+var (
+	testMetricsOnce sync.Once
+	testMetricsInst *metrics
+)
+
+// newTestMetrics returns the metrics shared by all the tests, as they can only be registered once.
+func newTestMetrics() *metrics {
+	testMetricsOnce.Do(func() {
+		testMetricsInst = initPrometheus(envConfig{MetricsNamespace: "test", MetricsSubsystem: "companion",
+			MetricsPath: "/metrics"}, http.NewServeMux())
+	})
+	return testMetricsInst
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+	return logger
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// fleetMemberNamePattern restricts the names of the nodes of a fleet to what fits in the urls, the
+// names of the loops and health checks and the metric labels.
+var fleetMemberNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// fleetMember is a node and its mining wallet in the fleet config. The settings left empty are the
+// ones of the env vars.
+type fleetMember struct {
+	Name                         string `json:"name"`
+	AlephiumEndpoint             string `json:"alephiumEndpoint"`
+	AlephiumApiKey               string `json:"alephiumApiKey"`
+	AlephiumApiKeyFile           string `json:"alephiumApiKeyFile"`
+	WalletName                   string `json:"walletName"`
+	WalletPassword               string `json:"walletPassword"`
+	WalletPasswordFile           string `json:"walletPasswordFile"`
+	WalletMnemonic               string `json:"walletMnemonic"`
+	WalletMnemonicFile           string `json:"walletMnemonicFile"`
+	WalletMnemonicPassphrase     string `json:"walletMnemonicPassphrase"`
+	WalletMnemonicPassphraseFile string `json:"walletMnemonicPassphraseFile"`
+	TransferAddress              string `json:"transferAddress"`
+	TransferMinAmount            string `json:"transferMinAmount"`
+	LedgerPath                   string `json:"ledgerPath"`
+	VaultSecretPath              string `json:"vaultSecretPath"`
+}
+
+// fleetConfig lists the nodes and mining wallets handled by a single companion, i.e.
+//
+//	{"nodes": [{"name": "node-1", "alephiumEndpoint": "http://node-1:12973", "walletName": "mining-1"}]}
+type fleetConfig struct {
+	Nodes []fleetMember `json:"nodes"`
+}
+
+func loadFleetConfig(path string) (*fleetConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &fleetConfig{}
+	err = json.Unmarshal(content, config)
+	if err != nil {
+		return nil, fmt.Errorf("fleet config %s is not valid: %w", path, err)
+	}
+	if len(config.Nodes) == 0 {
+		return nil, fmt.Errorf("fleet config %s doesn't list any node", path)
+	}
+
+	names := make(map[string]bool)
+	for _, member := range config.Nodes {
+		if !fleetMemberNamePattern.MatchString(member.Name) {
+			return nil, fmt.Errorf("fleet config %s: node name %q is not valid, only letters, digits, - and _ are allowed",
+				path, member.Name)
+		}
+		if names[member.Name] {
+			return nil, fmt.Errorf("fleet config %s: node name %s is used more than once", path, member.Name)
+		}
+		names[member.Name] = true
+	}
+	return config, nil
+}
+
+// envConfig returns the settings of the member, the ones of env overridden by the ones of the member.
+// Each member gets its own ledger, next to the one of env if not set, as a ledger can't be shared.
+func (m fleetMember) envConfig(env envConfig) envConfig {
+	override := func(value *string, memberValue string) {
+		if memberValue != "" {
+			*value = memberValue
+		}
+	}
+	// A secret set on the member, as is or as a file, replaces both forms of the one of env, as the file
+	// would otherwise win
+	overrideSecret := func(value *string, path *string, memberValue string, memberPath string) {
+		if memberValue != "" || memberPath != "" {
+			*value, *path = memberValue, memberPath
+		}
+	}
+	override(&env.AlephiumEndpoint, m.AlephiumEndpoint)
+	overrideSecret(&env.AlephiumApiKey, &env.AlephiumApiKeyFile, m.AlephiumApiKey, m.AlephiumApiKeyFile)
+	override(&env.WalletName, m.WalletName)
+	overrideSecret(&env.WalletPassword, &env.WalletPasswordFile, m.WalletPassword, m.WalletPasswordFile)
+	overrideSecret(&env.WalletMnemonic, &env.WalletMnemonicFile, m.WalletMnemonic, m.WalletMnemonicFile)
+	overrideSecret(&env.WalletMnemonicPassphrase, &env.WalletMnemonicPassphraseFile, m.WalletMnemonicPassphrase,
+		m.WalletMnemonicPassphraseFile)
+	override(&env.TransferAddress, m.TransferAddress)
+	override(&env.TransferMinAmount, m.TransferMinAmount)
+	override(&env.VaultSecretPath, m.VaultSecretPath)
+	if m.LedgerPath != "" {
+		env.LedgerPath = m.LedgerPath
+	} else if env.LedgerPath != "" {
+		env.LedgerPath = env.LedgerPath + "." + m.Name
+	}
+	return env
+}
+
+// envConfigs returns the settings of each member of the fleet, in the order of the config, checking
+// that no two members use the same wallet of the same node.
+func (c *fleetConfig) envConfigs(env envConfig) ([]envConfig, error) {
+	configs := make([]envConfig, 0, len(c.Nodes))
+	wallets := make(map[string]string)
+	for _, member := range c.Nodes {
+		memberEnv := member.envConfig(env)
+		wallet := memberEnv.AlephiumEndpoint + "|" + memberEnv.WalletName
+		if other, ok := wallets[wallet]; ok {
+			return nil, fmt.Errorf("nodes %s and %s both use wallet %s on %s", other, member.Name,
+				memberEnv.WalletName, memberEnv.AlephiumEndpoint)
+		}
+		wallets[wallet] = member.Name
+		configs = append(configs, memberEnv)
+	}
+	return configs, nil
+}
+
+func (c *fleetConfig) names() []string {
+	names := make([]string, 0, len(c.Nodes))
+	for _, member := range c.Nodes {
+		names = append(names, member.Name)
+	}
+	return names
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFleetConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "fleet.json")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadFleetConfig(t *testing.T) {
+	path := writeFleetConfig(t, `{"nodes": [
+		{"name": "node-1", "alephiumEndpoint": "http://node-1:12973", "walletName": "mining-1"},
+		{"name": "node-2", "alephiumEndpoint": "http://node-2:12973", "transferAddress": "1dest", "ledgerPath": "/data/node-2.db"}
+	]}`)
+	fleet, err := loadFleetConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"node-1", "node-2"}, fleet.names())
+
+	env := envConfig{AlephiumEndpoint: "http://alephium:12973", WalletName: "mining", WalletPassword: "secret",
+		TransferAddress: "1default", LedgerPath: "/data/ledger.db"}
+	envs, err := fleet.envConfigs(env)
+	assert.Nil(t, err)
+	assert.Len(t, envs, 2)
+	assert.Equal(t, "http://node-1:12973", envs[0].AlephiumEndpoint)
+	assert.Equal(t, "mining-1", envs[0].WalletName)
+	assert.Equal(t, "secret", envs[0].WalletPassword)
+	assert.Equal(t, "1default", envs[0].TransferAddress)
+	assert.Equal(t, "/data/ledger.db.node-1", envs[0].LedgerPath)
+	assert.Equal(t, "mining", envs[1].WalletName)
+	assert.Equal(t, "1dest", envs[1].TransferAddress)
+	assert.Equal(t, "/data/node-2.db", envs[1].LedgerPath)
+
+	// Both use the default wallet of the same node
+	fleet, err = loadFleetConfig(writeFleetConfig(t, `{"nodes": [{"name": "a"}, {"name": "b"}]}`))
+	assert.Nil(t, err)
+	_, err = fleet.envConfigs(env)
+	assert.NotNil(t, err)
+
+	for _, content := range []string{
+		`{"nodes": []}`,
+		`{"nodes": [{"name": ""}]}`,
+		`{"nodes": [{"name": "node 1"}]}`,
+		`{"nodes": [{"name": "a"}, {"name": "a"}]}`,
+		`{"nodes": `,
+	} {
+		_, err = loadFleetConfig(writeFleetConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestFleetCompanionsStart(t *testing.T) {
+	nodes := []*fakeNode{newFakeNode(t), newFakeNode(t)}
+	names := []string{"node-1", "node-2"}
+	companions := make([]*companion, 0, len(nodes))
+	for i, node := range nodes {
+		node.addWallet("mining-"+names[i], "secret")
+		env := envConfig{AlephiumEndpoint: node.server.URL, WalletName: "mining-" + names[i], WalletPassword: "secret",
+			TransferMinAmount: "10000000000000000000", TransferKeepReserve: "0", TransferKeepReserveScope: "address",
+			TransferFrequency: time.Hour, TransferScheduleTimezone: "UTC", RestartInitialBackoff: time.Millisecond,
+			RestartMaxBackoff: time.Second}
+		c, err := newCompanion(names[i], env, nil, nil, newTestMetrics(), newTestLogger())
+		assert.Nil(t, err)
+		assert.Nil(t, c.transferHandler)
+		companions = append(companions, c)
+	}
+	assert.Equal(t, "node-1/mining", companions[0].qualify("mining"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, c := range companions {
+		minersAddresses, err := c.start(ctx)
+		assert.Nil(t, err)
+		assert.Len(t, minersAddresses.Addresses, 4)
+		assert.Equal(t, "mining-"+names[i]+"-address-0", minersAddresses.Addresses[0])
+	}
+}
@@ -4,20 +4,24 @@ go 1.23.0
 
 require (
 	github.com/alephium/go-sdk v0.0.0-20230206042832-f7ec1fc14ec5
+	github.com/docker/distribution v2.8.2+incompatible
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/prometheus/client_golang v1.13.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/sqooba/go-common v0.0.0-20211129172903-8db1606d458e
 	github.com/stretchr/testify v1.8.0
 	github.com/willf/pad v0.0.0-20200313202418-172aa767f2a4
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/sync v0.0.0-20220601150217-0de741cfad7f
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/docker/distribution v2.8.2+incompatible // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/kr/pretty v0.2.0 // indirect
@@ -31,5 +35,4 @@ require (
 	golang.org/x/sys v0.31.0 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
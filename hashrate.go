@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// hashrateUnits are the units of the hashrates, from the largest one.
+var hashrateUnits = []struct {
+	name  string
+	value float64
+}{
+	{"PH/s", 1e15},
+	{"TH/s", 1e12},
+	{"GH/s", 1e9},
+	{"MH/s", 1e6},
+	{"KH/s", 1e3},
+	{"H/s", 1},
+}
+
+// parseHashrate parses a hashrate such as 850MH/s or 1.2 GH/s, in hashes per second, a hashrate without
+// unit being in H/s.
+func parseHashrate(s string) (float64, error) {
+	trimmed := strings.TrimSpace(s)
+	number, unit := trimmed, ""
+	if i := strings.IndexFunc(trimmed, func(r rune) bool { return (r < '0' || r > '9') && r != '.' }); i >= 0 {
+		number, unit = strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i:])
+	}
+	hashrate, err := strconv.ParseFloat(number, 64)
+	if err != nil || hashrate < 0 {
+		return 0, fmt.Errorf("hashrate %s is not a positive number", s)
+	}
+	if unit == "" {
+		return hashrate, nil
+	}
+	for _, u := range hashrateUnits {
+		if strings.EqualFold(unit, u.name) || strings.EqualFold(unit, strings.TrimSuffix(u.name, "/s")) {
+			return hashrate * u.value, nil
+		}
+	}
+	return 0, fmt.Errorf("hashrate %s has an unknown unit %s", s, unit)
+}
+
+// formatHashrate formats hashrate, in H/s, with the largest unit it has at least one of.
+func formatHashrate(hashrate float64) string {
+	for _, u := range hashrateUnits {
+		if hashrate >= u.value {
+			return strconv.FormatFloat(hashrate/u.value, 'f', 2, 64) + " " + u.name
+		}
+	}
+	return strconv.FormatFloat(hashrate, 'f', 2, 64) + " H/s"
+}
+
+// maxTarget is the largest target a block hash can be below of.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// targetDifficulty returns the number of hashes expected to find one below target, given by the node
+// as the hex of its compact bits: the size of the target in bytes followed by its 3 most significant bytes.
+// Mining a block of target takes more hashes, see blockWork.
+func targetDifficulty(target string) (float64, error) {
+	bits, err := hex.DecodeString(target)
+	if err != nil || len(bits) != 4 {
+		return 0, fmt.Errorf("target %s is not 4 bytes of hex", target)
+	}
+	value := new(big.Int).SetBytes(bits[1:])
+	size := int(bits[0])
+	if size < 3 {
+		value.Rsh(value, uint(8*(3-size)))
+	} else {
+		value.Lsh(value, uint(8*(size-3)))
+	}
+	if value.Sign() == 0 || value.Cmp(maxTarget) > 0 {
+		return 0, fmt.Errorf("target %s is out of range", target)
+	}
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(maxTarget), new(big.Float).SetInt(value)).Float64()
+	return difficulty, nil
+}
+
+// blockWork returns the number of hashes expected to mine a block of difficulty with groups groups. The hash
+// of a block also tells its chain, out of the groups² chains, so that only one hash below the target out of
+// the number of chains mines a block of the chain it was computed for. The node computes the hashrate of the
+// network the same way, as the difficulty of the chains times their number over their block time.
+func blockWork(difficulty float64, groups int32) float64 {
+	return difficulty * float64(groups) * float64(groups)
+}
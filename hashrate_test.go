@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseHashrate(t *testing.T) {
+	for s, expected := range map[string]float64{
+		"1000":     1000,
+		"850MH/s":  850e6,
+		"1.2 GH/s": 1.2e9,
+		"3 th/s":   3e12,
+		" 500 KH ": 500e3,
+	} {
+		hashrate, err := parseHashrate(s)
+		assert.Nil(t, err, s)
+		assert.InDelta(t, expected, hashrate, 1e-3, s)
+	}
+	for _, s := range []string{"", "fast", "-1MH/s", "1 MB/s"} {
+		_, err := parseHashrate(s)
+		assert.NotNil(t, err, s)
+	}
+	assert.Equal(t, "1.20 GH/s", formatHashrate(1.2e9))
+	assert.Equal(t, "850.00 MH/s", formatHashrate(850e6))
+}
+
+func TestTargetDifficulty(t *testing.T) {
+	difficulty, err := targetDifficulty("1d00ffff")
+	assert.Nil(t, err)
+	assert.InDelta(t, 4295032833.0, difficulty, 1)
+	// Each step of the size is 256 times easier
+	easier, err := targetDifficulty("1e00ffff")
+	assert.Nil(t, err)
+	assert.InDelta(t, difficulty/256, easier, 1)
+	for _, target := range []string{"", "1d00ff", "zz00ffff", "1d000000", "2101ffff"} {
+		_, err = targetDifficulty(target)
+		assert.NotNil(t, err, target)
+	}
+}
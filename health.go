@@ -1,15 +1,131 @@
 package main
 
 import (
-	"github.com/sqooba/go-common/healthchecks"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/distribution/health"
+	"github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 )
 
-func initHealthChecks(env envConfig, mux *http.ServeMux) {
-	healthchecks.RegisterHealthCheck("always-ok", alwaysOk)
+const (
+	livenessPath  = "/debug/health/live"
+	readinessPath = "/debug/health/ready"
+)
+
+// healthChecks splits the checks of the companion between liveness (is the process working at all,
+// restart it otherwise) and readiness (is the node usable for mining and transfers). All the checks are
+// also registered in the default registry served on /debug/health, used by the docker health check.
+type healthChecks struct {
+	liveness  *health.Registry
+	readiness *health.Registry
+}
+
+func initHealthChecks(env envConfig, mux *http.ServeMux) *healthChecks {
+	checks := &healthChecks{
+		liveness:  health.NewRegistry(),
+		readiness: health.NewRegistry(),
+	}
+	mux.HandleFunc(livenessPath, registryStatusHandler(checks.liveness))
+	mux.HandleFunc(readinessPath, registryStatusHandler(checks.readiness))
+	return checks
+}
+
+func (c *healthChecks) registerLiveness(name string, check health.Checker) {
+	c.liveness.Register(name, check)
+	health.Register(name, check)
+}
+
+func (c *healthChecks) registerReadiness(name string, check health.Checker) {
+	c.readiness.Register(name, check)
+	health.Register(name, check)
+}
+
+// registerStartup registers the readiness check of a startup sequence (wallet ready, miner addresses
+// set, node in sync), failing until the returned updater is updated with a nil error.
+func (c *healthChecks) registerStartup(name string) health.Updater {
+	starting := health.NewStatusUpdater()
+	starting.Update(fmt.Errorf("companion is starting"))
+	c.registerReadiness(name, starting)
+	return starting
+}
+
+// registerNodeChecks checks every period that the node is reachable and in sync, that the mining
+// wallet exists and can be unlocked and that the miner addresses are the ones of the wallet. The
+// names of the checks are prefixed with prefix, i.e. the name of the node in a fleet.
+func (c *healthChecks) registerNodeChecks(ctx context.Context, prefix string, h *miningHandler, period time.Duration) {
+	reachable := health.NewStatusUpdater()
+	synced := health.NewStatusUpdater()
+	wallet := health.NewStatusUpdater()
+	minerAddresses := health.NewStatusUpdater()
+	c.registerReadiness(prefix+"node-reachable", reachable)
+	c.registerReadiness(prefix+"node-synced", synced)
+	c.registerReadiness(prefix+"wallet", wallet)
+	c.registerReadiness(prefix+"miner-addresses", minerAddresses)
+
+	// Only the sync changes are notified, not the state of the first check, the node syncing at startup
+	var wasSynced *bool
+	check := func() {
+		log := logrus.NewEntry(h.log)
+		isSynced, err := IsSynced(ctx, h.alephiumClient, log)
+		if err != nil {
+			err = fmt.Errorf("node %s is unreachable: %w", h.alephiumClient.Host(), err)
+			reachable.Update(err)
+			synced.Update(err)
+			wallet.Update(err)
+			minerAddresses.Update(err)
+			return
+		}
+		reachable.Update(nil)
+		if wasSynced != nil && *wasSynced != isSynced {
+			eventType := eventNodeOutOfSync
+			if isSynced {
+				eventType = eventNodeSynced
+			}
+			h.notifier.notify(event{Type: eventType, Endpoint: h.alephiumClient.Host(), Wallet: h.walletName})
+		}
+		wasSynced = &isSynced
+		if isSynced {
+			synced.Update(nil)
+		} else {
+			synced.Update(fmt.Errorf("node %s is not in sync with any peer", h.alephiumClient.Host()))
+		}
+		wallet.Update(h.checkWallet(ctx, log))
+		minerAddresses.Update(h.checkMinerAddresses(ctx, log))
+	}
+
+	go func() {
+		check()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
 }
 
-// checkAlwaysOK is an always passing check.
-func alwaysOk() error {
-	return nil
+// registryStatusHandler serves the status of the checks of registry the same way /debug/health does,
+// 503 with the failing checks if any, 200 otherwise.
+func registryStatusHandler(registry *health.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		checks := registry.CheckStatus()
+		status := http.StatusOK
+		if len(checks) != 0 {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(checks)
+	}
 }
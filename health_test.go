@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/distribution/health"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNodeHealthChecks(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret")
+	handler, err := newMiningHandler(node.client(), "mining", testWalletSecrets("secret"), false, nil, newTestLogger())
+	assert.Nil(t, err)
+
+	// The checks are registered in the default registry as well, which must be fresh for each run
+	health.DefaultRegistry = health.NewRegistry()
+	mux := http.NewServeMux()
+	checks := initHealthChecks(envConfig{}, mux)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	starting := checks.registerStartup("startup")
+	checks.registerNodeChecks(ctx, "", handler, 10*time.Millisecond)
+
+	status := func(path string) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// Still starting and miner addresses not set yet
+	assert.Equal(t, http.StatusOK, status(livenessPath))
+	assert.Equal(t, http.StatusServiceUnavailable, status(readinessPath))
+
+	node.mu.Lock()
+	node.minerAddresses = []string{"mining-address-0", "mining-address-1", "mining-address-2", "mining-address-3"}
+	node.mu.Unlock()
+	starting.Update(nil)
+	assert.Eventually(t, func() bool { return status(readinessPath) == http.StatusOK }, time.Second, 10*time.Millisecond)
+
+	node.mu.Lock()
+	node.synced = false
+	node.wallets["mining"].locked = true
+	node.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		_, notSynced := checks.readiness.CheckStatus()["node-synced"]
+		return notSynced
+	}, time.Second, 10*time.Millisecond)
+	// The wallet got unlocked again by the check
+	assert.NotContains(t, checks.readiness.CheckStatus(), "wallet")
+}
+
+func TestTransferLiveness(t *testing.T) {
+	node := newFakeNode(t)
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	assert.Nil(t, handler.checkLiveness())
+
+	handler.setNextRun(time.Now().Add(-time.Minute))
+	assert.Nil(t, handler.checkLiveness())
+
+	handler.setNextRun(time.Now().Add(-time.Hour))
+	assert.NotNil(t, handler.checkLiveness())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+type transferStatus string
+
+const (
+	transferStatusSubmitted transferStatus = "submitted"
+	transferStatusConfirmed transferStatus = "confirmed"
+	transferStatusFailed    transferStatus = "failed"
+)
+
+var (
+	transfersBucket    = []byte("transfers")
+	stateBucket        = []byte("state")
+	lastTransferRunKey = []byte("lastTransferRun")
+)
+
+// transferRecord is the ledger entry of a single sweep tx.
+type transferRecord struct {
+	TxId        string         `json:"txId"`
+	FromGroup   int32          `json:"fromGroup"`
+	ToGroup     int32          `json:"toGroup"`
+	FromAddress string         `json:"fromAddress"`
+	ToAddresses []string       `json:"toAddresses"`
+	Amount      ALPH           `json:"amount"`
+	Fee         ALPH           `json:"fee"`
+	Dust        ALPH           `json:"dust"`
+	SubmittedAt time.Time      `json:"submittedAt"`
+	ConfirmedAt time.Time      `json:"confirmedAt"`
+	BlockHash   string         `json:"blockHash,omitempty"`
+	Status      transferStatus `json:"status"`
+}
+
+// transferLedger persists the transfers in a local bbolt file, so that in-flight
+// confirmations can be resumed after a restart, along with the earnings per period in location.
+type transferLedger struct {
+	db       *bolt.DB
+	location *time.Location
+}
+
+func newTransferLedger(path string, location *time.Location) (*transferLedger, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{transfersBucket, stateBucket, earningsBucket, earnedBlocksBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		// The first earnings summary and report cover the earnings since the ledger was created
+		for _, key := range earningsSinceKeys {
+			if tx.Bucket(earningsBucket).Get(key) != nil {
+				continue
+			}
+			value, err := json.Marshal(newEarnings("", time.Now().UTC(), time.Time{}))
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(earningsBucket).Put(key, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize ledger %s: %w", path, err)
+	}
+	return &transferLedger{db: db, location: location}, nil
+}
+
+func (l *transferLedger) Close() error {
+	return l.db.Close()
+}
+
+// save saves the record, adding the transfer to the earnings when it gets confirmed.
+func (l *transferLedger) save(record transferRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transfersBucket)
+		if record.Status == transferStatusConfirmed {
+			var previous transferRecord
+			if saved := bucket.Get([]byte(record.TxId)); saved != nil {
+				err := json.Unmarshal(saved, &previous)
+				if err != nil {
+					return err
+				}
+			}
+			if previous.Status != transferStatusConfirmed {
+				err := l.addEarnings(tx, record.ConfirmedAt, func(e *earnings) {
+					e.Transfers++
+					if record.Amount.Amount != nil {
+						e.Swept = e.Swept.Add(record.Amount)
+					}
+					if record.Fee.Amount != nil {
+						e.Fees = e.Fees.Add(record.Fee)
+					}
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return bucket.Put([]byte(record.TxId), value)
+	})
+}
+
+func (l *transferLedger) get(txId string) (*transferRecord, error) {
+	var record *transferRecord
+	err := l.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(transfersBucket).Get([]byte(txId))
+		if value == nil {
+			return nil
+		}
+		record = &transferRecord{}
+		return json.Unmarshal(value, record)
+	})
+	return record, err
+}
+
+// list returns all the transfers of the ledger, oldest first.
+func (l *transferLedger) list() ([]transferRecord, error) {
+	records := make([]transferRecord, 0)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(transfersBucket).ForEach(func(_, value []byte) error {
+			var record transferRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].SubmittedAt.Before(records[j].SubmittedAt)
+	})
+	return records, nil
+}
+
+// pending returns the transfers submitted but not yet confirmed, oldest first.
+func (l *transferLedger) pending() ([]transferRecord, error) {
+	records, err := l.list()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]transferRecord, 0)
+	for _, record := range records {
+		if record.Status == transferStatusSubmitted {
+			pending = append(pending, record)
+		}
+	}
+	return pending, nil
+}
+
+func (l *transferLedger) saveLastTransferRun(lastRun time.Time) error {
+	value, err := lastRun.UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put(lastTransferRunKey, value)
+	})
+}
+
+// lastTransferRun returns the time of the last transfer run, or zero time if none happened yet.
+func (l *transferLedger) lastTransferRun() (time.Time, error) {
+	var lastRun time.Time
+	err := l.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(stateBucket).Get(lastTransferRunKey)
+		if value == nil {
+			return nil
+		}
+		return lastRun.UnmarshalText(value)
+	})
+	return lastRun, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransferLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	ledger, err := newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+
+	now := time.Now().UTC()
+	amount, _ := ALPHFromALPHString("12.5")
+	assert.Nil(t, ledger.save(transferRecord{TxId: "tx2", SubmittedAt: now, Status: transferStatusSubmitted}))
+	assert.Nil(t, ledger.save(transferRecord{TxId: "tx1", SubmittedAt: now.Add(-time.Hour), Amount: amount,
+		BlockHash: "block1", Status: transferStatusConfirmed}))
+	assert.Nil(t, ledger.Close())
+
+	// Reopen to ensure the records survive a restart
+	ledger, err = newTransferLedger(path, time.UTC)
+	assert.Nil(t, err)
+	defer ledger.Close()
+
+	records, err := ledger.list()
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "tx1", records[0].TxId)
+	assert.Equal(t, 0, records[0].Amount.Cmp(amount))
+
+	pending, err := ledger.pending()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "tx2", pending[0].TxId)
+
+	record, err := ledger.get("tx3")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+}
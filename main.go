@@ -2,11 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	alephium "github.com/alephium/go-sdk"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/sirupsen/logrus"
 	"github.com/sqooba/go-common/healthchecks"
 	"github.com/sqooba/go-common/logging"
 	"github.com/sqooba/go-common/version"
@@ -15,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -26,20 +25,69 @@ var (
 )
 
 type envConfig struct {
-	Port     string `envconfig:"PORT" default:"8080"`
-	LogLevel string `envconfig:"LOG_LEVEL" default:"debug"`
-
-	AlephiumEndpoint         string        `envconfig:"ALEPHIUM_ENDPOINT" default:"http://alephium:12973"`
-	AlephiumApiKey           string        `envconfig:"ALEPHIUM_API_KEY" default:""`
-	WalletName               string        `envconfig:"WALLET_NAME" default:"mining-companion-wallet-1"`
-	WalletPassword           string        `envconfig:"WALLET_PASSWORD" default:"Default-Password-1234"`
-	WalletMnemonic           string        `envconfig:"WALLET_MNEMONIC" default:""`
-	WalletMnemonicPassphrase string        `envconfig:"WALLET_MNEMONIC_PASSPHRASE" default:""`
-	TransferMinAmount        string        `envconfig:"TRANSFER_MIN_AMOUNT" default:"20000000000000000000"`
-	TransferAddress          string        `envconfig:"TRANSFER_ADDRESS" default:""`
-	TransferFrequency        time.Duration `envconfig:"TRANSFER_FREQUENCY" default:"15m"`
-	PrintMnemonic            bool          `envconfig:"PRINT_MNEMONIC" default:"false"`
-	ImmediateTransfer        bool          `envconfig:"IMMEDIATE_TRANSFER" default:"false"`
+	Port       string `envconfig:"PORT" default:"8080"`
+	LogLevel   string `envconfig:"LOG_LEVEL" default:"debug"`
+	ConfigFile string `envconfig:"CONFIG_FILE" default:""`
+
+	AlephiumEndpoint             string        `envconfig:"ALEPHIUM_ENDPOINT" default:"http://alephium:12973"`
+	AlephiumApiKey               string        `envconfig:"ALEPHIUM_API_KEY" default:""`
+	AlephiumApiKeyFile           string        `envconfig:"ALEPHIUM_API_KEY_FILE" default:""`
+	NodeProbeInterval            time.Duration `envconfig:"NODE_PROBE_INTERVAL" default:"15s"`
+	WalletName                   string        `envconfig:"WALLET_NAME" default:"mining-companion-wallet-1"`
+	WalletPassword               string        `envconfig:"WALLET_PASSWORD" default:"Default-Password-1234"`
+	WalletMnemonic               string        `envconfig:"WALLET_MNEMONIC" default:""`
+	WalletMnemonicPassphrase     string        `envconfig:"WALLET_MNEMONIC_PASSPHRASE" default:""`
+	WalletPasswordFile           string        `envconfig:"WALLET_PASSWORD_FILE" default:""`
+	WalletMnemonicFile           string        `envconfig:"WALLET_MNEMONIC_FILE" default:""`
+	WalletMnemonicPassphraseFile string        `envconfig:"WALLET_MNEMONIC_PASSPHRASE_FILE" default:""`
+	AllowDefaultWalletPassword   bool          `envconfig:"ALLOW_DEFAULT_WALLET_PASSWORD" default:"false"`
+	VaultAddr                    string        `envconfig:"VAULT_ADDR" default:""`
+	VaultNamespace               string        `envconfig:"VAULT_NAMESPACE" default:""`
+	VaultToken                   string        `envconfig:"VAULT_TOKEN" default:""`
+	VaultTokenFile               string        `envconfig:"VAULT_TOKEN_FILE" default:""`
+	VaultRoleId                  string        `envconfig:"VAULT_ROLE_ID" default:""`
+	VaultSecretId                string        `envconfig:"VAULT_SECRET_ID" default:""`
+	VaultSecretIdFile            string        `envconfig:"VAULT_SECRET_ID_FILE" default:""`
+	VaultApproleMount            string        `envconfig:"VAULT_APPROLE_MOUNT" default:"approle"`
+	VaultKvMount                 string        `envconfig:"VAULT_KV_MOUNT" default:"secret"`
+	VaultSecretPath              string        `envconfig:"VAULT_SECRET_PATH" default:""`
+	NotificationsConfig          string        `envconfig:"NOTIFICATIONS_CONFIG" default:""`
+	NotificationRetries          int           `envconfig:"NOTIFICATION_RETRIES" default:"5"`
+	NotificationRetryBackoff     time.Duration `envconfig:"NOTIFICATION_RETRY_BACKOFF" default:"2s"`
+	NotificationDeadLetterPath   string        `envconfig:"NOTIFICATION_DEAD_LETTER_PATH" default:""`
+	EarningsSummarySchedule      string        `envconfig:"EARNINGS_SUMMARY_SCHEDULE" default:"0 0 * * *"`
+	SmtpHost                     string        `envconfig:"SMTP_HOST" default:""`
+	SmtpPort                     int           `envconfig:"SMTP_PORT" default:"587"`
+	SmtpUsername                 string        `envconfig:"SMTP_USERNAME" default:""`
+	SmtpPassword                 string        `envconfig:"SMTP_PASSWORD" default:""`
+	SmtpPasswordFile             string        `envconfig:"SMTP_PASSWORD_FILE" default:""`
+	SmtpStartTLS                 bool          `envconfig:"SMTP_STARTTLS" default:"true"`
+	SmtpFrom                     string        `envconfig:"SMTP_FROM" default:""`
+	SmtpTo                       []string      `envconfig:"SMTP_TO" default:""`
+	EmailReportSchedule          string        `envconfig:"EMAIL_REPORT_SCHEDULE" default:"0 8 * * MON"`
+	TransferMinAmount            string        `envconfig:"TRANSFER_MIN_AMOUNT" default:"20000000000000000000"`
+	TransferAddress              string        `envconfig:"TRANSFER_ADDRESS" default:""`
+	TransferKeepReserve          string        `envconfig:"TRANSFER_KEEP_RESERVE" default:"0"`
+	TransferKeepReserveScope     string        `envconfig:"TRANSFER_KEEP_RESERVE_SCOPE" default:"address"`
+	TransferFrequency            time.Duration `envconfig:"TRANSFER_FREQUENCY" default:"15m"`
+	TransferSchedule             string        `envconfig:"TRANSFER_SCHEDULE" default:""`
+	TransferScheduleTimezone     string        `envconfig:"TRANSFER_SCHEDULE_TIMEZONE" default:"UTC"`
+	TransferCatchUp              bool          `envconfig:"TRANSFER_CATCH_UP" default:"false"`
+	TransferAddressCheckNode     bool          `envconfig:"TRANSFER_ADDRESS_CHECK_NODE" default:"false"`
+	PrintMnemonic                bool          `envconfig:"PRINT_MNEMONIC" default:"false"`
+	DryRun                       bool          `envconfig:"DRY_RUN" default:"false"`
+	ImmediateTransfer            bool          `envconfig:"IMMEDIATE_TRANSFER" default:"false"`
+	LedgerPath                   string        `envconfig:"LEDGER_PATH" default:""`
+	HealthCheckPeriod            time.Duration `envconfig:"HEALTH_CHECK_PERIOD" default:"30s"`
+	BlockWatchInterval           time.Duration `envconfig:"BLOCK_WATCH_INTERVAL" default:"30s"`
+	HashrateWindow               time.Duration `envconfig:"HASHRATE_WINDOW" default:"1h"`
+	ExpectedHashrate             string        `envconfig:"EXPECTED_HASHRATE" default:""`
+	NoBlockAlertMultiple         float64       `envconfig:"NO_BLOCK_ALERT_MULTIPLE" default:"5"`
+	RestartInitialBackoff        time.Duration `envconfig:"RESTART_INITIAL_BACKOFF" default:"5s"`
+	RestartMaxBackoff            time.Duration `envconfig:"RESTART_MAX_BACKOFF" default:"5m"`
+	ShutdownGracePeriod          time.Duration `envconfig:"SHUTDOWN_GRACE_PERIOD" default:"25s"`
+	AdminApiToken                string        `envconfig:"ADMIN_API_TOKEN" default:""`
+	FleetConfig                  string        `envconfig:"FLEET_CONFIG" default:""`
 
 	MetricsNamespace string `envconfig:"METRICS_NAMESPACE" default:"alephium"`
 	MetricsSubsystem string `envconfig:"METRICS_SUBSYSTEM" default:"miningcompanion"`
@@ -60,15 +108,15 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
-	var env envConfig
-	if err := envconfig.Process("", &env); err != nil {
+	env, err := loadEnvConfig()
+	if err != nil {
 		log.Fatalf("Failed to process env var: %s\n", err)
 		return
 	}
 
 	flag.Parse()
 
-	err := logging.SetLogLevel(log, env.LogLevel)
+	err = logging.SetLogLevel(log, env.LogLevel)
 	if err != nil {
 		log.Fatalf("Logging level %s do not seem to be right. Err = %v", env.LogLevel, err)
 	}
@@ -81,121 +129,204 @@ func main() {
 		logging.SetRemoteLogLevelAndExit(log, env.Port, *setLogLevel)
 	}
 
-	if env.WalletName == "" || env.WalletPassword == "" {
-		log.Fatalf("Some mandatory configuration parameters are missing. Please correct the config and retry.")
-	}
-	if env.WalletPassword == DefaultWalletPassword {
-		log.Warnf("Your using the default password. This is not recommanded for production use.")
+	// Register health checks and metrics, one set of metrics per node of the fleet if any
+	healthChecks := initHealthChecks(env, http.DefaultServeMux)
+	names := []string{""}
+	envs := []envConfig{env}
+	var fleet *fleetConfig
+	var allMetrics []*metrics
+	if env.FleetConfig != "" {
+		fleet, err = loadFleetConfig(env.FleetConfig)
+		if err != nil {
+			log.Fatalf("FLEET_CONFIG %s is not valid. Err = %v", env.FleetConfig, err)
+		}
+		envs, err = fleet.envConfigs(env)
+		if err != nil {
+			log.Fatalf("FLEET_CONFIG %s is not valid. Err = %v", env.FleetConfig, err)
+		}
+		names = fleet.names()
+		allMetrics = initFleetPrometheus(env, names, http.DefaultServeMux)
+		log.Infof("Managing a fleet of %d nodes: %v.", len(names), names)
+	} else {
+		allMetrics = []*metrics{initPrometheus(env, http.DefaultServeMux)}
 	}
 
-	// Register health checks and metrics
-	initHealthChecks(env, http.DefaultServeMux)
-	metrics := initPrometheus(env, http.DefaultServeMux)
-
 	// Special endpoint to change the verbosity at runtime, i.e. curl -X PUT --data debug ...
 	logging.InitVerbosityHandler(log, http.DefaultServeMux)
 
-	// errgroup will coordinate the many routines handling the API.
-	cancellableCtx, cancel := context.WithCancel(context.Background())
-	g, ctx := errgroup.WithContext(cancellableCtx)
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-
-	s := http.Server{Addr: fmt.Sprint(":", env.Port)}
-	g.Go(s.ListenAndServe)
-
-	alephiumConfig := alephium.NewConfiguration()
-	alephiumConfig.Host = env.AlephiumEndpoint
-	if log.Level >= logrus.TraceLevel {
-		alephiumConfig.Debug = true
-	}
-	if env.AlephiumApiKey != "" {
-		alephiumConfig.DefaultHeader["X-API-KEY"] = env.AlephiumApiKey
-	}
-	alephiumClient := alephium.NewAPIClient(alephiumConfig)
-	if err != nil {
-		log.WithError(err).Fatalf("Got an error instantiating alephium client on %s", env.AlephiumEndpoint)
+	companions := make([]*companion, 0, len(envs))
+	supervisors := make([]*supervisor, 0, len(envs)+1)
+	for _, memberEnv := range envs {
+		err = memberEnv.validate()
+		if err != nil {
+			log.Fatalf("Some configuration parameters are not valid. Please correct the config and retry. Err = %v", err)
+		}
 	}
 
-	miningHandler, err := newMiningHandler(alephiumClient, env.WalletName, env.WalletPassword,
-		env.WalletMnemonic, env.WalletMnemonicPassphrase, env.PrintMnemonic, log)
-	if err != nil {
-		log.Fatalf("Got an error while creating the wallet handler. Err = %v", err)
+	// The wallet secrets are read from vault if set, one vault client being shared by the fleet
+	var vault *vaultClient
+	var vaultSupervisor *supervisor
+	if env.VaultAddr != "" {
+		vault, err = newVaultClient(env.VaultAddr, env.VaultNamespace, env.VaultKvMount, env.vaultTokenSecret(),
+			env.VaultRoleId, env.vaultSecretIdSecret(), env.VaultApproleMount, log)
+		if err != nil {
+			log.Fatalf("VAULT_ADDR %s is not valid. Err = %v", env.VaultAddr, err)
+		}
+		vaultSupervisor = newSupervisor(env.RestartInitialBackoff, env.RestartMaxBackoff, allMetrics[0], log)
+		supervisors = append(supervisors, vaultSupervisor)
+		log.Infof("Reading the wallet secrets from vault %s.", env.VaultAddr)
 	}
 
-	wallet, err := miningHandler.createAndUnlockWallet(ctx, logrus.NewEntry(log))
+	// The events are delivered in the background, the ones still queued at shutdown get the grace period
+	reporter, err := initEmailReporter(env, log)
 	if err != nil {
-		log.Fatalf("Got an error while creating and/or unlocking the wallet %s. Err = %v", env.WalletName, err)
+		log.Fatalf("SMTP settings are not valid. Err = %v", err)
 	}
-
-	err = miningHandler.updateMinersAddresses(ctx, logrus.NewEntry(log))
+	notifier, err := initNotifier(env, reporter, log)
 	if err != nil {
-		log.Fatalf("Got an error while updating miners addresses. Err = %v", err)
+		log.Fatalf("NOTIFICATIONS_CONFIG %s is not valid. Err = %v", env.NotificationsConfig, err)
 	}
+	defer notifier.Close(env.ShutdownGracePeriod)
 
-	minersAddressesReq := alephiumClient.MinersApi.GetMinersAddresses(ctx)
-	minersAddresses, _, err := minersAddressesReq.Execute()
-	if err != nil {
-		log.WithError(err).Fatalf("Got an error calling miners addresses")
+	for i, memberEnv := range envs {
+		c, err := newCompanion(names[i], memberEnv, vault, notifier.withNode(names[i]), allMetrics[i], log)
+		if err != nil {
+			log.Fatalf("Got an error while setting up wallet %s on %s. Err = %v", memberEnv.WalletName, memberEnv.AlephiumEndpoint, err)
+		}
+		defer c.Close()
+		companions = append(companions, c)
+		supervisors = append(supervisors, c.supervisor)
+		reporter.watch(names[i], c.addressBalanceStats, c.ledger)
 	}
-	log.Infof("Mining wallet %s (with addresses %v) is ready to be used, now waiting for the node to become in sync if needed.",
-		wallet.WalletName, minersAddresses.Addresses)
-
-	err = miningHandler.waitForNodeInSync(ctx, logrus.NewEntry(log))
-	if err != nil {
-		log.Fatalf("Got an error while waiting for the node to be in sync with peers. Err = %v", err)
+	// The secrets are only kept in their secret from now on, the fleet config being read again on reload
+	env.clearSecrets()
+	for i := range envs {
+		envs[i].clearSecrets()
+	}
+	var reportSupervisor *supervisor
+	if reporter != nil && reporter.schedule != nil {
+		reportSupervisor = newSupervisor(env.RestartInitialBackoff, env.RestartMaxBackoff, allMetrics[0], log)
+		supervisors = append(supervisors, reportSupervisor)
+		log.Infof("Emailing the mining report to %v on schedule %s (%s).", env.SmtpTo, env.EmailReportSchedule,
+			env.TransferScheduleTimezone)
 	}
+
+	// errgroup will coordinate the many routines handling the API.
+	cancellableCtx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(cancellableCtx)
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	s := http.Server{Addr: fmt.Sprint(":", env.Port)}
 	g.Go(func() error {
-		return miningHandler.ensureMiningWalletAndNodeMining(ctx, logrus.NewEntry(log))
+		err := s.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
 	})
 
-	addressesToWatch := make([]string, 0, len(minersAddresses.Addresses)+1)
-	for _, a := range minersAddresses.Addresses {
-		addressesToWatch = append(addressesToWatch, a)
+	// The background loops are restarted on transient errors, a fatal error stops the whole group.
+	http.DefaultServeMux.HandleFunc("/supervisor/status", supervisorStatusHandler(supervisors))
+	// loops tracks the background loops and the transfers triggered through the admin API, which must be done
+	// before shutting down the http server.
+	loops := &sync.WaitGroup{}
+	runLoop := func(loopSupervisor *supervisor, name string, loop func(ctx context.Context) error) {
+		loops.Add(1)
+		g.Go(func() error {
+			defer loops.Done()
+			return loopSupervisor.run(ctx, name, loop)
+		})
+	}
+
+	if vault != nil {
+		runLoop(vaultSupervisor, "vault-token", vault.renewToken)
 	}
-	if env.TransferAddress != "" {
-		addressesToWatch = append(addressesToWatch, env.TransferAddress)
+	if reportSupervisor != nil {
+		runLoop(reportSupervisor, "email-report", reporter.run)
 	}
-	addressBalanceStats, _ := newAddressBalanceStats(alephiumClient, addressesToWatch, metrics)
-	g.Go(func() error { return addressBalanceStats.Stats(ctx) })
 
-	if env.TransferAddress != "" {
-		transferHandler, err := newTransferHandler(alephiumClient, wallet.WalletName, env.WalletPassword,
-			env.WalletMnemonicPassphrase, env.TransferAddress, env.TransferMinAmount, env.TransferFrequency,
-			env.ImmediateTransfer, metrics, log)
-		if err != nil {
-			log.WithError(err).Fatalf("Got an error while instanciating the transfer handler")
-		}
+	var adminAPI *adminAPI
+	if env.AdminApiToken != "" {
+		adminAPI = newAdminAPI(ctx, env.AdminApiToken, loops, log)
+	}
 
-		log.Infof("We will transfer to %s the mining reward every %s.", env.TransferAddress, env.TransferFrequency)
+	for _, c := range companions {
+		c := c
+		if c.failoverClient != nil {
+			log.Infof("Using nodes %s with failover, probing them every %s.", c.env.AlephiumEndpoint, env.NodeProbeInterval)
+			go c.failoverClient.watch(ctx, env.NodeProbeInterval)
+		}
+		healthChecks.registerNodeChecks(ctx, c.qualify(""), c.miningHandler, env.HealthCheckPeriod)
+		if adminAPI != nil {
+			adminAPI.addMember(c.name, c.alephiumClient, c.env.WalletName, c.transferHandler, c.ledger)
+		}
 
-		g.Go(func() error {
-			err := transferHandler.handle(ctx, logrus.NewEntry(log))
+		// The wallet is set up and the node waited for in the background, so that the nodes of a fleet
+		// don't wait for each other. The other loops start once done.
+		starting := healthChecks.registerStartup(c.qualify("startup"))
+		runLoop(c.supervisor, c.qualify("startup"), func(ctx context.Context) error {
+			minersAddresses, err := c.start(ctx)
 			if err != nil {
-				log.WithError(err).Fatalf("Got an error while handling the transfer")
+				return err
 			}
-			return err
+			starting.Update(nil)
+			c.run(minersAddresses, healthChecks, http.DefaultServeMux, runLoop)
+			return nil
 		})
-	} else {
-		log.Infof("No transfer address configure, no problem, job is done.")
-		cancel()
+	}
+
+	if adminAPI != nil {
+		adminAPI.register(http.DefaultServeMux)
+		log.Infof("Admin API enabled on /api.")
+	}
+
+	if env.ConfigFile != "" {
+		current := env
+		go watchConfigFile(ctx, env.ConfigFile, func() {
+			reloaded, err := reloadConfig(current, companions, log)
+			if err != nil {
+				log.WithError(err).Errorf("Config file %s is not valid, keeping the current settings", env.ConfigFile)
+				return
+			}
+			current = reloaded
+		}, log)
+		log.Infof("Watching config file %s, the transfer settings are reloaded when it changes or on SIGHUP.", env.ConfigFile)
 	}
 
 	// Wait for any shutdown
 	select {
 	case <-signalChan:
 		log.Info("Shutdown signal received, exiting...")
-		cancel()
-		break
 	case <-ctx.Done():
 		log.Info("Group context is done, exiting...")
-		cancel()
-		break
+	}
+	cancel()
+
+	// The loops stop scheduling new transfers right away, while the transfers in flight get the grace period
+	// to be confirmed. The http server keeps serving the probes and the metrics meanwhile.
+	loopsDone := make(chan struct{})
+	go func() {
+		loops.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-time.After(env.ShutdownGracePeriod + 5*time.Second):
+		log.Fatalf("Background loops are still running after the shutdown grace period of %s, exiting anyway.",
+			env.ShutdownGracePeriod)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	err = s.Shutdown(shutdownCtx)
+	if err != nil {
+		log.WithError(err).Warnf("Got an error while shutting down the http server")
 	}
 
-	err = ctx.Err()
+	err = g.Wait()
 	if err != nil {
-		log.WithError(err).Fatal("Got an error from the error group context")
+		log.WithError(err).Fatal("Got an error from the error group")
 	}
 
 	log.Infof("All good, stopping now.")
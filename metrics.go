@@ -9,50 +9,269 @@ import (
 
 type metrics struct {
 	transferRun          prometheus.Counter
-	txAmount             prometheus.Counter
+	transferNextRun      prometheus.Gauge
+	transferPaused       prometheus.Gauge
+	txAmount             *prometheus.CounterVec
+	txFees               *prometheus.CounterVec
+	transferDecision     *prometheus.CounterVec
+	dryRunTxs            *prometheus.CounterVec
+	dryRunAmount         *prometheus.CounterVec
+	dryRunFees           *prometheus.CounterVec
 	addressTotalBalance  *prometheus.GaugeVec
 	addressLockedBalance *prometheus.GaugeVec
 	addressUtxos         *prometheus.GaugeVec
+	loopRestarts         *prometheus.CounterVec
+	loopLastError        *prometheus.GaugeVec
+	loopUp               *prometheus.GaugeVec
+	nodeUp               *prometheus.GaugeVec
+	nodeSynced           *prometheus.GaugeVec
+	nodeLatency          *prometheus.GaugeVec
+	nodeFailovers        *prometheus.CounterVec
+	blocksMined          *prometheus.CounterVec
+	coinbaseReward       *prometheus.CounterVec
+	sinceLastBlock       *prometheus.GaugeVec
+	expectedInterval     prometheus.Gauge
+	blocksOverdue        prometheus.Gauge
+	effectiveHashrate    *prometheus.GaugeVec
+	networkHashrate      prometheus.Gauge
+	networkShare         prometheus.Gauge
 }
 
 func initPrometheus(env envConfig, mux *http.ServeMux) *metrics {
+	m := newMetrics(env, nil)
+	mux.Handle(env.MetricsPath, promhttp.Handler())
+	return m
+}
+
+// initFleetPrometheus registers the metrics of each node of a fleet, labelled with its name.
+func initFleetPrometheus(env envConfig, names []string, mux *http.ServeMux) []*metrics {
+	fleetMetrics := make([]*metrics, 0, len(names))
+	for _, name := range names {
+		fleetMetrics = append(fleetMetrics, newMetrics(env, prometheus.Labels{"node": name}))
+	}
+	mux.Handle(env.MetricsPath, promhttp.Handler())
+	return fleetMetrics
+}
+
+// newMetrics registers the metrics, with constLabels on all of them, i.e. the name of the node in a fleet.
+func newMetrics(env envConfig, constLabels prometheus.Labels) *metrics {
 	m := &metrics{}
 
 	m.transferRun = promauto.NewCounter(prometheus.CounterOpts{
-		Name:      "transfer_runs_count",
-		Help:      "Number of transfer runs",
-		Namespace: env.MetricsNamespace,
-		Subsystem: env.MetricsSubsystem,
+		Name:        "transfer_runs_count",
+		Help:        "Number of transfer runs",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	})
+
+	m.transferNextRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "transfer_next_run_timestamp_seconds",
+		Help:        "Unix timestamp of the next planned transfer run",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
 	})
 
-	m.txAmount = promauto.NewCounter(prometheus.CounterOpts{
-		Name:      "transfer_amount_total",
-		Help:      "Amount transferred",
-		Namespace: env.MetricsNamespace,
-		Subsystem: env.MetricsSubsystem,
+	m.transferPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "transfer_paused",
+		Help:        "Whether the planned transfer runs are paused (1) or not (0)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
 	})
 
+	m.txAmount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "transfer_amount_total",
+		Help:        "Amount transferred, in ALPH",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.txFees = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "transfer_fees_total",
+		Help:        "Fees paid for the transfers, in ALPH",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.transferDecision = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "transfer_address_decisions_total",
+		Help:        "Number of sweep decisions per address, i.e. swept or skipped because below the min amount",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"address", "decision"})
+
+	m.dryRunTxs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "dry_run_txs_total",
+		Help:        "Number of txs built but not submitted in dry run mode",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.dryRunAmount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "dry_run_amount_total",
+		Help:        "Amount which would have been transferred in dry run mode, in ALPH",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.dryRunFees = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "dry_run_fees_total",
+		Help:        "Estimated fees of the txs built in dry run mode, in ALPH",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
 	m.addressTotalBalance = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name:      "total_balance",
-		Help:      "Total balance of the address",
-		Namespace: env.MetricsNamespace,
-		Subsystem: env.MetricsSubsystem,
+		Name:        "total_balance",
+		Help:        "Total balance of the address",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
 	}, []string{"address"})
 
 	m.addressLockedBalance = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name:      "locked_balance",
-		Help:      "Locked balance of the address",
-		Namespace: env.MetricsNamespace,
-		Subsystem: env.MetricsSubsystem,
+		Name:        "locked_balance",
+		Help:        "Locked balance of the address",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
 	}, []string{"address"})
 
 	m.addressUtxos = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name:      "utxos",
-		Help:      "Number of UTXOs of the address",
-		Namespace: env.MetricsNamespace,
-		Subsystem: env.MetricsSubsystem,
+		Name:        "utxos",
+		Help:        "Number of UTXOs of the address",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
 	}, []string{"address"})
 
-	mux.Handle(env.MetricsPath, promhttp.Handler())
+	m.loopRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "loop_restarts_total",
+		Help:        "Number of restarts of the background loops after an error",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"loop"})
+
+	m.loopLastError = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "loop_last_error_timestamp_seconds",
+		Help:        "Unix timestamp of the last error of the background loops, per error class (transient or fatal)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"loop", "class"})
+
+	m.loopUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "loop_up",
+		Help:        "Whether the background loop is running (1) or waiting for a restart or stopped (0)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"loop"})
+
+	m.nodeUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "node_up",
+		Help:        "Whether the node answered the last probe (1) or not (0)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})
+
+	m.nodeSynced = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "node_synced",
+		Help:        "Whether the node is in sync with at least one peer (1) or not (0)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})
+
+	m.nodeLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "node_latency_seconds",
+		Help:        "Moving average of the latency of the node probes",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})
+
+	m.nodeFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "node_failovers_total",
+		Help:        "Number of requests failed over from a node to another one",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from", "to"})
+
+	m.blocksMined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "blocks_mined_total",
+		Help:        "Number of blocks whose coinbase pays the miner addresses",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.coinbaseReward = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        "coinbase_reward_total",
+		Help:        "Coinbase rewards paid to the miner addresses, in ALPH",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"from_group", "to_group"})
+
+	m.sinceLastBlock = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "seconds_since_last_block_mined",
+		Help:        "Time since the last block mined to the miner address of the group, or since the watcher started",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"group"})
+
+	m.expectedInterval = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "expected_block_interval_seconds",
+		Help:        "Expected interval between two blocks mined at EXPECTED_HASHRATE given the network difficulty",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	})
+
+	m.blocksOverdue = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "blocks_overdue",
+		Help:        "Whether no block was mined for NO_BLOCK_ALERT_MULTIPLE times the expected interval (1) or not (0)",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	})
+
+	m.effectiveHashrate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "effective_hashrate",
+		Help:        "Hashrate of the miners of the group estimated from the blocks mined over HASHRATE_WINDOW, in H/s",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	}, []string{"group"})
+
+	m.networkHashrate = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "network_hashrate",
+		Help:        "Hashrate of the network over HASHRATE_WINDOW as computed by the node, in H/s",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	})
+
+	m.networkShare = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "network_hashrate_share",
+		Help:        "Share of the hashrate of the network estimated for the miners, from 0 to 1",
+		Namespace:   env.MetricsNamespace,
+		Subsystem:   env.MetricsSubsystem,
+		ConstLabels: constLabels,
+	})
+
 	return m
 }
@@ -2,35 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
 	alephium "github.com/alephium/go-sdk"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"strings"
 	"time"
 )
 
 type miningHandler struct {
-	alephiumClient           *alephium.APIClient
-	walletName               string
-	walletPassword           string
-	walletMnemonic           string
-	walletMnemonicPassphrase string
-	printMnemonic            bool
-	log                      *logrus.Logger
+	alephiumClient   nodeClient
+	walletName       string
+	secrets          secretProvider
+	printMnemonic    bool
+	syncPollInterval time.Duration
+	notifier         *notifier
+	log              *logrus.Logger
 }
 
-func newMiningHandler(alephiumClient *alephium.APIClient, walletName string, walletPassword string,
-	walletMnemonic string, walletMnemonicPassphrase string, printMnemonic bool,
-	log *logrus.Logger) (*miningHandler, error) {
+// newMiningHandler returns the handler of the mining wallet walletName, secrets giving its password, mnemonic
+// and mnemonic passphrase.
+func newMiningHandler(alephiumClient nodeClient, walletName string, secrets secretProvider, printMnemonic bool,
+	notifier *notifier, log *logrus.Logger) (*miningHandler, error) {
 
 	handler := &miningHandler{
-		alephiumClient:           alephiumClient,
-		walletName:               walletName,
-		walletPassword:           walletPassword,
-		walletMnemonic:           walletMnemonic,
-		walletMnemonicPassphrase: walletMnemonicPassphrase,
-		printMnemonic:            printMnemonic,
-		log:                      log,
+		alephiumClient:   alephiumClient,
+		walletName:       walletName,
+		secrets:          secrets,
+		printMnemonic:    printMnemonic,
+		syncPollInterval: 30 * time.Second,
+		notifier:         notifier,
+		log:              log,
 	}
 
 	return handler, nil
@@ -38,6 +39,9 @@ func newMiningHandler(alephiumClient *alephium.APIClient, walletName string, wal
 
 func hasSameAddresses(minerAddresses *alephium.MinerAddresses, walletAddresses *alephium.Addresses) bool {
 
+	if minerAddresses == nil || walletAddresses == nil {
+		return false
+	}
 	if len(minerAddresses.Addresses) <= 1 || len(minerAddresses.Addresses) != len(walletAddresses.Addresses) {
 		return false
 	}
@@ -60,19 +64,25 @@ func (h *miningHandler) createAndUnlockWallet(ctx context.Context, log *logrus.E
 
 	walletFound, err := checkWalletExist(ctx, h.alephiumClient, h.walletName, log)
 	if err != nil {
-		h.log.WithError(err).Debugf("Got an error calling CheckWalletExist endpoint %s", h.alephiumClient.GetConfig().Host)
+		h.log.WithError(err).Debugf("Got an error calling CheckWalletExist endpoint %s", h.alephiumClient.Host())
 		return nil, err
 	}
 
 	var wallet *alephium.WalletStatus
 	if !walletFound {
 		h.log.Infof("Wallet %s not found, creating or restoring it now.", h.walletName)
-		if h.walletMnemonic != "" {
+		password, mnemonic, passphrase, err := h.revealWalletSecrets(ctx)
+		if err != nil {
+			h.log.WithError(err).Debugf("Got an error while reading the secrets of wallet %s", h.walletName)
+			return nil, err
+		}
+		if len(mnemonic) > 0 {
 
-			restoredWallet, err := restoreWallet(ctx, h.alephiumClient, h.walletName, h.walletPassword, h.walletMnemonic,
-				h.walletMnemonicPassphrase, true, log)
+			restoredWallet, err := restoreWallet(ctx, h.alephiumClient, h.walletName, string(password),
+				string(mnemonic), string(passphrase), true, log)
+			wipe(password, mnemonic, passphrase)
 			if err != nil {
-				h.log.WithError(err).Debugf("Got an error calling wallet restore endpoint %v", h.alephiumClient.GetConfig().Host)
+				h.log.WithError(err).Debugf("Got an error calling wallet restore endpoint %v", h.alephiumClient.Host())
 				return nil, err
 			}
 
@@ -83,11 +93,11 @@ func (h *miningHandler) createAndUnlockWallet(ctx context.Context, log *logrus.E
 			}
 		} else {
 
-			createdWallet, err := createWallet(ctx, h.alephiumClient, h.walletName, h.walletPassword,
-				h.walletMnemonicPassphrase, true, log)
-
+			createdWallet, err := createWallet(ctx, h.alephiumClient, h.walletName, string(password),
+				string(passphrase), true, log)
+			wipe(password, passphrase)
 			if err != nil {
-				h.log.WithError(err).Debugf("Got an error calling wallet create endpoint %v. Err = %v", h.alephiumClient.GetConfig().Host)
+				h.log.WithError(err).Debugf("Got an error calling wallet create endpoint %v", h.alephiumClient.Host())
 				return nil, err
 			}
 			if h.printMnemonic {
@@ -100,6 +110,7 @@ func (h *miningHandler) createAndUnlockWallet(ctx context.Context, log *logrus.E
 				return nil, err
 			}
 		}
+		h.notifier.notify(event{Type: eventWalletCreated, Endpoint: h.alephiumClient.Host(), Wallet: h.walletName})
 	} else {
 		wallet, err = getWalletStatus(ctx, h.alephiumClient, h.walletName, log)
 		if err != nil {
@@ -109,7 +120,7 @@ func (h *miningHandler) createAndUnlockWallet(ctx context.Context, log *logrus.E
 	}
 
 	if wallet.Locked {
-		err := unlockWallet(ctx, h.alephiumClient, h.walletName, h.walletPassword, h.walletMnemonicPassphrase, log)
+		err := h.unlockWallet(ctx, log)
 		if err != nil {
 			h.log.WithError(err).Debugf("Got an error while unlocking the wallet %s", h.walletName)
 			return wallet, err
@@ -118,6 +129,32 @@ func (h *miningHandler) createAndUnlockWallet(ctx context.Context, log *logrus.E
 	return wallet, nil
 }
 
+// revealWalletSecrets returns the password, the mnemonic and the mnemonic passphrase of the wallet, only
+// read when the wallet must be created or restored, copies to wipe once the node got them. The node API
+// only takes strings, which can't be wiped and are left to the garbage collector.
+func (h *miningHandler) revealWalletSecrets(ctx context.Context) ([]byte, []byte, []byte, error) {
+	password, err := h.secrets.secret(ctx, walletPasswordSecret)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mnemonic, err := h.secrets.secret(ctx, walletMnemonicSecret)
+	if err != nil {
+		wipe(password)
+		return nil, nil, nil, err
+	}
+	passphrase, err := h.secrets.secret(ctx, walletMnemonicPassphraseSecret)
+	if err != nil {
+		wipe(password, mnemonic)
+		return nil, nil, nil, err
+	}
+	return password, mnemonic, passphrase, nil
+}
+
+// unlockWallet unlocks the mining wallet, reading its password and passphrase just for it.
+func (h *miningHandler) unlockWallet(ctx context.Context, log *logrus.Entry) error {
+	return unlockWalletWithSecrets(ctx, h.alephiumClient, h.walletName, h.secrets, log)
+}
+
 func (h *miningHandler) updateMinersAddresses(ctx context.Context, log *logrus.Entry) error {
 	minerAddresses, err := getMinersAddresses(ctx, h.alephiumClient, log)
 	if err != nil && !strings.HasPrefix(err.Error(), "Miner addresses are not set up") {
@@ -135,18 +172,21 @@ func (h *miningHandler) updateMinersAddresses(ctx context.Context, log *logrus.E
 		h.log.Debugf("Current miner addresses %v", minerAddresses)
 		h.log.Debugf("Mining wallet addresses %v", walletAddresses)
 
-		err = updateMinerAddresses(ctx, h.alephiumClient, GetAddressesAsString(walletAddresses.Addresses), log)
+		addresses := GetAddressesAsString(walletAddresses.Addresses)
+		err = updateMinerAddresses(ctx, h.alephiumClient, addresses, log)
 		if err != nil {
 			h.log.WithError(err).Debugf("Got an error calling update miners addresses")
 			return err
 		}
+		h.notifier.notify(event{Type: eventMinerAddressesChanged, Endpoint: h.alephiumClient.Host(),
+			Wallet: h.walletName, Addresses: addresses})
 	}
 	return nil
 }
 
 func (h *miningHandler) waitForNodeInSync(ctx context.Context, log *logrus.Entry) error {
 
-	_, err := WaitUntilSyncedWithAtLeastOnePeer(ctx, h.alephiumClient, 30*time.Second, log)
+	_, err := WaitUntilSyncedWithAtLeastOnePeer(ctx, h.alephiumClient, h.syncPollInterval, log)
 	if err != nil {
 		h.log.WithError(err).Debugf("Got an error waiting for the node to be in sync with peers")
 		return err
@@ -154,40 +194,78 @@ func (h *miningHandler) waitForNodeInSync(ctx context.Context, log *logrus.Entry
 	return nil
 }
 
-// ensureMiningWalletAndNodeMining
+// checkWallet verifies that the mining wallet exists and, if it got locked, that it can be unlocked again.
+func (h *miningHandler) checkWallet(ctx context.Context, log *logrus.Entry) error {
+	walletFound, err := checkWalletExist(ctx, h.alephiumClient, h.walletName, log)
+	if err != nil {
+		return err
+	}
+	if !walletFound {
+		return fmt.Errorf("wallet %s does not exist", h.walletName)
+	}
+	wallet, err := getWalletStatus(ctx, h.alephiumClient, h.walletName, log)
+	if err != nil {
+		return err
+	}
+	if wallet.Locked {
+		err = h.unlockWallet(ctx, log)
+		if err != nil {
+			return fmt.Errorf("wallet %s is locked and can't be unlocked: %w", h.walletName, err)
+		}
+	}
+	return nil
+}
+
+// checkMinerAddresses verifies that the node mines to the addresses of the mining wallet.
+func (h *miningHandler) checkMinerAddresses(ctx context.Context, log *logrus.Entry) error {
+	minerAddresses, err := getMinersAddresses(ctx, h.alephiumClient, log)
+	if err != nil {
+		return err
+	}
+	walletAddresses, err := getWalletAddresses(ctx, h.alephiumClient, h.walletName, log)
+	if err != nil {
+		return err
+	}
+	if !hasSameAddresses(minerAddresses, walletAddresses) {
+		return fmt.Errorf("miner addresses %v are not the ones of wallet %s", minerAddresses.Addresses, h.walletName)
+	}
+	return nil
+}
+
+// ensureMiningWalletAndNodeMining checks every 5 minutes that the node mines to the wallet addresses
+// and is in sync, until the context is done or an error occurs.
 func (h *miningHandler) ensureMiningWalletAndNodeMining(ctx context.Context, log *logrus.Entry) error {
-	for range time.Tick(5 * time.Minute) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 		err := h.updateMinersAddresses(ctx, log)
 		if err != nil {
-			h.log.Fatalf("Got an error while updating miners addresses. Err = %v", err)
+			h.log.WithError(err).Debugf("Got an error while updating miners addresses")
 			return err
 		}
 		err = h.waitForNodeInSync(ctx, log)
 		if err != nil {
-			h.log.Fatalf("Got an error while waiting for the node to be in sync with peers. Err = %v", err)
+			h.log.WithError(err).Debugf("Got an error while waiting for the node to be in sync with peers")
 			return err
 		}
 	}
-	return nil
 }
 
-func checkWalletExist(ctx context.Context, alephiumClient *alephium.APIClient, walletName string, log *logrus.Entry) (bool, error) {
-	walletNameRequest := alephiumClient.WalletsApi.GetWalletsWalletName(ctx, walletName)
-	_, resp, err := walletNameRequest.Execute()
-
-	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+func checkWalletExist(ctx context.Context, alephiumClient nodeClient, walletName string, log *logrus.Entry) (bool, error) {
+	walletFound, err := alephiumClient.CheckWalletExist(ctx, walletName)
+	if err != nil {
 		log.WithError(err).Debugf("Got an error while checking if the wallet %s exists", walletName)
 		return false, err
 	}
-
-	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
-		return false, nil
-	}
-
-	return true, nil
+	return walletFound, nil
 }
 
-func restoreWallet(ctx context.Context, alephiumClient *alephium.APIClient,
+func restoreWallet(ctx context.Context, alephiumClient nodeClient,
 	walletName, walletPassword, walletMnemonic, walletMnemonicPassphrase string,
 	isMiner bool, log *logrus.Entry) (*alephium.WalletRestoreResult, error) {
 
@@ -196,8 +274,7 @@ func restoreWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	if walletMnemonicPassphrase != "" {
 		walletRestore.SetMnemonicPassphrase(walletMnemonicPassphrase)
 	}
-	walletRestoreReq := alephiumClient.WalletsApi.PutWallets(ctx).WalletRestore(*walletRestore)
-	walletRestoreRes, _, err := walletRestoreReq.Execute()
+	walletRestoreRes, err := alephiumClient.RestoreWallet(ctx, *walletRestore)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while restoring wallet %s", walletName)
 		return nil, err
@@ -205,7 +282,7 @@ func restoreWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	return walletRestoreRes, nil
 }
 
-func createWallet(ctx context.Context, alephiumClient *alephium.APIClient,
+func createWallet(ctx context.Context, alephiumClient nodeClient,
 	walletName, walletPassword, walletMnemonicPassphrase string,
 	isMiner bool, log *logrus.Entry) (*alephium.WalletCreationResult, error) {
 
@@ -214,8 +291,7 @@ func createWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	if walletMnemonicPassphrase != "" {
 		walletCreation.SetMnemonicPassphrase(walletMnemonicPassphrase)
 	}
-	walletCreationReq := alephiumClient.WalletsApi.PostWallets(ctx).WalletCreation(*walletCreation)
-	walletCreationRes, _, err := walletCreationReq.Execute()
+	walletCreationRes, err := alephiumClient.CreateWallet(ctx, *walletCreation)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while restoring wallet %s", walletName)
 		return nil, err
@@ -223,7 +299,24 @@ func createWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	return walletCreationRes, nil
 }
 
-func unlockWallet(ctx context.Context, alephiumClient *alephium.APIClient,
+// unlockWalletWithSecrets unlocks the wallet, reading its password and passphrase from secrets just for the call.
+func unlockWalletWithSecrets(ctx context.Context, alephiumClient nodeClient, walletName string,
+	secrets secretProvider, log *logrus.Entry) error {
+
+	password, err := revealSecret(ctx, secrets, walletPasswordSecret)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while reading the password of wallet %s", walletName)
+		return err
+	}
+	passphrase, err := revealSecret(ctx, secrets, walletMnemonicPassphraseSecret)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while reading the mnemonic passphrase of wallet %s", walletName)
+		return err
+	}
+	return unlockWallet(ctx, alephiumClient, walletName, password, passphrase, log)
+}
+
+func unlockWallet(ctx context.Context, alephiumClient nodeClient,
 	walletName, walletPassword, walletMnemonicPassphrase string,
 	log *logrus.Entry) error {
 
@@ -231,9 +324,7 @@ func unlockWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	if walletMnemonicPassphrase != "" {
 		walletUnlock.SetMnemonicPassphrase(walletMnemonicPassphrase)
 	}
-	walletUnlockReq := alephiumClient.WalletsApi.PostWalletsWalletNameUnlock(ctx, walletName).
-		WalletUnlock(*walletUnlock)
-	_, err := walletUnlockReq.Execute()
+	err := alephiumClient.UnlockWallet(ctx, walletName, *walletUnlock)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while unlocking wallet %s", walletName)
 		return err
@@ -241,11 +332,10 @@ func unlockWallet(ctx context.Context, alephiumClient *alephium.APIClient,
 	return nil
 }
 
-func getWalletStatus(ctx context.Context, alephiumClient *alephium.APIClient,
+func getWalletStatus(ctx context.Context, alephiumClient nodeClient,
 	walletName string, log *logrus.Entry) (*alephium.WalletStatus, error) {
 
-	walletNameRequest := alephiumClient.WalletsApi.GetWalletsWalletName(ctx, walletName)
-	wallet, _, err := walletNameRequest.Execute()
+	wallet, err := alephiumClient.GetWalletStatus(ctx, walletName)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while calling wallet status %s", walletName)
 		return nil, err
@@ -253,11 +343,10 @@ func getWalletStatus(ctx context.Context, alephiumClient *alephium.APIClient,
 	return wallet, nil
 }
 
-func getWalletAddresses(ctx context.Context, alephiumClient *alephium.APIClient,
+func getWalletAddresses(ctx context.Context, alephiumClient nodeClient,
 	walletName string, log *logrus.Entry) (*alephium.Addresses, error) {
 
-	walletAddressesReq := alephiumClient.WalletsApi.GetWalletsWalletNameAddresses(ctx, walletName)
-	walletAddresses, _, err := walletAddressesReq.Execute()
+	walletAddresses, err := alephiumClient.GetWalletAddresses(ctx, walletName)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while calling get wallet addresses %s", walletName)
 		return nil, err
@@ -265,11 +354,76 @@ func getWalletAddresses(ctx context.Context, alephiumClient *alephium.APIClient,
 	return walletAddresses, nil
 }
 
-func getMinersAddresses(ctx context.Context, alephiumClient *alephium.APIClient,
+func getWalletBalances(ctx context.Context, alephiumClient nodeClient,
+	walletName string, log *logrus.Entry) (*alephium.Balances, error) {
+
+	walletBalances, err := alephiumClient.GetWalletBalances(ctx, walletName)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while calling get wallet balances %s", walletName)
+		return nil, err
+	}
+	return walletBalances, nil
+}
+
+func changeActiveAddress(ctx context.Context, alephiumClient nodeClient,
+	walletName string, address string, log *logrus.Entry) error {
+
+	err := alephiumClient.ChangeActiveAddress(ctx, walletName, address)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while changing active address of wallet %s to %s", walletName, address)
+		return err
+	}
+	return nil
+}
+
+func sweepActiveAddress(ctx context.Context, alephiumClient nodeClient,
+	walletName string, sweep alephium.Sweep, log *logrus.Entry) ([]alephium.TransferResult, error) {
+
+	transferRes, err := alephiumClient.SweepActiveAddress(ctx, walletName, sweep)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while sweeping active address of wallet %s", walletName)
+		return nil, err
+	}
+	return transferRes.GetResults(), nil
+}
+
+func buildTransaction(ctx context.Context, alephiumClient nodeClient,
+	buildTx alephium.BuildTransaction, log *logrus.Entry) (*alephium.BuildTransactionResult, error) {
+
+	builtTx, err := alephiumClient.BuildTransaction(ctx, buildTx)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while building a tx from %s", buildTx.FromPublicKey)
+		return nil, err
+	}
+	return builtTx, nil
+}
+
+func buildSweepAddressTransactions(ctx context.Context, alephiumClient nodeClient,
+	buildSweep alephium.BuildSweepAddressTransactions, log *logrus.Entry) (*alephium.BuildSweepAddressTransactionsResult, error) {
+
+	builtSweep, err := alephiumClient.BuildSweepAddressTransactions(ctx, buildSweep)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while building a sweep from %s", buildSweep.FromPublicKey)
+		return nil, err
+	}
+	return builtSweep, nil
+}
+
+func walletTransfer(ctx context.Context, alephiumClient nodeClient,
+	walletName string, transfer alephium.Transfer, log *logrus.Entry) (*alephium.TransferResult, error) {
+
+	transferRes, err := alephiumClient.Transfer(ctx, walletName, transfer)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while transferring from wallet %s", walletName)
+		return nil, err
+	}
+	return transferRes, nil
+}
+
+func getMinersAddresses(ctx context.Context, alephiumClient nodeClient,
 	log *logrus.Entry) (*alephium.MinerAddresses, error) {
 
-	minerAddressesReq := alephiumClient.MinersApi.GetMinersAddresses(ctx)
-	minerAddresses, _, err := minerAddressesReq.Execute()
+	minerAddresses, err := alephiumClient.GetMinersAddresses(ctx)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while calling miner addresses")
 		return nil, err
@@ -277,11 +431,10 @@ func getMinersAddresses(ctx context.Context, alephiumClient *alephium.APIClient,
 	return minerAddresses, nil
 }
 
-func updateMinerAddresses(ctx context.Context, alephiumClient *alephium.APIClient,
+func updateMinerAddresses(ctx context.Context, alephiumClient nodeClient,
 	addresses []string, log *logrus.Entry) error {
 	minerAddresses := alephium.NewMinerAddresses(addresses)
-	minerAddressesReq := alephiumClient.MinersApi.PutMinersAddresses(ctx).MinerAddresses(*minerAddresses)
-	_, err := minerAddressesReq.Execute()
+	err := alephiumClient.UpdateMinersAddresses(ctx, *minerAddresses)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while updatring miner addresses")
 		return err
@@ -289,6 +442,17 @@ func updateMinerAddresses(ctx context.Context, alephiumClient *alephium.APIClien
 	return nil
 }
 
+func getTransactionStatus(ctx context.Context, alephiumClient nodeClient, txId string, fromGroup int32,
+	toGroup int32, log *logrus.Entry) (*alephium.TxStatus, error) {
+
+	txStatus, err := alephiumClient.GetTransactionStatus(ctx, txId, fromGroup, toGroup)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while getting tx status for tx %s", txId)
+		return nil, err
+	}
+	return txStatus, nil
+}
+
 func GetAddressesAsString(walletAddresses []alephium.AddressInfo) []string {
 	addresses := make([]string, 0, len(walletAddresses))
 	for _, wa := range walletAddresses {
@@ -297,8 +461,19 @@ func GetAddressesAsString(walletAddresses []alephium.AddressInfo) []string {
 	return addresses
 }
 
-func getInterCliquePeerInfo(ctx context.Context, alephiumClient *alephium.APIClient, log *logrus.Entry) ([]alephium.InterCliquePeerInfo, error) {
-	info, _, err := alephiumClient.InfosApi.GetInfosInterCliquePeerInfo(ctx).Execute()
+func getBlock(ctx context.Context, alephiumClient nodeClient, blockHash string,
+	log *logrus.Entry) (*alephium.BlockEntry, error) {
+
+	block, err := alephiumClient.GetBlock(ctx, blockHash)
+	if err != nil {
+		log.WithError(err).Debugf("Got an error while calling block %s", blockHash)
+		return nil, err
+	}
+	return block, nil
+}
+
+func getInterCliquePeerInfo(ctx context.Context, alephiumClient nodeClient, log *logrus.Entry) ([]alephium.InterCliquePeerInfo, error) {
+	info, err := alephiumClient.GetInterCliquePeerInfo(ctx)
 	if err != nil {
 		log.WithError(err).Debugf("Got an error while calling intercliquepeerinfo")
 		return []alephium.InterCliquePeerInfo{}, err
@@ -320,7 +495,7 @@ func IsSyncedWithAtLeastOnePeer(peers []alephium.InterCliquePeerInfo) bool {
 
 // WaitUntilSyncedWithAtLeastOnePeer waits until the clique is connected to at least one clique
 // or the context is done.
-func WaitUntilSyncedWithAtLeastOnePeer(ctx context.Context, alephiumClient *alephium.APIClient, sleeptime time.Duration, log *logrus.Entry) (bool, error) {
+func WaitUntilSyncedWithAtLeastOnePeer(ctx context.Context, alephiumClient nodeClient, sleeptime time.Duration, log *logrus.Entry) (bool, error) {
 	for isSynced := false; ; {
 		select {
 		case <-ctx.Done():
@@ -336,13 +511,17 @@ func WaitUntilSyncedWithAtLeastOnePeer(ctx context.Context, alephiumClient *alep
 			return true, nil
 		} else {
 			log.Debugf("Not sync'ed yet, sleeping %s", sleeptime)
-			time.Sleep(sleeptime)
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(sleeptime):
+			}
 		}
 	}
 }
 
 // IsSynced checks if the clique is synced
-func IsSynced(ctx context.Context, alephiumClient *alephium.APIClient, log *logrus.Entry) (bool, error) {
+func IsSynced(ctx context.Context, alephiumClient nodeClient, log *logrus.Entry) (bool, error) {
 	peers, err := getInterCliquePeerInfo(ctx, alephiumClient, log)
 	if err != nil {
 		return false, err
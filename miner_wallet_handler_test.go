@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCreateAndUnlockWallet(t *testing.T) {
+	node := newFakeNode(t)
+	logger := newTestLogger()
+	handler, err := newMiningHandler(node.client(), "mining", testWalletSecrets("secret"), false, nil, logger)
+	assert.Nil(t, err)
+
+	wallet, err := handler.createAndUnlockWallet(context.Background(), logrus.NewEntry(logger))
+	assert.Nil(t, err)
+	assert.Equal(t, "mining", wallet.WalletName)
+	assert.Contains(t, node.wallets, "mining")
+
+	node.wallets["mining"].locked = true
+	_, err = handler.createAndUnlockWallet(context.Background(), logrus.NewEntry(logger))
+	assert.Nil(t, err)
+	assert.False(t, node.wallets["mining"].locked)
+
+	handler.secrets = testWalletSecrets("wrong")
+	node.wallets["mining"].locked = true
+	_, err = handler.createAndUnlockWallet(context.Background(), logrus.NewEntry(logger))
+	assert.NotNil(t, err)
+}
+
+func TestUpdateMinersAddresses(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret")
+	logger := newTestLogger()
+	handler, err := newMiningHandler(node.client(), "mining", testWalletSecrets("secret"), false, nil, logger)
+	assert.Nil(t, err)
+
+	err = handler.updateMinersAddresses(context.Background(), logrus.NewEntry(logger))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"mining-address-0", "mining-address-1", "mining-address-2", "mining-address-3"},
+		node.minerAddresses)
+}
+
+func TestWaitForNodeInSync(t *testing.T) {
+	node := newFakeNode(t)
+	node.synced = false
+	node.syncedAfter = 2
+	logger := newTestLogger()
+	handler, err := newMiningHandler(node.client(), "mining", testWalletSecrets("secret"), false, nil, logger)
+	assert.Nil(t, err)
+	handler.syncPollInterval = 10 * time.Millisecond
+
+	err = handler.waitForNodeInSync(context.Background(), logrus.NewEntry(logger))
+	assert.Nil(t, err)
+	assert.True(t, node.synced)
+	assert.Equal(t, 3, node.peerCalls)
+}
+
+// revealedSecrets keeps the secrets it reveals, to check that they get wiped.
+type revealedSecrets struct {
+	secretProvider
+	revealed [][]byte
+}
+
+func (s *revealedSecrets) secret(ctx context.Context, name string) ([]byte, error) {
+	value, err := s.secretProvider.secret(ctx, name)
+	s.revealed = append(s.revealed, value)
+	return value, err
+}
+
+func TestRestoreWalletWipesSecrets(t *testing.T) {
+	node := newFakeNode(t)
+	logger := newTestLogger()
+	secrets := &revealedSecrets{secretProvider: envConfig{WalletPassword: "secret", WalletMnemonic: "vault alarm sad",
+		WalletMnemonicPassphrase: "passphrase"}.walletSecrets()}
+	handler, err := newMiningHandler(node.client(), "mining", secrets, false, nil, logger)
+	assert.Nil(t, err)
+
+	_, err = handler.createAndUnlockWallet(context.Background(), logrus.NewEntry(logger))
+	assert.Nil(t, err)
+	assert.Contains(t, node.wallets, "mining")
+	assert.GreaterOrEqual(t, len(secrets.revealed), 3)
+	for _, value := range secrets.revealed {
+		assert.NotEmpty(t, value)
+		assert.Equal(t, make([]byte, len(value)), value)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	alephium "github.com/alephium/go-sdk"
+	"net/http"
+)
+
+// nodeClient is the subset of the Alephium node API used by the companion.
+type nodeClient interface {
+	Host() string
+
+	CheckWalletExist(ctx context.Context, walletName string) (bool, error)
+	CreateWallet(ctx context.Context, walletCreation alephium.WalletCreation) (*alephium.WalletCreationResult, error)
+	RestoreWallet(ctx context.Context, walletRestore alephium.WalletRestore) (*alephium.WalletRestoreResult, error)
+	UnlockWallet(ctx context.Context, walletName string, walletUnlock alephium.WalletUnlock) error
+	GetWalletStatus(ctx context.Context, walletName string) (*alephium.WalletStatus, error)
+	GetWalletAddresses(ctx context.Context, walletName string) (*alephium.Addresses, error)
+	GetWalletBalances(ctx context.Context, walletName string) (*alephium.Balances, error)
+	ChangeActiveAddress(ctx context.Context, walletName string, address string) error
+	SweepActiveAddress(ctx context.Context, walletName string, sweep alephium.Sweep) (*alephium.TransferResults, error)
+	Transfer(ctx context.Context, walletName string, transfer alephium.Transfer) (*alephium.TransferResult, error)
+
+	GetMinersAddresses(ctx context.Context) (*alephium.MinerAddresses, error)
+	UpdateMinersAddresses(ctx context.Context, minerAddresses alephium.MinerAddresses) error
+
+	GetAddressBalance(ctx context.Context, address string) (*alephium.Balance, error)
+	GetAddressGroup(ctx context.Context, address string) (*alephium.Group, error)
+
+	GetInterCliquePeerInfo(ctx context.Context) ([]alephium.InterCliquePeerInfo, error)
+
+	GetTransactionStatus(ctx context.Context, txId string, fromGroup int32, toGroup int32) (*alephium.TxStatus, error)
+	BuildTransaction(ctx context.Context, buildTx alephium.BuildTransaction) (*alephium.BuildTransactionResult, error)
+	BuildSweepAddressTransactions(ctx context.Context,
+		buildSweep alephium.BuildSweepAddressTransactions) (*alephium.BuildSweepAddressTransactionsResult, error)
+
+	GetBlock(ctx context.Context, blockHash string) (*alephium.BlockEntry, error)
+	// GetBlocks returns the blocks of each chain with a timestamp between fromTs and toTs, in milliseconds.
+	GetBlocks(ctx context.Context, fromTs int64, toTs int64) (*alephium.BlocksPerTimeStampRange, error)
+	// GetCurrentHashrate returns the hashrate of the network over the last timespan, in milliseconds.
+	GetCurrentHashrate(ctx context.Context, timespan int64) (*alephium.HashRateResponse, error)
+}
+
+// alephiumNodeClient implements nodeClient with the REST API of an Alephium full node.
+type alephiumNodeClient struct {
+	client *alephium.APIClient
+}
+
+// newNodeClient returns the client of the node at endpoint, apiKey being read on each request as it
+// can come from a file.
+func newNodeClient(endpoint string, apiKey *secret, debug bool) nodeClient {
+	alephiumConfig := alephium.NewConfiguration()
+	alephiumConfig.Host = endpoint
+	alephiumConfig.Debug = debug
+	if apiKey != nil {
+		alephiumConfig.HTTPClient = &http.Client{Transport: &apiKeyTransport{apiKey: apiKey, next: http.DefaultTransport}}
+	}
+	return &alephiumNodeClient{client: alephium.NewAPIClient(alephiumConfig)}
+}
+
+func (c *alephiumNodeClient) Host() string {
+	return c.client.GetConfig().Host
+}
+
+func (c *alephiumNodeClient) CheckWalletExist(ctx context.Context, walletName string) (bool, error) {
+	_, resp, err := c.client.WalletsApi.GetWalletsWalletName(ctx, walletName).Execute()
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapNodeError(resp, err)
+	}
+	return true, nil
+}
+
+func (c *alephiumNodeClient) CreateWallet(ctx context.Context,
+	walletCreation alephium.WalletCreation) (*alephium.WalletCreationResult, error) {
+
+	walletCreationRes, resp, err := c.client.WalletsApi.PostWallets(ctx).WalletCreation(walletCreation).Execute()
+	return walletCreationRes, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) RestoreWallet(ctx context.Context,
+	walletRestore alephium.WalletRestore) (*alephium.WalletRestoreResult, error) {
+
+	walletRestoreRes, resp, err := c.client.WalletsApi.PutWallets(ctx).WalletRestore(walletRestore).Execute()
+	return walletRestoreRes, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) UnlockWallet(ctx context.Context, walletName string, walletUnlock alephium.WalletUnlock) error {
+	resp, err := c.client.WalletsApi.PostWalletsWalletNameUnlock(ctx, walletName).WalletUnlock(walletUnlock).Execute()
+	return wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetWalletStatus(ctx context.Context, walletName string) (*alephium.WalletStatus, error) {
+	wallet, resp, err := c.client.WalletsApi.GetWalletsWalletName(ctx, walletName).Execute()
+	return wallet, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetWalletAddresses(ctx context.Context, walletName string) (*alephium.Addresses, error) {
+	walletAddresses, resp, err := c.client.WalletsApi.GetWalletsWalletNameAddresses(ctx, walletName).Execute()
+	return walletAddresses, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetWalletBalances(ctx context.Context, walletName string) (*alephium.Balances, error) {
+	walletBalances, resp, err := c.client.WalletsApi.GetWalletsWalletNameBalances(ctx, walletName).Execute()
+	return walletBalances, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) ChangeActiveAddress(ctx context.Context, walletName string, address string) error {
+	changeActiveAddress := alephium.NewChangeActiveAddress(address)
+	resp, err := c.client.WalletsApi.PostWalletsWalletNameChangeActiveAddress(ctx, walletName).
+		ChangeActiveAddress(*changeActiveAddress).Execute()
+	return wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) SweepActiveAddress(ctx context.Context, walletName string,
+	sweep alephium.Sweep) (*alephium.TransferResults, error) {
+
+	transferRes, resp, err := c.client.WalletsApi.PostWalletsWalletNameSweepActiveAddress(ctx, walletName).Sweep(sweep).Execute()
+	return transferRes, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) Transfer(ctx context.Context, walletName string,
+	transfer alephium.Transfer) (*alephium.TransferResult, error) {
+
+	transferRes, resp, err := c.client.WalletsApi.PostWalletsWalletNameTransfer(ctx, walletName).Transfer(transfer).Execute()
+	return transferRes, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetMinersAddresses(ctx context.Context) (*alephium.MinerAddresses, error) {
+	minerAddresses, resp, err := c.client.MinersApi.GetMinersAddresses(ctx).Execute()
+	return minerAddresses, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) UpdateMinersAddresses(ctx context.Context, minerAddresses alephium.MinerAddresses) error {
+	resp, err := c.client.MinersApi.PutMinersAddresses(ctx).MinerAddresses(minerAddresses).Execute()
+	return wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetAddressBalance(ctx context.Context, address string) (*alephium.Balance, error) {
+	balance, resp, err := c.client.AddressesApi.GetAddressesAddressBalance(ctx, address).Execute()
+	return balance, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetAddressGroup(ctx context.Context, address string) (*alephium.Group, error) {
+	group, resp, err := c.client.AddressesApi.GetAddressesAddressGroup(ctx, address).Execute()
+	return group, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetInterCliquePeerInfo(ctx context.Context) ([]alephium.InterCliquePeerInfo, error) {
+	info, resp, err := c.client.InfosApi.GetInfosInterCliquePeerInfo(ctx).Execute()
+	return info, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetTransactionStatus(ctx context.Context, txId string, fromGroup int32,
+	toGroup int32) (*alephium.TxStatus, error) {
+
+	txStatus, resp, err := c.client.TransactionsApi.GetTransactionsStatus(ctx).TxId(txId).FromGroup(fromGroup).
+		ToGroup(toGroup).Execute()
+	return txStatus, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) BuildTransaction(ctx context.Context,
+	buildTx alephium.BuildTransaction) (*alephium.BuildTransactionResult, error) {
+
+	builtTx, resp, err := c.client.TransactionsApi.PostTransactionsBuild(ctx).BuildTransaction(buildTx).Execute()
+	return builtTx, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) BuildSweepAddressTransactions(ctx context.Context,
+	buildSweep alephium.BuildSweepAddressTransactions) (*alephium.BuildSweepAddressTransactionsResult, error) {
+
+	builtSweep, resp, err := c.client.TransactionsApi.PostTransactionsSweepAddressBuild(ctx).
+		BuildSweepAddressTransactions(buildSweep).Execute()
+	return builtSweep, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetBlock(ctx context.Context, blockHash string) (*alephium.BlockEntry, error) {
+	block, resp, err := c.client.BlockflowApi.GetBlockflowBlocksBlockHash(ctx, blockHash).Execute()
+	return block, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetBlocks(ctx context.Context, fromTs int64, toTs int64) (*alephium.BlocksPerTimeStampRange, error) {
+	blocks, resp, err := c.client.BlockflowApi.GetBlockflowBlocks(ctx).FromTs(fromTs).ToTs(toTs).Execute()
+	return blocks, wrapNodeError(resp, err)
+}
+
+func (c *alephiumNodeClient) GetCurrentHashrate(ctx context.Context, timespan int64) (*alephium.HashRateResponse, error) {
+	hashrate, resp, err := c.client.InfosApi.GetInfosCurrentHashrate(ctx).Timespan(timespan).Execute()
+	return hashrate, wrapNodeError(resp, err)
+}
+
+// nodeAPIError is an error answered by the node, along with its HTTP status code.
+type nodeAPIError struct {
+	StatusCode int
+	err        error
+}
+
+func (e *nodeAPIError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nodeAPIError) Unwrap() error {
+	return e.err
+}
+
+func wrapNodeError(resp *http.Response, err error) error {
+	if err != nil && resp != nil && resp.StatusCode >= 300 {
+		return &nodeAPIError{StatusCode: resp.StatusCode, err: err}
+	}
+	return err
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nodeProbeTimeout = 5 * time.Second
+	// nodeLatencyWeight is the weight of the last probe in the moving average of the latency of a node.
+	nodeLatencyWeight = 0.3
+)
+
+// nodeEndpoint is one of the nodes of a failoverNodeClient, along with its last known health.
+type nodeEndpoint struct {
+	client  nodeClient
+	up      bool
+	synced  bool
+	latency time.Duration
+}
+
+// failoverNodeClient implements nodeClient on top of several nodes. Wallet-independent reads go to the
+// healthiest node, failing over to the next ones on error. Wallet operations are pinned to a node holding
+// the wallet, as wallets (and their active address and lock state) are local to a node.
+type failoverNodeClient struct {
+	nodes       []*nodeEndpoint
+	walletNodes map[string]*nodeEndpoint
+	lock        *sync.RWMutex
+	metrics     *metrics
+	log         *logrus.Logger
+}
+
+func newFailoverNodeClient(clients []nodeClient, metrics *metrics, log *logrus.Logger) *failoverNodeClient {
+	nodes := make([]*nodeEndpoint, 0, len(clients))
+	for _, client := range clients {
+		// Nodes are deemed healthy, in the given order, until the first probe
+		nodes = append(nodes, &nodeEndpoint{client: client, up: true, synced: true})
+	}
+	return &failoverNodeClient{
+		nodes:       nodes,
+		walletNodes: make(map[string]*nodeEndpoint),
+		lock:        &sync.RWMutex{},
+		metrics:     metrics,
+		log:         log,
+	}
+}
+
+// newNodeClients returns the client of the comma separated list of endpoints, a failover one if there
+// is more than one endpoint.
+func newNodeClients(endpoints string, apiKey *secret, debug bool, metrics *metrics,
+	log *logrus.Logger) (nodeClient, *failoverNodeClient, error) {
+
+	clients := make([]nodeClient, 0)
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint != "" {
+			clients = append(clients, newNodeClient(endpoint, apiKey, debug))
+		}
+	}
+	if len(clients) == 0 {
+		return nil, nil, fmt.Errorf("no Alephium endpoint configured")
+	}
+	if len(clients) == 1 {
+		return clients[0], nil, nil
+	}
+	failover := newFailoverNodeClient(clients, metrics, log)
+	return failover, failover, nil
+}
+
+// watch probes the nodes every interval until the context is done.
+func (c *failoverNodeClient) watch(ctx context.Context, interval time.Duration) {
+	c.probe(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.probe(ctx)
+		}
+	}
+}
+
+// probe scores the health of each node, checking it's in sync and measuring its latency.
+func (c *failoverNodeClient) probe(ctx context.Context) {
+	for _, node := range c.nodes {
+		probeCtx, cancel := context.WithTimeout(ctx, nodeProbeTimeout)
+		start := time.Now()
+		synced, err := IsSynced(probeCtx, node.client, logrus.NewEntry(c.log))
+		latency := time.Since(start)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.lock.Lock()
+		if err != nil {
+			if node.up {
+				c.log.WithError(err).Warnf("Node %s is unreachable", node.client.Host())
+			}
+			node.up = false
+		} else {
+			if !node.up {
+				c.log.Infof("Node %s is reachable again", node.client.Host())
+			}
+			node.up = true
+			node.synced = synced
+			if node.latency == 0 {
+				node.latency = latency
+			} else {
+				node.latency = time.Duration(nodeLatencyWeight*float64(latency) + (1-nodeLatencyWeight)*float64(node.latency))
+			}
+		}
+		c.observe(node)
+		c.lock.Unlock()
+	}
+}
+
+// observe exposes the health of node as metrics, lock being held.
+func (c *failoverNodeClient) observe(node *nodeEndpoint) {
+	labels := prometheus.Labels{"endpoint": node.client.Host()}
+	c.metrics.nodeUp.With(labels).Set(boolToFloat(node.up))
+	c.metrics.nodeSynced.With(labels).Set(boolToFloat(node.up && node.synced))
+	c.metrics.nodeLatency.With(labels).Set(node.latency.Seconds())
+}
+
+// ordered returns the nodes from the healthiest to the least healthy one: reachable and synced nodes
+// first, by latency, then reachable nodes not in sync, then unreachable ones, tried as a last resort.
+func (c *failoverNodeClient) ordered() []*nodeEndpoint {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	nodes := make([]*nodeEndpoint, len(c.nodes))
+	copy(nodes, c.nodes)
+	score := func(node *nodeEndpoint) int {
+		switch {
+		case node.up && node.synced:
+			return 0
+		case node.up:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if score(nodes[i]) != score(nodes[j]) {
+			return score(nodes[i]) < score(nodes[j])
+		}
+		return nodes[i].latency < nodes[j].latency
+	})
+	return nodes
+}
+
+// markDown flags node as unreachable until the next successful probe.
+func (c *failoverNodeClient) markDown(node *nodeEndpoint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	node.up = false
+	c.observe(node)
+}
+
+func (c *failoverNodeClient) failedOver(operation string, from *nodeEndpoint, to *nodeEndpoint, err error) {
+	c.log.WithError(err).Warnf("Failing over %s from node %s to node %s", operation, from.client.Host(), to.client.Host())
+	c.metrics.nodeFailovers.With(prometheus.Labels{"from": from.client.Host(), "to": to.client.Host()}).Inc()
+}
+
+// isNodeFailure tells whether err is due to the node itself, being unreachable or failing, rather than
+// to the request.
+func isNodeFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *nodeAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// read runs call on the healthiest node, failing over to the next ones if the node fails.
+func (c *failoverNodeClient) read(operation string, call func(client nodeClient) error) error {
+	var previous *nodeEndpoint
+	var err error
+	for _, node := range c.ordered() {
+		if previous != nil {
+			c.failedOver(operation, previous, node, err)
+		}
+		err = call(node.client)
+		if !isNodeFailure(err) {
+			return err
+		}
+		c.markDown(node)
+		previous = node
+	}
+	return err
+}
+
+// walletNode returns the node the wallet is pinned to, pinning it to the healthiest node holding
+// the wallet if needed.
+func (c *failoverNodeClient) walletNode(ctx context.Context, walletName string) (*nodeEndpoint, error) {
+	c.lock.RLock()
+	node, ok := c.walletNodes[walletName]
+	c.lock.RUnlock()
+	if ok {
+		return node, nil
+	}
+	found, err := c.CheckWalletExist(ctx, walletName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("wallet %s not found on any node", walletName)
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.walletNodes[walletName], nil
+}
+
+func (c *failoverNodeClient) pin(walletName string, node *nodeEndpoint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if previous, ok := c.walletNodes[walletName]; !ok || previous != node {
+		c.log.Infof("Wallet %s is now pinned to node %s", walletName, node.client.Host())
+	}
+	c.walletNodes[walletName] = node
+}
+
+func (c *failoverNodeClient) unpin(walletName string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.walletNodes, walletName)
+}
+
+// walletCall runs call on the node the wallet is pinned to. If the node fails, the wallet is pinned to
+// another node holding it, and call is retried there if it's idempotent. Txs are never retried, as they
+// may have been submitted anyway.
+func (c *failoverNodeClient) walletCall(ctx context.Context, walletName string, operation string, idempotent bool,
+	call func(client nodeClient) error) error {
+
+	node, err := c.walletNode(ctx, walletName)
+	if err != nil {
+		return err
+	}
+	err = call(node.client)
+	if !isNodeFailure(err) {
+		return err
+	}
+	c.markDown(node)
+	c.unpin(walletName)
+	if !idempotent {
+		return err
+	}
+	next, nextErr := c.walletNode(ctx, walletName)
+	if nextErr != nil || next == node {
+		return err
+	}
+	c.failedOver(operation, node, next, err)
+	return call(next.client)
+}
+
+func (c *failoverNodeClient) Host() string {
+	return c.ordered()[0].client.Host()
+}
+
+// CheckWalletExist pins the wallet to the healthiest node holding it. The wallet doesn't exist only if
+// all the nodes tell so, an error being returned if it's not found and some nodes can't be reached.
+func (c *failoverNodeClient) CheckWalletExist(ctx context.Context, walletName string) (bool, error) {
+	var lastErr error
+	for _, node := range c.ordered() {
+		found, err := node.client.CheckWalletExist(ctx, walletName)
+		if err != nil {
+			if isNodeFailure(err) {
+				c.markDown(node)
+			}
+			lastErr = err
+			continue
+		}
+		if found {
+			c.pin(walletName, node)
+			return true, nil
+		}
+	}
+	if lastErr != nil {
+		return false, fmt.Errorf("wallet %s not found on the reachable nodes, some nodes failed: %w", walletName, lastErr)
+	}
+	return false, nil
+}
+
+func (c *failoverNodeClient) CreateWallet(ctx context.Context,
+	walletCreation alephium.WalletCreation) (*alephium.WalletCreationResult, error) {
+
+	node := c.ordered()[0]
+	result, err := node.client.CreateWallet(ctx, walletCreation)
+	if err == nil {
+		c.pin(walletCreation.WalletName, node)
+	}
+	return result, err
+}
+
+func (c *failoverNodeClient) RestoreWallet(ctx context.Context,
+	walletRestore alephium.WalletRestore) (*alephium.WalletRestoreResult, error) {
+
+	node := c.ordered()[0]
+	result, err := node.client.RestoreWallet(ctx, walletRestore)
+	if err == nil {
+		c.pin(walletRestore.WalletName, node)
+	}
+	return result, err
+}
+
+func (c *failoverNodeClient) UnlockWallet(ctx context.Context, walletName string, walletUnlock alephium.WalletUnlock) error {
+	return c.walletCall(ctx, walletName, "wallet unlock", true, func(client nodeClient) error {
+		return client.UnlockWallet(ctx, walletName, walletUnlock)
+	})
+}
+
+func (c *failoverNodeClient) GetWalletStatus(ctx context.Context, walletName string) (*alephium.WalletStatus, error) {
+	var wallet *alephium.WalletStatus
+	err := c.walletCall(ctx, walletName, "wallet status", true, func(client nodeClient) error {
+		var err error
+		wallet, err = client.GetWalletStatus(ctx, walletName)
+		return err
+	})
+	return wallet, err
+}
+
+func (c *failoverNodeClient) GetWalletAddresses(ctx context.Context, walletName string) (*alephium.Addresses, error) {
+	var walletAddresses *alephium.Addresses
+	err := c.walletCall(ctx, walletName, "wallet addresses", true, func(client nodeClient) error {
+		var err error
+		walletAddresses, err = client.GetWalletAddresses(ctx, walletName)
+		return err
+	})
+	return walletAddresses, err
+}
+
+func (c *failoverNodeClient) GetWalletBalances(ctx context.Context, walletName string) (*alephium.Balances, error) {
+	var walletBalances *alephium.Balances
+	err := c.walletCall(ctx, walletName, "wallet balances", true, func(client nodeClient) error {
+		var err error
+		walletBalances, err = client.GetWalletBalances(ctx, walletName)
+		return err
+	})
+	return walletBalances, err
+}
+
+func (c *failoverNodeClient) ChangeActiveAddress(ctx context.Context, walletName string, address string) error {
+	return c.walletCall(ctx, walletName, "change active address", true, func(client nodeClient) error {
+		return client.ChangeActiveAddress(ctx, walletName, address)
+	})
+}
+
+func (c *failoverNodeClient) SweepActiveAddress(ctx context.Context, walletName string,
+	sweep alephium.Sweep) (*alephium.TransferResults, error) {
+
+	var transferRes *alephium.TransferResults
+	err := c.walletCall(ctx, walletName, "sweep", false, func(client nodeClient) error {
+		var err error
+		transferRes, err = client.SweepActiveAddress(ctx, walletName, sweep)
+		return err
+	})
+	return transferRes, err
+}
+
+func (c *failoverNodeClient) Transfer(ctx context.Context, walletName string,
+	transfer alephium.Transfer) (*alephium.TransferResult, error) {
+
+	var transferRes *alephium.TransferResult
+	err := c.walletCall(ctx, walletName, "transfer", false, func(client nodeClient) error {
+		var err error
+		transferRes, err = client.Transfer(ctx, walletName, transfer)
+		return err
+	})
+	return transferRes, err
+}
+
+// GetMinersAddresses returns the miner addresses if all the reachable nodes agree on them, and no
+// addresses otherwise, so that they get updated on all the nodes.
+func (c *failoverNodeClient) GetMinersAddresses(ctx context.Context) (*alephium.MinerAddresses, error) {
+	var minerAddresses *alephium.MinerAddresses
+	var lastErr error
+	for _, node := range c.ordered() {
+		nodeAddresses, err := node.client.GetMinersAddresses(ctx)
+		if err != nil {
+			if isNodeFailure(err) {
+				c.markDown(node)
+			}
+			lastErr = err
+			continue
+		}
+		if minerAddresses == nil {
+			minerAddresses = nodeAddresses
+		} else if !sameStrings(minerAddresses.Addresses, nodeAddresses.Addresses) {
+			c.log.Warnf("Node %s mines to %v, not to %v as the other nodes", node.client.Host(),
+				nodeAddresses.Addresses, minerAddresses.Addresses)
+			return &alephium.MinerAddresses{Addresses: []string{}}, nil
+		}
+	}
+	if minerAddresses == nil {
+		return nil, lastErr
+	}
+	return minerAddresses, nil
+}
+
+// UpdateMinersAddresses updates the miner addresses of all the reachable nodes.
+func (c *failoverNodeClient) UpdateMinersAddresses(ctx context.Context, minerAddresses alephium.MinerAddresses) error {
+	updated := 0
+	var lastErr error
+	for _, node := range c.ordered() {
+		err := node.client.UpdateMinersAddresses(ctx, minerAddresses)
+		if err != nil {
+			c.log.WithError(err).Warnf("Got an error while updating the miner addresses of node %s", node.client.Host())
+			if isNodeFailure(err) {
+				c.markDown(node)
+			}
+			lastErr = err
+			continue
+		}
+		updated++
+	}
+	if updated == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (c *failoverNodeClient) GetAddressBalance(ctx context.Context, address string) (*alephium.Balance, error) {
+	var balance *alephium.Balance
+	err := c.read("address balance", func(client nodeClient) error {
+		var err error
+		balance, err = client.GetAddressBalance(ctx, address)
+		return err
+	})
+	return balance, err
+}
+
+func (c *failoverNodeClient) GetAddressGroup(ctx context.Context, address string) (*alephium.Group, error) {
+	var group *alephium.Group
+	err := c.read("address group", func(client nodeClient) error {
+		var err error
+		group, err = client.GetAddressGroup(ctx, address)
+		return err
+	})
+	return group, err
+}
+
+func (c *failoverNodeClient) GetInterCliquePeerInfo(ctx context.Context) ([]alephium.InterCliquePeerInfo, error) {
+	var info []alephium.InterCliquePeerInfo
+	err := c.read("peer info", func(client nodeClient) error {
+		var err error
+		info, err = client.GetInterCliquePeerInfo(ctx)
+		return err
+	})
+	return info, err
+}
+
+// GetTransactionStatus returns the status of the tx on the healthiest node knowing it. A tx is only known
+// by the node it was submitted to until it propagates, so it's not found only if all the nodes tell so, an
+// error being returned if it's not found and some nodes can't be reached.
+func (c *failoverNodeClient) GetTransactionStatus(ctx context.Context, txId string, fromGroup int32,
+	toGroup int32) (*alephium.TxStatus, error) {
+
+	var notFound *alephium.TxStatus
+	var lastErr error
+	for _, node := range c.ordered() {
+		txStatus, err := node.client.GetTransactionStatus(ctx, txId, fromGroup, toGroup)
+		if err != nil {
+			if isNodeFailure(err) {
+				c.markDown(node)
+			}
+			lastErr = err
+			continue
+		}
+		if txStatus.TxNotFound == nil {
+			return txStatus, nil
+		}
+		notFound = txStatus
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("tx %s not found on the reachable nodes, some nodes failed: %w", txId, lastErr)
+	}
+	return notFound, nil
+}
+
+func (c *failoverNodeClient) BuildTransaction(ctx context.Context,
+	buildTx alephium.BuildTransaction) (*alephium.BuildTransactionResult, error) {
+
+	var builtTx *alephium.BuildTransactionResult
+	err := c.read("build tx", func(client nodeClient) error {
+		var err error
+		builtTx, err = client.BuildTransaction(ctx, buildTx)
+		return err
+	})
+	return builtTx, err
+}
+
+func (c *failoverNodeClient) BuildSweepAddressTransactions(ctx context.Context,
+	buildSweep alephium.BuildSweepAddressTransactions) (*alephium.BuildSweepAddressTransactionsResult, error) {
+
+	var builtSweep *alephium.BuildSweepAddressTransactionsResult
+	err := c.read("build sweep", func(client nodeClient) error {
+		var err error
+		builtSweep, err = client.BuildSweepAddressTransactions(ctx, buildSweep)
+		return err
+	})
+	return builtSweep, err
+}
+
+func (c *failoverNodeClient) GetBlock(ctx context.Context, blockHash string) (*alephium.BlockEntry, error) {
+	var block *alephium.BlockEntry
+	err := c.read("block", func(client nodeClient) error {
+		var err error
+		block, err = client.GetBlock(ctx, blockHash)
+		return err
+	})
+	return block, err
+}
+
+func (c *failoverNodeClient) GetBlocks(ctx context.Context, fromTs int64, toTs int64) (*alephium.BlocksPerTimeStampRange, error) {
+	var blocks *alephium.BlocksPerTimeStampRange
+	err := c.read("blocks", func(client nodeClient) error {
+		var err error
+		blocks, err = client.GetBlocks(ctx, fromTs, toTs)
+		return err
+	})
+	return blocks, err
+}
+
+func (c *failoverNodeClient) GetCurrentHashrate(ctx context.Context, timespan int64) (*alephium.HashRateResponse, error) {
+	var hashrate *alephium.HashRateResponse
+	err := c.read("current-hashrate", func(client nodeClient) error {
+		var err error
+		hashrate, err = client.GetCurrentHashrate(ctx, timespan)
+		return err
+	})
+	return hashrate, err
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, value := range a {
+		if !contains(b, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFailoverReads(t *testing.T) {
+	nodeA := newFakeNode(t)
+	nodeB := newFakeNode(t)
+	nodeA.addWallet("mining", "secret", "10")
+	nodeB.addWallet("mining", "secret", "10")
+	client := newFailoverNodeClient([]nodeClient{nodeA.client(), nodeB.client()}, newTestMetrics(), newTestLogger())
+	ctx := context.Background()
+
+	client.probe(ctx)
+	assert.Len(t, client.ordered(), 2)
+
+	// Unsynced nodes come after the synced ones
+	nodeA.mu.Lock()
+	nodeA.synced = false
+	nodeA.mu.Unlock()
+	client.probe(ctx)
+	assert.Equal(t, nodeB.server.URL, client.Host())
+
+	nodeA.mu.Lock()
+	nodeA.synced = true
+	nodeA.mu.Unlock()
+	nodeB.server.Close()
+	balance, err := client.GetAddressBalance(ctx, "mining-address-0")
+	assert.Nil(t, err)
+	assert.Equal(t, "10000000000000000000", balance.Balance)
+	assert.Equal(t, nodeA.server.URL, client.Host())
+}
+
+func TestFailoverWallet(t *testing.T) {
+	nodeA := newFakeNode(t)
+	nodeB := newFakeNode(t)
+	nodeC := newFakeNode(t)
+	nodeB.addWallet("mining", "secret", "10")
+	nodeC.addWallet("mining", "secret", "20")
+	client := newFailoverNodeClient([]nodeClient{nodeA.client(), nodeB.client(), nodeC.client()}, newTestMetrics(),
+		newTestLogger())
+	ctx := context.Background()
+
+	found, err := client.CheckWalletExist(ctx, "mining")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	balances, err := client.GetWalletBalances(ctx, "mining")
+	assert.Nil(t, err)
+	assert.Equal(t, "10000000000000000000", balances.TotalBalance)
+
+	// The wallet fails over to the other node holding it, but txs are not retried
+	nodeB.server.Close()
+	_, err = client.SweepActiveAddress(ctx, "mining", alephium.Sweep{ToAddress: "1dest"})
+	assert.NotNil(t, err)
+	balances, err = client.GetWalletBalances(ctx, "mining")
+	assert.Nil(t, err)
+	assert.Equal(t, "20000000000000000000", balances.TotalBalance)
+	assert.Empty(t, nodeC.submitted)
+
+	// A wallet missing on all the reachable nodes isn't reported as missing while a node is down
+	_, err = client.CheckWalletExist(ctx, "other")
+	assert.NotNil(t, err)
+}
+
+func TestFailoverMinerAddresses(t *testing.T) {
+	nodeA := newFakeNode(t)
+	nodeB := newFakeNode(t)
+	nodeA.minerAddresses = []string{"a", "b"}
+	nodeB.minerAddresses = []string{"a", "c"}
+	client := newFailoverNodeClient([]nodeClient{nodeA.client(), nodeB.client()}, newTestMetrics(), newTestLogger())
+	ctx := context.Background()
+
+	minerAddresses, err := client.GetMinersAddresses(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, minerAddresses.Addresses)
+
+	err = client.UpdateMinersAddresses(ctx, alephium.MinerAddresses{Addresses: []string{"a", "b"}})
+	assert.Nil(t, err)
+	minerAddresses, err = client.GetMinersAddresses(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, minerAddresses.Addresses)
+	assert.Equal(t, []string{"a", "b"}, nodeB.minerAddresses)
+}
+
+func TestFailoverTransactionStatus(t *testing.T) {
+	nodeA := newFakeNode(t)
+	nodeB := newFakeNode(t)
+	nodeB.confirmAfter = 1
+	nodeB.addWallet("mining", "secret", "10")
+	client := newFailoverNodeClient([]nodeClient{nodeA.client(), nodeB.client()}, newTestMetrics(), newTestLogger())
+	ctx := context.Background()
+
+	transferRes, err := client.SweepActiveAddress(ctx, "mining", alephium.Sweep{ToAddress: "1dest"})
+	assert.Nil(t, err)
+	txId := transferRes.Results[0].TxId
+	assert.Equal(t, []string{txId}, nodeB.submitted)
+
+	// The healthiest node doesn't know the tx yet, the node it was submitted to does
+	txStatus, err := client.GetTransactionStatus(ctx, txId, 0, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, txStatus.MemPooled)
+	txStatus, err = client.GetTransactionStatus(ctx, txId, 0, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, txStatus.Confirmed)
+	assert.Equal(t, nodeA.server.URL, client.Host())
+
+	txStatus, err = client.GetTransactionStatus(ctx, "unknown", 0, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, txStatus.TxNotFound)
+
+	// A tx isn't reported as not found while the node it was submitted to is down
+	nodeB.server.Close()
+	_, err = client.GetTransactionStatus(ctx, txId, 0, 0)
+	assert.NotNil(t, err)
+}
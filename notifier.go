@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// notificationQueueSize is the number of events waiting for delivery per sink, the events notified
+	// while the queue is full going straight to the dead letter file.
+	notificationQueueSize = 100
+	notificationTimeout   = 10 * time.Second
+)
+
+// eventType is the type of the events notified by the companion.
+type eventType string
+
+const (
+	eventTransferSubmitted     eventType = "transfer.submitted"
+	eventTransferConfirmed     eventType = "transfer.confirmed"
+	eventTransferFailed        eventType = "transfer.failed"
+	eventNodeOutOfSync         eventType = "node.out_of_sync"
+	eventNodeSynced            eventType = "node.synced"
+	eventMinerAddressesChanged eventType = "miner_addresses.changed"
+	eventWalletCreated         eventType = "wallet.created"
+	eventEarningsSummary       eventType = "earnings.summary"
+	eventBlockMined            eventType = "block.mined"
+	eventBlocksOverdue         eventType = "blocks.overdue"
+)
+
+var eventTypes = []eventType{eventTransferSubmitted, eventTransferConfirmed, eventTransferFailed, eventNodeOutOfSync,
+	eventNodeSynced, eventMinerAddressesChanged, eventWalletCreated, eventEarningsSummary, eventBlockMined,
+	eventBlocksOverdue}
+
+// event is something worth telling about, i.e. a transfer confirmed or the node falling out of sync.
+// Only the fields relevant to its type are set.
+type event struct {
+	Type      eventType        `json:"type"`
+	Time      time.Time        `json:"time"`
+	Node      string           `json:"node,omitempty"`
+	Endpoint  string           `json:"endpoint,omitempty"`
+	Wallet    string           `json:"wallet,omitempty"`
+	Transfer  *transferRecord  `json:"transfer,omitempty"`
+	Addresses []string         `json:"addresses,omitempty"`
+	Summary   *earningsSummary `json:"summary,omitempty"`
+	Block     *minedBlock      `json:"block,omitempty"`
+	Overdue   *overdueBlocks   `json:"overdue,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// eventSink delivers the events somewhere, i.e. to a webhook.
+type eventSink interface {
+	deliver(ctx context.Context, e event) error
+}
+
+// permanentError is a delivery error which retrying won't fix, i.e. the receiver rejecting the event.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// notificationsConfig lists where to deliver the events, i.e.
+//
+//	{"webhooks": [{"url": "https://example.org/hooks/mining", "secretFile": "/run/secrets/hook", "events": ["transfer.failed"]}],
+//	 "telegram": [{"botTokenFile": "/run/secrets/telegram", "chatId": "-1001234567890"}]}
+type notificationsConfig struct {
+	Webhooks []webhookConfig `json:"webhooks"`
+	Discord  []chatConfig    `json:"discord"`
+	Slack    []chatConfig    `json:"slack"`
+	Telegram []chatConfig    `json:"telegram"`
+}
+
+// chats returns the chat channels of the config by platform.
+func (c *notificationsConfig) chats() map[string][]chatConfig {
+	return map[string][]chatConfig{chatDiscord: c.Discord, chatSlack: c.Slack, chatTelegram: c.Telegram}
+}
+
+func loadNotificationsConfig(path string) (*notificationsConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &notificationsConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("notifications config %s is not valid: %w", path, err)
+	}
+	for i, webhook := range config.Webhooks {
+		err = parseHTTPURL("webhook url", webhook.URL)
+		if err != nil {
+			return nil, fmt.Errorf("notifications config %s: %w", path, err)
+		}
+		if webhook.Name == "" {
+			u, _ := url.Parse(webhook.URL)
+			config.Webhooks[i].Name = "webhook " + u.Host
+		}
+		err = checkEventTypes(webhook.Events)
+		if err != nil {
+			return nil, fmt.Errorf("notifications config %s: %w", path, err)
+		}
+	}
+	for platform, chats := range config.chats() {
+		for i := range chats {
+			err = chats[i].validate(platform)
+			if err != nil {
+				return nil, fmt.Errorf("notifications config %s: %w", path, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+func checkEventTypes(events []eventType) error {
+	for _, e := range events {
+		if !containsEventType(eventTypes, e) {
+			return fmt.Errorf("unknown event %s, expecting one of %v", e, eventTypes)
+		}
+	}
+	return nil
+}
+
+func containsEventType(events []eventType, e eventType) bool {
+	for _, other := range events {
+		if other == e {
+			return true
+		}
+	}
+	return false
+}
+
+// initNotifier returns the notifier delivering the events to the sinks of NOTIFICATIONS_CONFIG and to
+// reporter, nil if none.
+func initNotifier(env envConfig, reporter *emailReporter, log *logrus.Logger) (*notifier, error) {
+	if env.NotificationsConfig == "" && reporter == nil {
+		return nil, nil
+	}
+	config := &notificationsConfig{}
+	if env.NotificationsConfig != "" {
+		var err error
+		config, err = loadNotificationsConfig(env.NotificationsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n := newNotifier(env.NotificationRetries, env.NotificationRetryBackoff, env.NotificationDeadLetterPath, log)
+	sinks := make([]eventSink, 0, len(config.Webhooks))
+	for _, webhook := range config.Webhooks {
+		sink, err := newWebhookSink(webhook)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	for i, webhook := range config.Webhooks {
+		n.addSink(webhook.Name, sinks[i], webhook.Events)
+	}
+	for platform, chats := range config.chats() {
+		for _, chat := range chats {
+			n.addSink(chat.Name, newChatSink(platform, chat), chat.Events)
+		}
+	}
+	if reporter != nil {
+		n.addSink("email", reporter, emailReportEvents)
+	}
+	return n, nil
+}
+
+// sinkQueue holds the events waiting for delivery to a sink, filtered by type.
+type sinkQueue struct {
+	name   string
+	sink   eventSink
+	events map[eventType]bool
+	queue  chan event
+}
+
+// notifier delivers the events to the sinks in the background, retrying with exponential backoff. The
+// events which can't be delivered are appended to the dead letter file. A nil notifier drops the events.
+type notifier struct {
+	queues         []*sinkQueue
+	retries        int
+	initialBackoff time.Duration
+	deadLetterPath string
+	deadLetterLock *sync.Mutex
+	// node is set on the events, the name of the node in a fleet.
+	node      string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	workers   *sync.WaitGroup
+	closed    *bool
+	closeLock *sync.RWMutex
+	log       *logrus.Logger
+}
+
+func newNotifier(retries int, initialBackoff time.Duration, deadLetterPath string, log *logrus.Logger) *notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &notifier{
+		retries:        retries,
+		initialBackoff: initialBackoff,
+		deadLetterPath: deadLetterPath,
+		deadLetterLock: &sync.Mutex{},
+		ctx:            ctx,
+		cancel:         cancel,
+		workers:        &sync.WaitGroup{},
+		closed:         new(bool),
+		closeLock:      &sync.RWMutex{},
+		log:            log,
+	}
+}
+
+// addSink starts delivering the events of the given types, all of them if empty, to sink.
+func (n *notifier) addSink(name string, sink eventSink, events []eventType) {
+	q := &sinkQueue{
+		name:  name,
+		sink:  sink,
+		queue: make(chan event, notificationQueueSize),
+	}
+	if len(events) > 0 {
+		q.events = make(map[eventType]bool)
+		for _, eventType := range events {
+			q.events[eventType] = true
+		}
+	}
+	n.queues = append(n.queues, q)
+	n.workers.Add(1)
+	go func() {
+		defer n.workers.Done()
+		for e := range q.queue {
+			n.deliver(q, e)
+		}
+	}()
+}
+
+// withNode returns a notifier sharing the sinks of n, setting node on the events. The sinks are expected
+// to be all added to n before.
+func (n *notifier) withNode(node string) *notifier {
+	if n == nil {
+		return nil
+	}
+	withNode := *n
+	withNode.node = node
+	return &withNode
+}
+
+// notify queues e for delivery to the sinks interested in its type, without blocking.
+func (n *notifier) notify(e event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Node == "" {
+		e.Node = n.node
+	}
+	if e.Transfer != nil {
+		// The record keeps changing while the event waits for delivery
+		record := *e.Transfer
+		e.Transfer = &record
+	}
+
+	n.closeLock.RLock()
+	defer n.closeLock.RUnlock()
+	if *n.closed {
+		n.log.Warnf("Notifier closed, event %s not delivered", e.Type)
+		return
+	}
+	for _, q := range n.queues {
+		if q.events != nil && !q.events[e.Type] {
+			continue
+		}
+		select {
+		case q.queue <- e:
+		default:
+			n.writeDeadLetter(q.name, e, fmt.Errorf("delivery queue is full"))
+		}
+	}
+}
+
+// deliver sends e to the sink of q, retrying with exponential backoff until the number of retries
+// is exhausted or the notifier is closed.
+func (n *notifier) deliver(q *sinkQueue, e event) {
+	backoff := n.initialBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(n.ctx, notificationTimeout)
+		err := q.sink.deliver(ctx, e)
+		cancel()
+		if err == nil {
+			n.log.Debugf("Event %s delivered to %s", e.Type, q.name)
+			return
+		}
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) || attempt >= n.retries || n.ctx.Err() != nil {
+			n.writeDeadLetter(q.name, e, err)
+			return
+		}
+		n.log.WithError(err).Debugf("Got an error while delivering event %s to %s, retrying in %s", e.Type, q.name, backoff)
+		select {
+		case <-n.ctx.Done():
+			n.writeDeadLetter(q.name, e, n.ctx.Err())
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// deadLetter is a line of the dead letter file.
+type deadLetter struct {
+	Time  time.Time `json:"time"`
+	Sink  string    `json:"sink"`
+	Error string    `json:"error"`
+	Event event     `json:"event"`
+}
+
+// writeDeadLetter appends the event which couldn't be delivered to the dead letter file, as a json line,
+// so that it can be replayed or at least looked at.
+func (n *notifier) writeDeadLetter(sink string, e event, deliveryErr error) {
+	n.log.WithError(deliveryErr).Errorf("Event %s couldn't be delivered to %s", e.Type, sink)
+	if n.deadLetterPath == "" {
+		return
+	}
+	line, err := json.Marshal(deadLetter{Time: time.Now().UTC(), Sink: sink, Error: deliveryErr.Error(), Event: e})
+	if err != nil {
+		n.log.WithError(err).Errorf("Got an error while encoding the dead letter of event %s", e.Type)
+		return
+	}
+
+	n.deadLetterLock.Lock()
+	defer n.deadLetterLock.Unlock()
+	file, err := os.OpenFile(n.deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		n.log.WithError(err).Errorf("Got an error while writing event %s to dead letter file %s", e.Type, n.deadLetterPath)
+	}
+}
+
+// Close stops accepting events and gives the queued ones timeout to be delivered, the remaining ones
+// going to the dead letter file.
+func (n *notifier) Close(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	n.closeLock.Lock()
+	if *n.closed {
+		n.closeLock.Unlock()
+		return
+	}
+	*n.closed = true
+	for _, q := range n.queues {
+		close(q.queue)
+	}
+	n.closeLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		n.log.Warnf("Events still not delivered after %s, writing them to the dead letter file", timeout)
+	}
+	n.cancel()
+	<-done
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps the events delivered to it.
+type recordingSink struct {
+	events []event
+	lock   sync.Mutex
+}
+
+func (s *recordingSink) deliver(_ context.Context, e event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSink) types() []eventType {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	types := make([]eventType, 0, len(s.events))
+	for _, e := range s.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func writeNotificationsConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "notifications.json")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadNotificationsConfig(t *testing.T) {
+	config, err := loadNotificationsConfig(writeNotificationsConfig(t, `{"webhooks": [
+		{"url": "https://example.org/hook", "events": ["transfer.failed"]}
+	]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "webhook example.org", config.Webhooks[0].Name)
+
+	for _, content := range []string{
+		`{"webhooks": [{"url": "example.org/hook"}]}`,
+		`{"webhooks": [{"url": "https://example.org/hook", "events": ["transfer.lost"]}]}`,
+		`{"webhooks": [{"url": "https://example.org/hook", "unknown": true}]}`,
+		`{"webhooks": `,
+	} {
+		_, err = loadNotificationsConfig(writeNotificationsConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var bodies []string
+	var lock sync.Mutex
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		signature := "sha256=" + signPayload([]byte("hook-secret"), r.Header.Get("X-Companion-Timestamp"), body)
+		if r.Header.Get("X-Companion-Signature") != signature || r.Header.Get("X-Companion-Event") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	sink, err := newWebhookSink(webhookConfig{Name: "test", URL: server.URL, Secret: "hook-secret",
+		Template: `{"text": {{ json (printf "%s on %s: %s" .Type .Node .Transfer.TxId) }}}`})
+	assert.Nil(t, err)
+	n := newNotifier(3, time.Millisecond, "", newTestLogger())
+	n.addSink("test", sink, []eventType{eventTransferConfirmed})
+	fleetNotifier := n.withNode("node-1")
+
+	fleetNotifier.notify(event{Type: eventTransferSubmitted, Transfer: &transferRecord{TxId: "tx-1"}})
+	fleetNotifier.notify(event{Type: eventTransferConfirmed, Transfer: &transferRecord{TxId: "tx-1"}})
+	n.Close(5 * time.Second)
+
+	// Delivered after 2 retries, the submitted event being filtered out
+	assert.Equal(t, []string{`{"text": "transfer.confirmed on node-1: tx-1"}`}, bodies)
+}
+
+func TestNotifierDeadLetter(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusBadRequest)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	sink, err := newWebhookSink(webhookConfig{Name: "test", URL: server.URL})
+	assert.Nil(t, err)
+	n := newNotifier(2, time.Millisecond, path, newTestLogger())
+	n.addSink("test", sink, nil)
+
+	// Rejected right away, then the retries are exhausted
+	n.notify(event{Type: eventNodeOutOfSync, Endpoint: "http://alephium:12973"})
+	time.Sleep(100 * time.Millisecond)
+	status.Store(http.StatusInternalServerError)
+	n.notify(event{Type: eventWalletCreated, Wallet: "mining"})
+	n.Close(5 * time.Second)
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	var letter deadLetter
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &letter))
+	assert.Equal(t, "test", letter.Sink)
+	assert.Equal(t, eventNodeOutOfSync, letter.Event.Type)
+	assert.Contains(t, letter.Error, "400")
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &letter))
+	assert.Equal(t, "mining", letter.Event.Wallet)
+	assert.Contains(t, letter.Error, "500")
+}
+
+func TestTransferNotifications(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25")
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	sink := &recordingSink{}
+	n := newNotifier(0, time.Millisecond, "", newTestLogger())
+	n.addSink("recording", sink, nil)
+	handler.notifier = n
+
+	err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	node.wallets["mining"].locked = true
+	handler.secrets = testWalletSecrets("wrong")
+	err = handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.NotNil(t, err)
+	n.Close(5 * time.Second)
+
+	assert.Equal(t, []eventType{eventTransferSubmitted, eventTransferConfirmed, eventTransferFailed}, sink.types())
+	assert.Equal(t, "tx-1", sink.events[1].Transfer.TxId)
+	assert.Equal(t, transferStatusConfirmed, sink.events[1].Transfer.Status)
+	assert.Equal(t, transferStatusSubmitted, sink.events[0].Transfer.Status)
+}
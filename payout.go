@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// payoutRecipient is one destination of the mining rewards, receiving percentage % of them.
+type payoutRecipient struct {
+	address    string
+	percentage int64
+}
+
+// payoutSpec lists the destinations of the mining rewards, i.e. addrA:70,addrB:25,addrC:5.
+// A single address without percentage receives 100% of the rewards.
+type payoutSpec []payoutRecipient
+
+func parsePayoutSpec(spec string) (payoutSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("payout spec is empty")
+	}
+	if !strings.ContainsAny(spec, ":,") {
+		return payoutSpec{{address: spec, percentage: 100}}, nil
+	}
+
+	payout := make(payoutSpec, 0)
+	seen := make(map[string]bool)
+	total := int64(0)
+	for _, part := range strings.Split(spec, ",") {
+		split := strings.Split(strings.TrimSpace(part), ":")
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("payout %s is not of the form address:percentage", part)
+		}
+		address := strings.TrimSpace(split[0])
+		percentage, err := strconv.ParseInt(strings.TrimSpace(split[1]), 10, 64)
+		if err != nil || percentage <= 0 || percentage > 100 {
+			return nil, fmt.Errorf("percentage of payout %s is not an integer between 1 and 100", part)
+		}
+		if seen[address] {
+			return nil, fmt.Errorf("address %s appears more than once in the payout spec", address)
+		}
+		seen[address] = true
+		total += percentage
+		payout = append(payout, payoutRecipient{address: address, percentage: percentage})
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("percentages of the payout spec sum up to %d, not 100", total)
+	}
+	return payout, nil
+}
+
+// validate checks that the addresses of the payout are valid Alephium addresses which can receive payouts.
+func (p payoutSpec) validate() error {
+	for _, recipient := range p {
+		_, err := parsePayoutAddress(recipient.address)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p payoutSpec) addresses() []string {
+	addresses := make([]string, 0, len(p))
+	for _, recipient := range p {
+		addresses = append(addresses, recipient.address)
+	}
+	return addresses
+}
+
+// split divides amount between the recipients according to their percentage.
+// The rounding dust is given to the recipient with the highest percentage (the first one
+// in case of tie) so that the whole amount is always distributed, and is returned as well.
+func (p payoutSpec) split(amount ALPH) ([]ALPH, ALPH) {
+	shares := make([]ALPH, 0, len(p))
+	distributed := ALPH{Amount: new(big.Int)}
+	largest := 0
+	for i, recipient := range p {
+		share := amount.Multiply(recipient.percentage).Divide(100)
+		shares = append(shares, share)
+		distributed = distributed.Add(share)
+		if recipient.percentage > p[largest].percentage {
+			largest = i
+		}
+	}
+	dust := amount.Subtract(distributed)
+	shares[largest] = shares[largest].Add(dust)
+	return shares, dust
+}
+
+func (p payoutSpec) String() string {
+	parts := make([]string, 0, len(p))
+	for _, recipient := range p {
+		parts = append(parts, fmt.Sprintf("%s (%d%%)", recipient.address, recipient.percentage))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParsePayoutSpec(t *testing.T) {
+	payout, err := parsePayoutSpec("1abc")
+	assert.Nil(t, err)
+	assert.Equal(t, payoutSpec{{address: "1abc", percentage: 100}}, payout)
+
+	payout, err = parsePayoutSpec("1abc:70, 1def:25,1ghi:5")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1abc", "1def", "1ghi"}, payout.addresses())
+
+	for _, spec := range []string{"", "1abc:70,1def:20", "1abc:70,1def", "1abc:0,1def:100", "1abc:50,1abc:50", "1abc:x,1def:50"} {
+		_, err = parsePayoutSpec(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestPayoutSplit(t *testing.T) {
+	payout, err := parsePayoutSpec("1abc:25,1def:70,1ghi:5")
+	assert.Nil(t, err)
+
+	amount, _ := ALPHFromCoinString("1000000000000000003")
+	shares, dust := payout.split(amount)
+	assert.Equal(t, "1", dust.String())
+	assert.Equal(t, "250000000000000000", shares[0].String())
+	assert.Equal(t, "700000000000000003", shares[1].String())
+	assert.Equal(t, "50000000000000000", shares[2].String())
+
+	total := shares[0].Add(shares[1]).Add(shares[2])
+	assert.Equal(t, 0, total.Cmp(amount))
+}
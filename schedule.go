@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// newTransferSchedule returns the schedule of the transfers. If expression is set, it's a standard
+// cron expression (minute, hour, day of month, month, day of week) evaluated in the given time zone,
+// which also supports the day of week suffix #n to express the nth given day of week of the month,
+// i.e. "0 2 * * MON#1" for the first Monday of the month at 02:00. Otherwise, the transfers happen
+// every frequency.
+func newTransferSchedule(expression string, timezone string, frequency time.Duration) (cron.Schedule, error) {
+	if expression == "" {
+		if frequency <= 0 {
+			return nil, fmt.Errorf("transfer frequency %s must be positive", frequency)
+		}
+		return cron.Every(frequency), nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("time zone %s is not valid: %w", timezone, err)
+	}
+
+	fields := strings.Fields(expression)
+	nth := 0
+	if len(fields) == 5 && strings.Contains(fields[4], "#") {
+		split := strings.Split(fields[4], "#")
+		if len(split) == 2 {
+			nth, _ = strconv.Atoi(split[1])
+		}
+		if nth < 1 || nth > 5 {
+			return nil, fmt.Errorf("day of week %s of schedule %s is not of the form day#n, n between 1 and 5",
+				fields[4], expression)
+		}
+		if fields[2] != "*" && fields[2] != "?" {
+			return nil, fmt.Errorf("schedule %s can't restrict both the day of month and the nth day of week", expression)
+		}
+		lastDay := nth * 7
+		if lastDay > 31 {
+			lastDay = 31
+		}
+		fields[2] = fmt.Sprintf("%d-%d", (nth-1)*7+1, lastDay)
+		fields[4] = split[0]
+	}
+
+	schedule, err := cron.ParseStandard(strings.Join(fields, " "))
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s is not valid: %w", expression, err)
+	}
+	specSchedule, ok := schedule.(*cron.SpecSchedule)
+	if !ok {
+		return schedule, nil
+	}
+	specSchedule.Location = location
+	if nth > 0 {
+		return &nthWeekdaySchedule{spec: specSchedule}, nil
+	}
+	return specSchedule, nil
+}
+
+// nthWeekdaySchedule requires both the day of month and the day of week of spec to match,
+// whereas cron matches either of them when both are restricted.
+type nthWeekdaySchedule struct {
+	spec *cron.SpecSchedule
+}
+
+func (s *nthWeekdaySchedule) Next(t time.Time) time.Time {
+	next := s.spec.Next(t)
+	for !next.IsZero() {
+		if s.spec.Dom&(1<<uint(next.Day())) > 0 && s.spec.Dow&(1<<uint(next.Weekday())) > 0 {
+			return next
+		}
+		next = s.spec.Next(next)
+	}
+	return next
+}
+
+// missedRun tells if a run was scheduled between the last run and now.
+func missedRun(schedule cron.Schedule, lastRun time.Time, now time.Time) bool {
+	if lastRun.IsZero() {
+		return false
+	}
+	next := schedule.Next(lastRun)
+	return !next.IsZero() && next.Before(now)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTransferScheduleFrequency(t *testing.T) {
+	schedule, err := newTransferSchedule("", "UTC", 15*time.Minute)
+	assert.Nil(t, err)
+	now := time.Date(2023, 2, 6, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, now.Add(15*time.Minute), schedule.Next(now))
+}
+
+func TestTransferScheduleDaily(t *testing.T) {
+	schedule, err := newTransferSchedule("0 2 * * *", "Europe/Zurich", 0)
+	assert.Nil(t, err)
+	now := time.Date(2023, 2, 6, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2023, 2, 7, 1, 0, 0, 0, time.UTC), schedule.Next(now).UTC())
+}
+
+func TestTransferScheduleFirstMonday(t *testing.T) {
+	schedule, err := newTransferSchedule("0 2 * * MON#1", "UTC", 0)
+	assert.Nil(t, err)
+	now := time.Date(2023, 2, 6, 10, 0, 0, 0, time.UTC)
+	next := schedule.Next(now)
+	assert.Equal(t, time.Date(2023, 3, 6, 2, 0, 0, 0, time.UTC), next)
+	assert.Equal(t, time.Date(2023, 4, 3, 2, 0, 0, 0, time.UTC), schedule.Next(next))
+}
+
+func TestTransferScheduleInvalid(t *testing.T) {
+	for _, expression := range []string{"0 2 * *", "0 2 * * MON#6", "0 2 1 * MON#1", "0 25 * * *"} {
+		_, err := newTransferSchedule(expression, "UTC", 0)
+		assert.NotNil(t, err, expression)
+	}
+	_, err := newTransferSchedule("0 2 * * *", "Mars/Olympus", 0)
+	assert.NotNil(t, err)
+}
+
+func TestMissedRun(t *testing.T) {
+	schedule, err := newTransferSchedule("0 2 * * *", "UTC", 0)
+	assert.Nil(t, err)
+	now := time.Date(2023, 2, 6, 10, 0, 0, 0, time.UTC)
+	assert.False(t, missedRun(schedule, time.Time{}, now))
+	assert.False(t, missedRun(schedule, now.Add(-time.Hour), now))
+	assert.True(t, missedRun(schedule, now.Add(-24*time.Hour), now))
+}
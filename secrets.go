@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// secret is a sensitive setting, given either as is or as a file to read it from, i.e. a mounted docker
+// or kubernetes secret set with the *_FILE variant of the env var. A secret from a file is read each time
+// it's needed, so that a changed file is picked up without restart, and is not kept in memory.
+type secret struct {
+	name  string
+	value []byte
+	path  string
+}
+
+func newSecret(name string, value string, path string) *secret {
+	if path != "" {
+		return &secret{name: name, path: path}
+	}
+	return &secret{name: name, value: []byte(value)}
+}
+
+// reveal returns the value of the secret, a copy to wipe once used. A nil secret is empty.
+func (s *secret) reveal() ([]byte, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if s.path == "" {
+		return bytes.Clone(s.value), nil
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s from %s: %w", s.name, s.path, err)
+	}
+	// Editors and kubectl often leave a trailing newline, which is not part of the secret
+	return bytes.TrimRight(content, "\r\n"), nil
+}
+
+// revealString returns the value of the secret as a string, for the node API which only takes strings.
+// Unlike the bytes returned by reveal, the string can't be wiped and is left to the garbage collector.
+func (s *secret) revealString() (string, error) {
+	value, err := s.reveal()
+	if err != nil {
+		return "", err
+	}
+	defer wipe(value)
+	return string(value), nil
+}
+
+// wipe zeroes the secret given as is, once it's not needed anymore.
+func (s *secret) wipe() {
+	if s != nil {
+		wipe(s.value)
+	}
+}
+
+// wipe zeroes revealed secrets once they're not needed anymore.
+func wipe(values ...[]byte) {
+	for _, value := range values {
+		for i := range value {
+			value[i] = 0
+		}
+	}
+}
+
+// The secrets of the mining wallet given by a secretProvider.
+const (
+	walletPasswordSecret           = "WALLET_PASSWORD"
+	walletMnemonicSecret           = "WALLET_MNEMONIC"
+	walletMnemonicPassphraseSecret = "WALLET_MNEMONIC_PASSPHRASE"
+)
+
+// secretProvider gives the secrets of the mining wallet, read from where they're kept each time they're
+// needed.
+type secretProvider interface {
+	// secret returns the value of the named secret, empty if it's not set, a copy to wipe once used.
+	secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// revealSecret returns the named secret of provider as a string, for the node API which only takes strings.
+func revealSecret(ctx context.Context, provider secretProvider, name string) (string, error) {
+	value, err := provider.secret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(value)
+	return string(value), nil
+}
+
+// envSecretProvider gives the secrets set in the env vars or in the files of their *_FILE variant.
+type envSecretProvider map[string]*secret
+
+func (p envSecretProvider) secret(_ context.Context, name string) ([]byte, error) {
+	return p[name].reveal()
+}
+
+func (p envSecretProvider) wipe() {
+	for _, s := range p {
+		s.wipe()
+	}
+}
+
+// walletSecrets returns the password, the mnemonic and the mnemonic passphrase of the mining wallet.
+func (env envConfig) walletSecrets() envSecretProvider {
+	return envSecretProvider{
+		walletPasswordSecret:           newSecret(walletPasswordSecret, env.WalletPassword, env.WalletPasswordFile),
+		walletMnemonicSecret:           newSecret(walletMnemonicSecret, env.WalletMnemonic, env.WalletMnemonicFile),
+		walletMnemonicPassphraseSecret: newSecret(walletMnemonicPassphraseSecret, env.WalletMnemonicPassphrase, env.WalletMnemonicPassphraseFile),
+	}
+}
+
+// clearSecrets drops the secrets given as is from env, once they're kept in their secret, so that they're
+// not copied along with the other settings. The strings can't be wiped and are left to the garbage collector.
+func (env *envConfig) clearSecrets() {
+	env.AlephiumApiKey = ""
+	env.WalletPassword = ""
+	env.WalletMnemonic = ""
+	env.WalletMnemonicPassphrase = ""
+	env.VaultToken = ""
+	env.VaultSecretId = ""
+	env.SmtpPassword = ""
+}
+
+func (env envConfig) apiKeySecret() *secret {
+	return newSecret("ALEPHIUM_API_KEY", env.AlephiumApiKey, env.AlephiumApiKeyFile)
+}
+
+func (env envConfig) smtpPasswordSecret() *secret {
+	return newSecret("SMTP_PASSWORD", env.SmtpPassword, env.SmtpPasswordFile)
+}
+
+// validateSecrets checks that the secret files can be read, that the wallet password is set and that
+// it's not the default one, unless explicitly allowed. The password kept in vault is checked at startup.
+func (env envConfig) validateSecrets() error {
+	secrets := env.walletSecrets()
+	for _, s := range []*secret{secrets[walletPasswordSecret], secrets[walletMnemonicSecret],
+		secrets[walletMnemonicPassphraseSecret], env.apiKeySecret(), env.vaultTokenSecret(), env.vaultSecretIdSecret(), env.smtpPasswordSecret()} {
+		value, err := s.reveal()
+		if err != nil {
+			return err
+		}
+		wipe(value)
+	}
+	if env.VaultAddr != "" {
+		return nil
+	}
+	return checkWalletPassword(context.Background(), secrets, env.AllowDefaultWalletPassword)
+}
+
+// checkWalletPassword checks that the wallet password of provider is set and that it's not the default
+// one, unless allowDefault.
+func checkWalletPassword(ctx context.Context, provider secretProvider, allowDefault bool) error {
+	value, err := provider.secret(ctx, walletPasswordSecret)
+	if err != nil {
+		return err
+	}
+	defer wipe(value)
+	if len(value) == 0 {
+		return fmt.Errorf("WALLET_PASSWORD is mandatory")
+	}
+	if string(value) == DefaultWalletPassword && !allowDefault {
+		return fmt.Errorf("WALLET_PASSWORD is the default password, set your own or ALLOW_DEFAULT_WALLET_PASSWORD=true " +
+			"if you really want to use it")
+	}
+	return nil
+}
+
+// apiKeyTransport sets the API key of the node on each request, reading it again from its file if any.
+type apiKeyTransport struct {
+	apiKey *secret
+	next   http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	apiKey, err := t.apiKey.revealString()
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("X-API-KEY", apiKey)
+	}
+	return t.next.RoundTrip(request)
+}
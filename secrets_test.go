@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWalletSecrets returns the secrets of a wallet with password and without mnemonic.
+func testWalletSecrets(password string) secretProvider {
+	return envConfig{WalletPassword: password}.walletSecrets()
+}
+
+func TestSecretFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, os.WriteFile(path, []byte("secret\n"), 0600))
+	s := newSecret("WALLET_PASSWORD", "ignored", path)
+	value, err := s.revealString()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", value)
+
+	// The file is read again, a rotated secret is used without restart
+	assert.Nil(t, os.WriteFile(path, []byte("rotated"), 0600))
+	value, err = s.revealString()
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated", value)
+
+	assert.Nil(t, os.Remove(path))
+	_, err = s.reveal()
+	assert.NotNil(t, err)
+
+	value, err = newSecret("WALLET_PASSWORD", "secret", "").revealString()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", value)
+}
+
+func TestValidateSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, os.WriteFile(path, []byte(DefaultWalletPassword+"\n"), 0600))
+	env := envConfig{WalletPassword: "secret", WalletPasswordFile: path}
+	assert.NotNil(t, env.validateSecrets())
+	env.AllowDefaultWalletPassword = true
+	assert.Nil(t, env.validateSecrets())
+
+	env = envConfig{WalletPassword: ""}
+	assert.NotNil(t, env.validateSecrets())
+	env = envConfig{WalletPassword: "secret", WalletMnemonicFile: filepath.Join(t.TempDir(), "missing")}
+	assert.NotNil(t, env.validateSecrets())
+}
+
+func TestAPIKeyTransport(t *testing.T) {
+	var apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("X-API-KEY")
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "api-key")
+	assert.Nil(t, os.WriteFile(path, []byte("key-1\n"), 0600))
+	client := &http.Client{Transport: &apiKeyTransport{apiKey: newSecret("ALEPHIUM_API_KEY", "", path), next: http.DefaultTransport}}
+	for _, expected := range []string{"key-1", "key-2"} {
+		assert.Nil(t, os.WriteFile(path, []byte(expected), 0600))
+		response, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		response.Body.Close()
+		assert.Equal(t, expected, apiKey)
+	}
+}
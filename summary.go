@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// earningsSummary sums up what the mining wallet earned over a period, notified on EARNINGS_SUMMARY_SCHEDULE:
+// the coinbase rewards of the blocks mined to the miner addresses, and the transfers confirmed with their fees.
+type earningsSummary struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Blocks    int       `json:"blocks"`
+	Rewards   ALPH      `json:"rewards"`
+	Transfers int       `json:"transfers"`
+	// Amount is the amount swept by the transfers, out of the rewards of the period or of the previous ones.
+	Amount ALPH `json:"amount"`
+	Fees   ALPH `json:"fees"`
+	// Balance is the balance of the mining wallet at the end of the period, if known.
+	Balance *ALPH `json:"balance,omitempty"`
+}
+
+func newEarningsSummary(from time.Time) earningsSummary {
+	return earningsSummary{From: from, Rewards: ALPH{Amount: new(big.Int)}, Amount: ALPH{Amount: new(big.Int)},
+		Fees: ALPH{Amount: new(big.Int)}}
+}
+
+// earningsSummarizer notifies the earnings summaries of the mining wallet on schedule. With a ledger, the
+// earnings are summed up by the ledger along with the earnings per period, so that the earnings made before
+// a restart are part of the next summary. They're summed up in memory since the start otherwise.
+type earningsSummarizer struct {
+	alephiumClient nodeClient
+	walletName     string
+	schedule       cron.Schedule
+	ledger         *transferLedger
+	// summary sums up the earnings since the last summary when there's no ledger.
+	summary  earningsSummary
+	lock     *sync.Mutex
+	notifier *notifier
+	log      *logrus.Logger
+}
+
+func newEarningsSummarizer(alephiumClient nodeClient, walletName string, schedule cron.Schedule,
+	ledger *transferLedger, notifier *notifier, log *logrus.Logger) *earningsSummarizer {
+	return &earningsSummarizer{
+		alephiumClient: alephiumClient,
+		walletName:     walletName,
+		schedule:       schedule,
+		ledger:         ledger,
+		summary:        newEarningsSummary(time.Now().UTC()),
+		lock:           &sync.Mutex{},
+		notifier:       notifier,
+		log:            log,
+	}
+}
+
+// addBlock accounts a block mined to the miner addresses in the summary of the current period, unless the
+// ledger does. A nil summarizer ignores it.
+func (s *earningsSummarizer) addBlock(block minedBlock) {
+	if s == nil || s.ledger != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.summary.Blocks++
+	s.summary.Rewards = s.summary.Rewards.Add(block.Reward)
+}
+
+// addTransfer accounts a confirmed transfer in the summary of the current period, unless the ledger does.
+// A nil summarizer ignores it.
+func (s *earningsSummarizer) addTransfer(record *transferRecord) {
+	if s == nil || s.ledger != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.summary.Transfers++
+	s.summary.Amount = s.summary.Amount.Add(record.Amount)
+	s.summary.Fees = s.summary.Fees.Add(record.Fee)
+}
+
+// takeSummary returns the summary of the period ending now and starts a new one.
+func (s *earningsSummarizer) takeSummary(now time.Time) (earningsSummary, error) {
+	if s.ledger != nil {
+		e, err := s.ledger.takeEarningsSince(earningsSummaryKey, now)
+		if err != nil {
+			return earningsSummary{}, err
+		}
+		return earningsSummary{From: e.Start, To: e.End, Blocks: e.Blocks, Rewards: e.Rewards, Transfers: e.Transfers,
+			Amount: e.Swept, Fees: e.Fees}, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summary := s.summary
+	summary.To = now
+	s.summary = newEarningsSummary(now)
+	return summary, nil
+}
+
+// run notifies the earnings summary of the period on schedule, until ctx is done.
+func (s *earningsSummarizer) run(ctx context.Context, log *logrus.Entry) error {
+	for {
+		next := s.schedule.Next(time.Now())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+		s.notifySummary(ctx, time.Now().UTC(), log)
+	}
+}
+
+// notifySummary notifies the summary of the period ending now, along with the balance of the wallet.
+// The summary is still notified if the balance can't be read.
+func (s *earningsSummarizer) notifySummary(ctx context.Context, now time.Time, log *logrus.Entry) {
+	summary, err := s.takeSummary(now)
+	if err != nil {
+		s.log.WithError(err).Errorf("Earnings of wallet %s can't be read from the ledger, no summary notified",
+			s.walletName)
+		return
+	}
+	balances, err := getWalletBalances(ctx, s.alephiumClient, s.walletName, log)
+	if err == nil {
+		balance, ok := ALPHFromCoinString(balances.TotalBalance)
+		if ok {
+			summary.Balance = &balance
+		}
+	} else {
+		s.log.WithError(err).Warnf("Balance of wallet %s can't be read, notifying the earnings summary without it",
+			s.walletName)
+	}
+	s.notifier.notify(event{Type: eventEarningsSummary, Endpoint: s.alephiumClient.Host(), Wallet: s.walletName,
+		Summary: &summary})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	errorClassTransient = "transient"
+	errorClassFatal     = "fatal"
+
+	loopStateRunning = "running"
+	loopStateBackoff = "backoff"
+	loopStateStopped = "stopped"
+	loopStateFailed  = "failed"
+)
+
+// fatalError marks an error which restarting the loop can't recover from, i.e. a configuration error.
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fatalError) Unwrap() error {
+	return e.err
+}
+
+func fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err: err}
+}
+
+// classifyError tells whether an error is transient, i.e. the node being unreachable or answering
+// a server error, or fatal, i.e. flagged as such or the node refusing the credentials.
+func classifyError(err error) string {
+	var fatalErr *fatalError
+	if errors.As(err, &fatalErr) {
+		return errorClassFatal
+	}
+	var apiErr *nodeAPIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		return errorClassFatal
+	}
+	return errorClassTransient
+}
+
+// loopStatus is the state of a supervised loop, as served on /supervisor/status.
+type loopStatus struct {
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	Restarts    int       `json:"restarts"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+	NextRestart time.Time `json:"nextRestart,omitempty"`
+}
+
+// supervisor runs the background loops of the companion, restarting them with exponential backoff
+// and jitter when they fail with a transient error. A fatal error stops the loop and is returned.
+type supervisor struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	metrics        *metrics
+	log            *logrus.Logger
+	loops          map[string]*loopStatus
+	loopsLock      *sync.RWMutex
+}
+
+func newSupervisor(initialBackoff time.Duration, maxBackoff time.Duration, metrics *metrics,
+	log *logrus.Logger) *supervisor {
+
+	return &supervisor{
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		metrics:        metrics,
+		log:            log,
+		loops:          make(map[string]*loopStatus),
+		loopsLock:      &sync.RWMutex{},
+	}
+}
+
+// run runs loop until it returns without error, the context is done or it fails with a fatal error.
+func (s *supervisor) run(ctx context.Context, name string, loop func(ctx context.Context) error) error {
+	attempt := 0
+	for {
+		s.update(name, func(status *loopStatus) { status.State = loopStateRunning })
+		s.metrics.loopUp.With(prometheus.Labels{"loop": name}).Set(1)
+		started := time.Now()
+		err := loop(ctx)
+		s.metrics.loopUp.With(prometheus.Labels{"loop": name}).Set(0)
+		if err == nil || ctx.Err() != nil {
+			s.update(name, func(status *loopStatus) { status.State = loopStateStopped })
+			return nil
+		}
+
+		class := classifyError(err)
+		s.metrics.loopLastError.With(prometheus.Labels{"loop": name, "class": class}).SetToCurrentTime()
+		if class == errorClassFatal {
+			s.log.WithError(err).Errorf("Loop %s failed with a fatal error, not restarting it", name)
+			s.update(name, func(status *loopStatus) {
+				status.State = loopStateFailed
+				status.LastError = err.Error()
+				status.LastErrorAt = time.Now()
+			})
+			return err
+		}
+
+		// A loop which ran fine for a while starts over with the initial backoff
+		if time.Since(started) > s.maxBackoff {
+			attempt = 0
+		}
+		backoff := s.backoff(attempt)
+		attempt++
+		s.log.WithError(err).Warnf("Loop %s failed with a transient error, restarting it in %s", name, backoff)
+		s.metrics.loopRestarts.With(prometheus.Labels{"loop": name}).Inc()
+		s.update(name, func(status *loopStatus) {
+			status.State = loopStateBackoff
+			status.Restarts++
+			status.LastError = err.Error()
+			status.LastErrorAt = time.Now()
+			status.NextRestart = time.Now().Add(backoff)
+		})
+
+		select {
+		case <-ctx.Done():
+			s.update(name, func(status *loopStatus) { status.State = loopStateStopped })
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// backoff returns the delay before the given restart attempt, doubling from initialBackoff up to
+// maxBackoff, with a jitter of up to half of it so that the loops don't restart in lockstep.
+func (s *supervisor) backoff(attempt int) time.Duration {
+	backoff := s.initialBackoff
+	for i := 0; i < attempt && backoff < s.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.maxBackoff {
+		backoff = s.maxBackoff
+	}
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (s *supervisor) update(name string, f func(status *loopStatus)) {
+	s.loopsLock.Lock()
+	defer s.loopsLock.Unlock()
+	status, ok := s.loops[name]
+	if !ok {
+		status = &loopStatus{Name: name}
+		s.loops[name] = status
+	}
+	f(status)
+}
+
+func (s *supervisor) status() []loopStatus {
+	s.loopsLock.RLock()
+	defer s.loopsLock.RUnlock()
+	statuses := make([]loopStatus, 0, len(s.loops))
+	for _, status := range s.loops {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// supervisorStatusHandler serves the status of the loops of all the supervisors, one per node in a fleet.
+func supervisorStatusHandler(supervisors []*supervisor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]loopStatus, 0)
+		for _, s := range supervisors {
+			statuses = append(statuses, s.status()...)
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]loopStatus{"loops": statuses})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, errorClassTransient, classifyError(fmt.Errorf("connection refused")))
+	assert.Equal(t, errorClassTransient, classifyError(&nodeAPIError{StatusCode: http.StatusInternalServerError,
+		err: fmt.Errorf("500 Internal Server Error")}))
+	assert.Equal(t, errorClassFatal, classifyError(fmt.Errorf("unlocking: %w", &nodeAPIError{
+		StatusCode: http.StatusUnauthorized, err: fmt.Errorf("401 Unauthorized")})))
+	assert.Equal(t, errorClassFatal, classifyError(fatal(fmt.Errorf("invalid config"))))
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	s := newSupervisor(time.Second, 10*time.Second, newTestMetrics(), newTestLogger())
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second} {
+		backoff := s.backoff(attempt)
+		assert.GreaterOrEqual(t, backoff, max/2)
+		assert.LessOrEqual(t, backoff, max)
+	}
+}
+
+func TestSupervisorRestarts(t *testing.T) {
+	s := newSupervisor(time.Millisecond, 10*time.Millisecond, newTestMetrics(), newTestLogger())
+	runs := 0
+	err := s.run(context.Background(), "test-restarts", func(ctx context.Context) error {
+		runs++
+		if runs < 3 {
+			return fmt.Errorf("node unreachable")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, runs)
+	status := s.status()
+	assert.Len(t, status, 1)
+	assert.Equal(t, loopStateStopped, status[0].State)
+	assert.Equal(t, 2, status[0].Restarts)
+	assert.Equal(t, "node unreachable", status[0].LastError)
+
+	runs = 0
+	err = s.run(context.Background(), "test-fatal", func(ctx context.Context) error {
+		runs++
+		return fatal(fmt.Errorf("invalid config"))
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, loopStateFailed, s.status()[0].State)
+}
@@ -2,46 +2,101 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	alephium "github.com/alephium/go-sdk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
+	"math/big"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// provisionalFee is the fee used to build a first version of a tx, before its actual fee is known.
+// It corresponds to the max gas per tx at the default gas price.
+var provisionalFee = ALPH{Amount: new(big.Int).SetInt64(500000000000000000)}
+
+const (
+	keepReservePerAddress = "address"
+	keepReservePerWallet  = "wallet"
+	// transferLivenessGrace is how late a planned transfer run can be before the transfer loop is deemed stuck.
+	transferLivenessGrace = 30 * time.Minute
+)
+
+// transferSettings are the settings of the transfers which can be reconfigured. They are never modified,
+// reconfigure swapping them for new ones, so that a run can go on with the settings it started with.
+type transferSettings struct {
+	payout            payoutSpec
+	transferMinAmount ALPH
+	keepReserve       ALPH
+	keepReserveScope  string
+	schedule          cron.Schedule
+}
+
 type transferHandler struct {
-	alephiumClient     *alephium.APIClient
-	walletName         string
-	walletPassword     string
-	mnemonicPassphrase string
-	transferAddress    string
-	transferMinAmount  ALPH
-	transferFrequency  time.Duration
-	immediate          bool
-	metrics            *metrics
+	alephiumClient    nodeClient
+	walletName        string
+	secrets           secretProvider
+	settings          *transferSettings
+	catchUp           bool
+	immediate         bool
+	dryRun            bool
+	checkPayoutGroups bool
+	// payoutChecked tells whether the groups of the payout addresses of the settings were checked with the
+	// node, it's guarded by settingsLock.
+	payoutChecked bool
+	// started tells whether handle was started before, by the supervisor which restarts it after an error.
+	started          bool
+	shutdownGrace    time.Duration
+	confirmationPoll time.Duration
+	nextRun          time.Time
+	nextRunLock      *sync.RWMutex
+	settingsLock     *sync.RWMutex
+	reconfigured     chan struct{}
+	paused           *atomic.Bool
+	metrics          *metrics
+	ledger           *transferLedger
+	// summarizer accounts the confirmed transfers in the earnings summary, if not nil.
+	summarizer         *earningsSummarizer
+	notifier           *notifier
 	log                *logrus.Logger
 	concurrentExecLock *sync.RWMutex
 }
 
-func newTransferHandler(alephiumClient *alephium.APIClient, walletName string, walletPassword string,
-	mnemonicPassphrase string, transferAddress string, transferMinAmount string, transferFrequency time.Duration,
-	immediate bool, metrics *metrics, log *logrus.Logger) (*transferHandler, error) {
+func newTransferHandler(alephiumClient nodeClient, walletName string, secrets secretProvider,
+	payout payoutSpec, transferMinAmount string, keepReserve string,
+	keepReserveScope string, schedule cron.Schedule, catchUp bool, immediate bool, dryRun bool,
+	checkPayoutGroups bool, shutdownGrace time.Duration, metrics *metrics, ledger *transferLedger,
+	summarizer *earningsSummarizer, notifier *notifier, log *logrus.Logger) (*transferHandler, error) {
 
-	minAlf, ok := ALPHFromCoinString(transferMinAmount)
-	if !ok {
-		return nil, fmt.Errorf("transferMinAmount %s is not a valid ALPH transfer amoount", transferMinAmount)
+	settings, err := newTransferSettings(payout, transferMinAmount, keepReserve, keepReserveScope, schedule)
+	if err != nil {
+		return nil, err
 	}
 
 	handler := &transferHandler{
 		alephiumClient:     alephiumClient,
 		walletName:         walletName,
-		walletPassword:     walletPassword,
-		mnemonicPassphrase: mnemonicPassphrase,
-		transferAddress:    transferAddress,
-		transferMinAmount:  minAlf,
-		transferFrequency:  transferFrequency,
+		secrets:            secrets,
+		settings:           settings,
+		catchUp:            catchUp,
 		immediate:          immediate,
+		dryRun:             dryRun,
+		checkPayoutGroups:  checkPayoutGroups,
+		shutdownGrace:      shutdownGrace,
+		confirmationPoll:   5 * time.Second,
+		nextRunLock:        &sync.RWMutex{},
+		settingsLock:       &sync.RWMutex{},
+		reconfigured:       make(chan struct{}, 1),
+		paused:             &atomic.Bool{},
 		metrics:            metrics,
+		ledger:             ledger,
+		summarizer:         summarizer,
+		notifier:           notifier,
 		log:                log,
 		concurrentExecLock: &sync.RWMutex{},
 	}
@@ -49,25 +104,192 @@ func newTransferHandler(alephiumClient *alephium.APIClient, walletName string, w
 	return handler, nil
 }
 
+func newTransferSettings(payout payoutSpec, transferMinAmount string, keepReserve string, keepReserveScope string,
+	schedule cron.Schedule) (*transferSettings, error) {
+
+	minAlf, reserve, err := parseTransferAmounts(transferMinAmount, keepReserve, keepReserveScope)
+	if err != nil {
+		return nil, err
+	}
+	return &transferSettings{payout: payout, transferMinAmount: minAlf, keepReserve: reserve,
+		keepReserveScope: keepReserveScope, schedule: schedule}, nil
+}
+
+// parseTransferAmounts parses the min amount to transfer, in nanoALPH, and the reserve to keep, in ALPH.
+func parseTransferAmounts(transferMinAmount string, keepReserve string, keepReserveScope string) (ALPH, ALPH, error) {
+	minAlf, ok := ALPHFromCoinString(transferMinAmount)
+	if !ok {
+		return ALPH{}, ALPH{}, fmt.Errorf("transferMinAmount %s is not a valid ALPH transfer amoount", transferMinAmount)
+	}
+	reserve, ok := ALPHFromALPHString(keepReserve)
+	if !ok || reserve.Amount.Sign() < 0 {
+		return ALPH{}, ALPH{}, fmt.Errorf("keepReserve %s is not a valid ALPH amount", keepReserve)
+	}
+	if keepReserveScope != keepReservePerAddress && keepReserveScope != keepReservePerWallet {
+		return ALPH{}, ALPH{}, fmt.Errorf("keepReserveScope %s is neither %s nor %s", keepReserveScope,
+			keepReservePerAddress, keepReservePerWallet)
+	}
+	return minAlf, reserve, nil
+}
+
+// reconfigure swaps the transfer settings. A run in progress completes with the previous settings, without
+// holding reconfigure up, and the next run is planned again with the new schedule.
+func (h *transferHandler) reconfigure(payout payoutSpec, transferMinAmount string, keepReserve string,
+	keepReserveScope string, schedule cron.Schedule) error {
+
+	settings, err := newTransferSettings(payout, transferMinAmount, keepReserve, keepReserveScope, schedule)
+	if err != nil {
+		return err
+	}
+	h.settingsLock.Lock()
+	h.settings = settings
+	h.payoutChecked = false
+	h.settingsLock.Unlock()
+
+	select {
+	case h.reconfigured <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// nextScheduledRun returns the next run of the schedule after t.
+func (h *transferHandler) nextScheduledRun(t time.Time) time.Time {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+	return h.settings.schedule.Next(t)
+}
+
 func (h *transferHandler) handle(ctx context.Context, log *logrus.Entry) error {
-	if h.immediate {
-		err := h.transfer(ctx, log)
-		if err != nil {
-			h.log.Debugf("Got an error while immediately transferring some amount. Err = %v", err)
-			return err
+	err := h.resumePendingTransfers(ctx, log)
+	if err != nil {
+		h.log.Debugf("Got an error while resuming pending transfers. Err = %v", err)
+		return err
+	}
+	// The immediate and catch-up runs happen on the first start only, not when restarted after an error
+	if !h.started && (h.immediate || h.hasMissedRun(log)) {
+		h.started = true
+		if h.isPaused() {
+			h.log.Infof("Transfers are paused, skipping the immediate run")
+		} else {
+			err := h.transfer(ctx, log)
+			if err != nil {
+				h.log.Debugf("Got an error while immediately transferring some amount. Err = %v", err)
+				return err
+			}
 		}
 	}
-	for range time.Tick(h.transferFrequency) {
+	h.started = true
+	for {
+		next := h.nextScheduledRun(time.Now())
+		h.setNextRun(next)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.reconfigured:
+			h.log.Infof("Transfer settings changed, planning the next run again")
+			continue
+		case <-time.After(time.Until(next)):
+		}
+		if h.isPaused() {
+			h.log.Infof("Transfers are paused, skipping the run planned at %s", next)
+			continue
+		}
 		err := h.transfer(ctx, log)
 		if err != nil {
 			h.log.Debugf("Got an error while transferring some amount. Err = %v", err)
 			return err
 		}
 	}
+}
+
+// pause skips the planned transfer runs until resume is called. Runs in progress are not interrupted.
+func (h *transferHandler) pause() {
+	h.paused.Store(true)
+	h.metrics.transferPaused.Set(1)
+}
+
+func (h *transferHandler) resume() {
+	h.paused.Store(false)
+	h.metrics.transferPaused.Set(0)
+}
+
+func (h *transferHandler) isPaused() bool {
+	return h.paused.Load()
+}
+
+// isRunning tells whether a transfer run is in progress.
+func (h *transferHandler) isRunning() bool {
+	if !h.concurrentExecLock.TryLock() {
+		return true
+	}
+	h.concurrentExecLock.Unlock()
+	return false
+}
+
+// hasMissedRun tells whether a run was scheduled while the companion was down, and should be caught up.
+func (h *transferHandler) hasMissedRun(log *logrus.Entry) bool {
+	if !h.catchUp || h.ledger == nil {
+		return false
+	}
+	lastRun, err := h.ledger.lastTransferRun()
+	if err != nil {
+		log.WithError(err).Warnf("Got an error while reading the last transfer run, not catching up")
+		return false
+	}
+	h.settingsLock.RLock()
+	missed := missedRun(h.settings.schedule, lastRun, time.Now())
+	h.settingsLock.RUnlock()
+	if missed {
+		log.Infof("A transfer was scheduled since the last run at %s, catching it up now", lastRun)
+		return true
+	}
+	return false
+}
+
+func (h *transferHandler) setNextRun(next time.Time) {
+	h.nextRunLock.Lock()
+	defer h.nextRunLock.Unlock()
+	h.nextRun = next
+	h.metrics.transferNextRun.Set(float64(next.Unix()))
+}
+
+func (h *transferHandler) getNextRun() time.Time {
+	h.nextRunLock.RLock()
+	defer h.nextRunLock.RUnlock()
+	return h.nextRun
+}
+
+// checkLiveness fails when the transfer loop looks stuck, i.e. the planned run is overdue by more than
+// transferLivenessGrace.
+func (h *transferHandler) checkLiveness() error {
+	next := h.getNextRun()
+	if next.IsZero() {
+		return nil
+	}
+	if late := time.Since(next); late > transferLivenessGrace {
+		return fmt.Errorf("transfer run planned at %s is %s late", next.Format(time.RFC3339), late.Round(time.Second))
+	}
 	return nil
 }
 
+// nextRunHandler serves the next planned transfer run.
+func (h *transferHandler) nextRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]time.Time{"nextRun": h.getNextRun()})
+}
+
+// transfer runs a transfer, notifying its failure unless interrupted by a shutdown.
 func (h *transferHandler) transfer(ctx context.Context, log *logrus.Entry) error {
+	err := h.runTransfer(ctx, log)
+	if err != nil && ctx.Err() == nil {
+		h.notifier.notify(event{Type: eventTransferFailed, Endpoint: h.alephiumClient.Host(), Wallet: h.walletName,
+			Error: err.Error()})
+	}
+	return err
+}
+
+func (h *transferHandler) runTransfer(ctx context.Context, log *logrus.Entry) error {
 
 	locked := h.concurrentExecLock.TryLock()
 	if !locked {
@@ -75,47 +297,520 @@ func (h *transferHandler) transfer(ctx context.Context, log *logrus.Entry) error
 		return nil
 	}
 	defer h.concurrentExecLock.Unlock()
+	// The run goes on with the settings it starts with, even if they get reconfigured meanwhile
+	h.settingsLock.RLock()
+	settings := h.settings
+	checkPayout := h.checkPayoutGroups && !h.payoutChecked
+	h.settingsLock.RUnlock()
+
+	// Once started, a run gets shutdownGrace to complete its in-flight sweeps when ctx is done
+	workCtx, cancelWork := graceContext(ctx, h.shutdownGrace)
+	defer cancelWork()
+
+	if checkPayout {
+		err := h.checkPayoutAddressGroups(workCtx, settings.payout, log)
+		if err != nil {
+			return err
+		}
+		h.settingsLock.Lock()
+		h.payoutChecked = h.payoutChecked || h.settings == settings
+		h.settingsLock.Unlock()
+	}
 
 	h.metrics.transferRun.Inc()
+	if h.ledger != nil && !h.dryRun {
+		err := h.ledger.saveLastTransferRun(time.Now())
+		if err != nil {
+			h.log.WithError(err).Debugf("Got an error while saving the last transfer run")
+			return err
+		}
+	}
 
-	wallet, err := getWalletStatus(ctx, h.alephiumClient, h.walletName, log)
+	wallet, err := getWalletStatus(workCtx, h.alephiumClient, h.walletName, log)
 	if err != nil {
 		h.log.WithError(err).Debugf("Got an error calling wallet status, err = %v", err)
 		return err
 	}
 	if wallet.Locked {
-		err := unlockWallet(ctx, h.alephiumClient, wallet.WalletName, h.walletPassword, h.mnemonicPassphrase, log)
+		err := unlockWalletWithSecrets(workCtx, h.alephiumClient, wallet.WalletName, h.secrets, log)
 		if err != nil {
 			h.log.WithError(err).Debugf("Got an error calling wallet unlock. Err = %v", err)
 			return err
 		}
 	}
 
-	sweep := alephium.NewSweep(h.transferAddress)
-	sweepAllReq := h.alephiumClient.WalletsApi.PostWalletsWalletNameSweepAllAddresses(ctx, wallet.WalletName).Sweep(*sweep)
-	transferRes, _, err := sweepAllReq.Execute()
+	walletBalances, err := getWalletBalances(workCtx, h.alephiumClient, wallet.WalletName, log)
 	if err != nil {
-		h.log.WithError(err).Debugf("Got an error while sweeping all")
+		h.log.WithError(err).Debugf("Got an error calling wallet balances")
 		return err
 	}
 
-	for _, tx := range transferRes.GetResults() {
-		h.log.Infof("New tx %s,%d->%d just submitted", tx.TxId, tx.FromGroup, tx.ToGroup)
-		var txConfirmed *alephium.Confirmed
-		for txConfirmed == nil {
+	publicKeys := make(map[string]string)
+	if !settings.useSweep() || h.dryRun {
+		walletAddresses, err := getWalletAddresses(workCtx, h.alephiumClient, wallet.WalletName, log)
+		if err != nil {
+			h.log.WithError(err).Debugf("Got an error calling wallet addresses")
+			return err
+		}
+		for _, addressInfo := range walletAddresses.Addresses {
+			publicKeys[addressInfo.Address] = addressInfo.PublicKey
+		}
+	}
 
-			txStatusReq := h.alephiumClient.TransactionsApi.GetTransactionsStatus(ctx)
-			txStatus, _, err := txStatusReq.TxId(tx.TxId).ToGroup(tx.FromGroup).ToGroup(tx.ToGroup).Execute()
+	records := make([]*transferRecord, 0, len(walletBalances.Balances))
+	walletReserveLeft := settings.keepReserve
+	for _, addressBalance := range walletBalances.Balances {
+		if ctx.Err() != nil {
+			h.log.Infof("Shutdown requested, not transferring from the remaining addresses")
+			break
+		}
+		availableBalance, ok := getAvailableBalance(addressBalance)
+		if !ok {
+			h.log.Warnf("Balance of address %s can't be parsed, skipping it", addressBalance.Address)
+			continue
+		}
+		transferableBalance := availableBalance.Subtract(settings.reserveOf(availableBalance, &walletReserveLeft))
+		if transferableBalance.Cmp(settings.transferMinAmount) < 0 {
+			h.log.Debugf("Transferable balance %s of address %s is below the min amount %s, skipping it",
+				transferableBalance.PrettyString(), addressBalance.Address, settings.transferMinAmount.PrettyString())
+			h.metrics.transferDecision.With(prometheus.Labels{"address": addressBalance.Address, "decision": "skipped"}).Inc()
+			continue
+		}
+		h.log.Infof("Transferable balance %s of address %s is above the min amount %s, transferring it",
+			transferableBalance.PrettyString(), addressBalance.Address, settings.transferMinAmount.PrettyString())
+		if h.dryRun {
+			h.metrics.transferDecision.With(prometheus.Labels{"address": addressBalance.Address, "decision": "dry_run"}).Inc()
+			err = h.dryRunAddress(workCtx, settings, addressBalance.Address, publicKeys[addressBalance.Address],
+				transferableBalance, log)
 			if err != nil {
-				h.log.WithError(err).Debugf("Got an error while getting tx status for tx %s", tx.TxId)
+				h.log.WithError(err).Debugf("Got an error while dry running the transfer of address %s", addressBalance.Address)
 				return err
 			}
-			txConfirmed = txStatus.Confirmed
-			time.Sleep(5 * time.Second)
+			continue
+		}
+		h.metrics.transferDecision.With(prometheus.Labels{"address": addressBalance.Address, "decision": "swept"}).Inc()
+
+		var addressTxs []alephium.TransferResult
+		dust := ALPH{Amount: new(big.Int)}
+		if settings.useSweep() {
+			addressTxs, err = h.sweepAddress(workCtx, settings, wallet.WalletName, addressBalance.Address, log)
+		} else {
+			addressTxs, dust, err = h.transferFromAddress(workCtx, settings, wallet.WalletName, addressBalance.Address,
+				publicKeys[addressBalance.Address], transferableBalance, log)
+		}
+		if err != nil {
+			h.log.WithError(err).Debugf("Got an error while sweeping address %s", addressBalance.Address)
+			return err
+		}
+		for _, tx := range addressTxs {
+			h.log.Infof("New tx %s,%d->%d just submitted", tx.TxId, tx.FromGroup, tx.ToGroup)
+			record := &transferRecord{
+				TxId:        tx.TxId,
+				FromGroup:   tx.FromGroup,
+				ToGroup:     tx.ToGroup,
+				FromAddress: addressBalance.Address,
+				ToAddresses: settings.payout.addresses(),
+				Dust:        dust,
+				SubmittedAt: time.Now().UTC(),
+				Status:      transferStatusSubmitted,
+			}
+			err = h.saveRecord(record)
+			if err != nil {
+				return err
+			}
+			h.notifyTransfer(eventTransferSubmitted, record, "")
+			records = append(records, record)
+		}
+	}
+
+	for i, record := range records {
+		err := h.waitForConfirmation(workCtx, record, log)
+		if err != nil {
+			if workCtx.Err() != nil {
+				h.logPendingTransfers(records[i:])
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPayoutAddressGroups cross-checks the groups of the payout addresses with the node, before
+// transferring anything to them. A node rejecting an address or computing another group is fatal.
+func (h *transferHandler) checkPayoutAddressGroups(ctx context.Context, payout payoutSpec, log *logrus.Entry) error {
+	for _, recipient := range payout {
+		address, err := parsePayoutAddress(recipient.address)
+		if err != nil {
+			return fatal(err)
+		}
+		group, err := h.alephiumClient.GetAddressGroup(ctx, recipient.address)
+		var apiErr *nodeAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+			return fatal(fmt.Errorf("node %s rejects payout address %s: %w", h.alephiumClient.Host(), recipient.address, err))
+		}
+		if err != nil {
+			log.WithError(err).Debugf("Got an error while getting the group of address %s", recipient.address)
+			return err
+		}
+		if int(group.Group) != address.group {
+			return fatal(fmt.Errorf("node %s puts payout address %s in group %d instead of %d", h.alephiumClient.Host(),
+				recipient.address, group.Group, address.group))
+		}
+	}
+	h.log.Infof("Payout addresses %v checked with node %s", payout.addresses(), h.alephiumClient.Host())
+	return nil
+}
+
+// resumePendingTransfers waits for the confirmation of the transfers the ledger knows
+// as submitted, typically because the process stopped before they got confirmed.
+func (h *transferHandler) resumePendingTransfers(ctx context.Context, log *logrus.Entry) error {
+	if h.ledger == nil {
+		return nil
+	}
+	pending, err := h.ledger.pending()
+	if err != nil {
+		h.log.WithError(err).Debugf("Got an error while listing pending transfers of the ledger")
+		return err
+	}
+	for i := range pending {
+		record := &pending[i]
+		h.log.Infof("Resuming confirmation of tx %s,%d->%d submitted at %s", record.TxId, record.FromGroup,
+			record.ToGroup, record.SubmittedAt)
+		err := h.waitForConfirmation(ctx, record, log)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForConfirmation polls the status of the tx until it gets included in a block,
+// then accounts it and updates the ledger.
+func (h *transferHandler) waitForConfirmation(ctx context.Context, record *transferRecord, log *logrus.Entry) error {
+	var txConfirmed *alephium.Confirmed
+	for txConfirmed == nil {
+
+		txStatus, err := getTransactionStatus(ctx, h.alephiumClient, record.TxId, record.FromGroup, record.ToGroup, log)
+		if err != nil {
+			return err
+		}
+		if txStatus.TxNotFound != nil {
+			h.log.Warnf("Tx %s,%d->%d is not known by the node, marking it as failed", record.TxId,
+				record.FromGroup, record.ToGroup)
+			record.Status = transferStatusFailed
+			h.notifyTransfer(eventTransferFailed, record, "tx not known by the node")
+			return h.saveRecord(record)
+		}
+		txConfirmed = txStatus.Confirmed
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.confirmationPoll):
+		}
+	}
+	h.log.Infof("New tx %s,%d->%d is now included in block %s!", record.TxId, record.FromGroup, record.ToGroup,
+		txConfirmed.BlockHash)
+	err := h.accountTx(ctx, record, txConfirmed.BlockHash, log)
+	if err != nil {
+		h.log.WithError(err).Debugf("Got an error while accounting tx %s", record.TxId)
+		return err
+	}
+	h.summarizer.addTransfer(record)
+	h.notifyTransfer(eventTransferConfirmed, record, "")
+	return h.saveRecord(record)
+}
+
+func (h *transferHandler) notifyTransfer(eventType eventType, record *transferRecord, reason string) {
+	h.notifier.notify(event{Type: eventType, Endpoint: h.alephiumClient.Host(), Wallet: h.walletName,
+		Transfer: record, Error: reason})
+}
+
+// logPendingTransfers reports the txs which didn't get confirmed before the end of the shutdown grace period.
+func (h *transferHandler) logPendingTransfers(records []*transferRecord) {
+	txIds := make([]string, 0, len(records))
+	for _, record := range records {
+		txIds = append(txIds, record.TxId)
+	}
+	if h.ledger != nil {
+		h.log.Warnf("Txs %v are still pending at shutdown, their confirmation will be resumed at the next start", txIds)
+	} else {
+		h.log.Warnf("Txs %v are still pending at shutdown and won't be accounted, set LEDGER_PATH to resume them "+
+			"at the next start", txIds)
+	}
+}
+
+func (h *transferHandler) saveRecord(record *transferRecord) error {
+	if h.ledger == nil {
+		return nil
+	}
+	err := h.ledger.save(*record)
+	if err != nil {
+		h.log.WithError(err).Debugf("Got an error while saving tx %s in the ledger", record.TxId)
+		return err
+	}
+	return nil
+}
+
+// sweepAddress makes the given address the active one of the wallet and sweeps it to the transfer address.
+func (h *transferHandler) sweepAddress(ctx context.Context, settings *transferSettings, walletName string,
+	address string, log *logrus.Entry) ([]alephium.TransferResult, error) {
+
+	err := changeActiveAddress(ctx, h.alephiumClient, walletName, address, log)
+	if err != nil {
+		return nil, err
+	}
+
+	sweep := alephium.NewSweep(settings.payout[0].address)
+	return sweepActiveAddress(ctx, h.alephiumClient, walletName, *sweep, log)
+}
+
+// graceContext returns a context which isn't done when ctx is, but grace after it, so that in-flight
+// work can complete on shutdown.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		select {
+		case <-graceCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		select {
+		case <-graceCtx.Done():
+		case <-time.After(grace):
+			cancel()
+		}
+	}()
+	return graceCtx, cancel
+}
+
+// useSweep tells whether the addresses can simply be swept, i.e. nothing is kept in the wallet
+// and everything goes to a single address.
+func (s *transferSettings) useSweep() bool {
+	return len(s.payout) == 1 && s.keepReserve.Amount.Sign() == 0
+}
+
+// reserveOf returns the part of the available balance of an address to keep in the wallet.
+// With a per wallet reserve, the addresses keep their balance until the reserve is reached.
+func (s *transferSettings) reserveOf(availableBalance ALPH, walletReserveLeft *ALPH) ALPH {
+	if s.keepReserveScope == keepReservePerAddress {
+		return s.keepReserve
+	}
+	reserve := *walletReserveLeft
+	if reserve.Cmp(availableBalance) > 0 {
+		reserve = availableBalance
+	}
+	*walletReserveLeft = walletReserveLeft.Subtract(reserve)
+	return reserve
+}
+
+// transferFromAddress makes the given address the active one of the wallet and transfers the given
+// amount, minus the fee, to the payout recipients in a single multi-outputs tx.
+// The fee is found out by estimateTransfer, and the gas of the tx it built with the final amounts
+// is then enforced on the submitted one so that exactly amount leaves the address.
+func (h *transferHandler) transferFromAddress(ctx context.Context, settings *transferSettings, walletName string,
+	address string, publicKey string, amount ALPH, log *logrus.Entry) ([]alephium.TransferResult, ALPH, error) {
+
+	if amount.Cmp(provisionalFee) <= 0 {
+		return nil, ALPH{}, fmt.Errorf("transferable balance %s of address %s doesn't cover the fee",
+			amount.PrettyString(), address)
+	}
+
+	err := changeActiveAddress(ctx, h.alephiumClient, walletName, address, log)
+	if err != nil {
+		return nil, ALPH{}, err
+	}
+
+	builtTx, fee, err := h.estimateTransfer(ctx, settings, publicKey, amount, log)
+	if err != nil {
+		return nil, ALPH{}, err
+	}
+
+	shares, dust := settings.payout.split(amount.Subtract(fee))
+	transfer := alephium.NewTransfer(settings.payoutDestinations(shares))
+	transfer.SetGasAmount(builtTx.GasAmount)
+	transfer.SetGasPrice(builtTx.GasPrice)
+	tx, err := walletTransfer(ctx, h.alephiumClient, walletName, *transfer, log)
+	if err != nil {
+		return nil, ALPH{}, err
+	}
+	h.log.Infof("Transferred %s of address %s to %s, for a fee of %s and a rounding dust of %s",
+		amount.Subtract(fee).PrettyString(), address, settings.payout, fee.PrettyString(), dust.String())
+	return []alephium.TransferResult{*tx}, dust, nil
+}
+
+// maxTransferEstimates is the number of times a tx is built before giving up on finding out its fee.
+const maxTransferEstimates = 3
+
+// estimateTransfer builds a tx transferring amount, minus a provisional fee, to the payout recipients
+// to find out the gas and the fee the actual tx will need. As the gas depends on the outputs being
+// transferred, the tx is built again with amount minus the fee found out, until it needs the same gas,
+// so that the returned tx is the one to submit.
+func (h *transferHandler) estimateTransfer(ctx context.Context, settings *transferSettings, publicKey string,
+	amount ALPH, log *logrus.Entry) (*alephium.BuildTransactionResult, ALPH, error) {
+
+	fee := provisionalFee
+	for i := 0; i < maxTransferEstimates; i++ {
+		shares, _ := settings.payout.split(amount.Subtract(fee))
+		buildTx := alephium.NewBuildTransaction(publicKey, settings.payoutDestinations(shares))
+		builtTx, err := buildTransaction(ctx, h.alephiumClient, *buildTx, log)
+		if err != nil {
+			return nil, ALPH{}, err
+		}
+		builtFee, ok := getFee(builtTx.GasAmount, builtTx.GasPrice)
+		if !ok {
+			return nil, ALPH{}, fmt.Errorf("gas price %s of the built tx is not a valid amount", builtTx.GasPrice)
+		}
+		if builtFee.Cmp(fee) == 0 {
+			return builtTx, fee, nil
+		}
+		if amount.Cmp(builtFee) <= 0 {
+			return nil, ALPH{}, fmt.Errorf("transferable balance %s doesn't cover the fee %s",
+				amount.PrettyString(), builtFee.PrettyString())
+		}
+		fee = builtFee
+	}
+	return nil, ALPH{}, fmt.Errorf("fee of the tx transferring %s still changes after %d estimates",
+		amount.PrettyString(), maxTransferEstimates)
+}
+
+// dryRunAddress builds the tx(s) the transfer of the address would submit, without signing nor
+// submitting them, and logs and accounts them in the dry run metrics.
+func (h *transferHandler) dryRunAddress(ctx context.Context, settings *transferSettings, address string,
+	publicKey string, amount ALPH, log *logrus.Entry) error {
+
+	if settings.useSweep() {
+		buildSweep := alephium.NewBuildSweepAddressTransactions(publicKey, settings.payout[0].address)
+		builtSweep, err := buildSweepAddressTransactions(ctx, h.alephiumClient, *buildSweep, log)
+		if err != nil {
+			return err
+		}
+		fee := ALPH{Amount: new(big.Int)}
+		for _, unsignedTx := range builtSweep.UnsignedTxs {
+			txFee, ok := getFee(unsignedTx.GasAmount, unsignedTx.GasPrice)
+			if !ok {
+				return fmt.Errorf("gas price %s of the built tx is not a valid amount", unsignedTx.GasPrice)
+			}
+			h.log.Infof("[DRY RUN] Would submit tx %s,%d->%d sweeping address %s to %s, for a fee of %s",
+				unsignedTx.TxId, builtSweep.FromGroup, builtSweep.ToGroup, address, settings.payout[0].address, txFee.PrettyString())
+			fee = fee.Add(txFee)
 		}
-		h.log.Infof("New tx %s,%d->%d is now included in block %s!", tx.TxId, tx.FromGroup, tx.ToGroup, txConfirmed.BlockHash)
-		// TODO: get block to find out the exact amount transferred to update counter consistently
+		h.accountDryRun(builtSweep.FromGroup, builtSweep.ToGroup, len(builtSweep.UnsignedTxs), amount.Subtract(fee), fee)
+		return nil
 	}
 
+	if amount.Cmp(provisionalFee) <= 0 {
+		return fmt.Errorf("transferable balance %s of address %s doesn't cover the fee", amount.PrettyString(), address)
+	}
+	builtTx, fee, err := h.estimateTransfer(ctx, settings, publicKey, amount, log)
+	if err != nil {
+		return err
+	}
+	shares, dust := settings.payout.split(amount.Subtract(fee))
+	for i, share := range shares {
+		h.log.Infof("[DRY RUN] Would transfer %s from address %s to %s", share.PrettyString(), address, settings.payout[i].address)
+	}
+	h.log.Infof("[DRY RUN] Would submit a tx like %s,%d->%d, for a fee of %s and a rounding dust of %s",
+		builtTx.TxId, builtTx.FromGroup, builtTx.ToGroup, fee.PrettyString(), dust.String())
+	h.accountDryRun(builtTx.FromGroup, builtTx.ToGroup, 1, amount.Subtract(fee), fee)
 	return nil
 }
+
+func (h *transferHandler) accountDryRun(fromGroup int32, toGroup int32, txs int, amount ALPH, fee ALPH) {
+	groupLabels := prometheus.Labels{"from_group": fmt.Sprint(fromGroup), "to_group": fmt.Sprint(toGroup)}
+	h.metrics.dryRunTxs.With(groupLabels).Add(float64(txs))
+	h.metrics.dryRunAmount.With(groupLabels).Add(amount.FloatALPH())
+	h.metrics.dryRunFees.With(groupLabels).Add(fee.FloatALPH())
+}
+
+func (s *transferSettings) payoutDestinations(shares []ALPH) []alephium.Destination {
+	destinations := make([]alephium.Destination, 0, len(shares))
+	for i, share := range shares {
+		destinations = append(destinations, *alephium.NewDestination(s.payout[i].address, share.String()))
+	}
+	return destinations
+}
+
+// getAvailableBalance returns the balance of the address minus the locked part,
+// i.e. the coinbase outputs which can't be spent yet.
+func getAvailableBalance(addressBalance alephium.AddressBalance) (ALPH, bool) {
+	balance, ok := ALPHFromCoinString(addressBalance.Balance)
+	if !ok {
+		return ALPH{}, false
+	}
+	lockedBalance, ok := ALPHFromCoinString(addressBalance.LockedBalance)
+	if !ok {
+		return ALPH{}, false
+	}
+	return balance.Subtract(lockedBalance), true
+}
+
+// accountTx reads the block including the tx to find out the exact amount transferred
+// and the fee paid, and updates the counters and the record accordingly.
+func (h *transferHandler) accountTx(ctx context.Context, record *transferRecord, blockHash string, log *logrus.Entry) error {
+	block, err := getBlock(ctx, h.alephiumClient, blockHash, log)
+	if err != nil {
+		return err
+	}
+	for _, blockTx := range block.Transactions {
+		if blockTx.Unsigned.TxId != record.TxId {
+			continue
+		}
+		amount, fee, ok := getTxAmountAndFee(blockTx, record.ToAddresses)
+		if !ok {
+			return fmt.Errorf("amounts of tx %s in block %s can't be parsed", record.TxId, blockHash)
+		}
+		h.log.Infof("Tx %s,%d->%d transferred %s for a fee of %s", record.TxId, record.FromGroup, record.ToGroup,
+			amount.PrettyString(), fee.PrettyString())
+		groupLabels := prometheus.Labels{"from_group": fmt.Sprint(record.FromGroup), "to_group": fmt.Sprint(record.ToGroup)}
+		h.metrics.txAmount.With(groupLabels).Add(amount.FloatALPH())
+		h.metrics.txFees.With(groupLabels).Add(fee.FloatALPH())
+
+		record.Amount = amount
+		record.Fee = fee
+		record.BlockHash = blockHash
+		record.ConfirmedAt = time.UnixMilli(block.Timestamp).UTC()
+		record.Status = transferStatusConfirmed
+		return nil
+	}
+	return fmt.Errorf("tx %s not found in block %s", record.TxId, blockHash)
+}
+
+// getTxAmountAndFee returns the amount sent to the given addresses by the tx, and the fee paid for it,
+// computed as gas amount times gas price.
+func getTxAmountAndFee(tx alephium.Transaction, toAddresses []string) (ALPH, ALPH, bool) {
+	amount := ALPH{Amount: new(big.Int)}
+	for _, output := range tx.Unsigned.FixedOutputs {
+		if !contains(toAddresses, output.Address) {
+			continue
+		}
+		outputAmount, ok := ALPHFromCoinString(output.AttoAlphAmount)
+		if !ok {
+			return ALPH{}, ALPH{}, false
+		}
+		amount = amount.Add(outputAmount)
+	}
+	fee, ok := getFee(tx.Unsigned.GasAmount, tx.Unsigned.GasPrice)
+	if !ok {
+		return ALPH{}, ALPH{}, false
+	}
+	return amount, fee, true
+}
+
+// getFee returns the fee of a tx, i.e. gas amount times gas price.
+func getFee(gasAmount int32, gasPrice string) (ALPH, bool) {
+	price, ok := ALPHFromCoinString(gasPrice)
+	if !ok {
+		return ALPH{}, false
+	}
+	return price.Multiply(int64(gasAmount)), true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
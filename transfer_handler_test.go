@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	alephium "github.com/alephium/go-sdk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetAvailableBalance(t *testing.T) {
+	available, ok := getAvailableBalance(alephium.AddressBalance{
+		Address:       "1abc",
+		Balance:       "25000000000000000000",
+		LockedBalance: "5000000000000000000",
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "20000000000000000000", available.String())
+
+	_, ok = getAvailableBalance(alephium.AddressBalance{Address: "1abc", Balance: "NaN", LockedBalance: "0"})
+	assert.False(t, ok)
+}
+
+func TestGetTxAmountAndFee(t *testing.T) {
+	tx := alephium.Transaction{
+		Unsigned: alephium.UnsignedTx{
+			TxId:      "tx1",
+			GasAmount: 20000,
+			GasPrice:  "100000000000",
+			FixedOutputs: []alephium.FixedAssetOutput{
+				{Address: "1dest", AttoAlphAmount: "10000000000000000000"},
+				{Address: "1dest", AttoAlphAmount: "2500000000000000000"},
+				{Address: "1other", AttoAlphAmount: "1000000000000000000"},
+			},
+		},
+	}
+	amount, fee, ok := getTxAmountAndFee(tx, []string{"1dest"})
+	assert.True(t, ok)
+	assert.Equal(t, "12500000000000000000", amount.String())
+	assert.Equal(t, "2000000000000000", fee.String())
+}
+
+func TestReserveOf(t *testing.T) {
+	reserve, _ := ALPHFromALPHString("5")
+	three, _ := ALPHFromALPHString("3")
+	ten, _ := ALPHFromALPHString("10")
+
+	s := &transferSettings{keepReserve: reserve, keepReserveScope: keepReservePerAddress}
+	left := reserve
+	assert.Equal(t, 0, s.reserveOf(three, &left).Cmp(reserve))
+	assert.Equal(t, 0, s.reserveOf(ten, &left).Cmp(reserve))
+
+	s.keepReserveScope = keepReservePerWallet
+	assert.Equal(t, 0, s.reserveOf(three, &left).Cmp(three))
+	assert.Equal(t, "2000000000000000000", s.reserveOf(ten, &left).String())
+	assert.Equal(t, "0", s.reserveOf(ten, &left).String())
+}
+
+func newTestTransferHandler(t *testing.T, node *fakeNode, payout string, dryRun bool) *transferHandler {
+	spec, err := parsePayoutSpec(payout)
+	assert.Nil(t, err)
+	schedule, err := newTransferSchedule("", "UTC", time.Hour)
+	assert.Nil(t, err)
+	handler, err := newTransferHandler(node.client(), "mining", testWalletSecrets("secret"), spec, "10000000000000000000",
+		"0", keepReservePerAddress, schedule, false, false, dryRun, false, time.Second, newTestMetrics(), nil, nil, nil, newTestLogger())
+	assert.Nil(t, err)
+	handler.confirmationPoll = time.Millisecond
+	return handler
+}
+
+func TestTransferSweep(t *testing.T) {
+	node := newFakeNode(t)
+	node.confirmAfter = 2
+	node.addWallet("mining", "secret", "25", "5", "12.5")
+	ledger, err := newTransferLedger(filepath.Join(t.TempDir(), "ledger.db"), time.UTC)
+	assert.Nil(t, err)
+	defer ledger.Close()
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	handler.ledger = ledger
+
+	err = handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tx-1", "tx-2"}, node.submitted)
+
+	records, err := ledger.list()
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "mining-address-0", records[0].FromAddress)
+	assert.Equal(t, transferStatusConfirmed, records[0].Status)
+	assert.Equal(t, "24998000000000000000", records[0].Amount.String())
+	assert.Equal(t, "2000000000000000", records[0].Fee.String())
+	assert.Equal(t, "block-tx-1", records[0].BlockHash)
+	assert.Equal(t, "mining-address-2", records[1].FromAddress)
+	assert.Equal(t, "5000000000000000000", node.wallets["mining"].balances["mining-address-1"].String())
+}
+
+func TestTransferSplit(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "20")
+	handler := newTestTransferHandler(t, node, "1destA:70,1destB:30", false)
+
+	err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tx-1"}, node.submitted)
+
+	outputs := node.txs["tx-1"].tx.Unsigned.FixedOutputs
+	assert.Len(t, outputs, 2)
+	assert.Equal(t, "1destA", outputs[0].Address)
+	assert.Equal(t, "13998600000000000000", outputs[0].AttoAlphAmount)
+	assert.Equal(t, "1destB", outputs[1].Address)
+	assert.Equal(t, "5999400000000000000", outputs[1].AttoAlphAmount)
+	// The submitted tx is the last one estimated
+	built := node.built[len(node.built)-1]
+	assert.Len(t, built, 2)
+	assert.Equal(t, outputs[0].AttoAlphAmount, built[0].AttoAlphAmount)
+	assert.Equal(t, outputs[1].AttoAlphAmount, built[1].AttoAlphAmount)
+}
+
+func TestTransferSplitGasChange(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "20")
+	// The gas needed changes once the tx is built with the actual fee instead of the provisional one
+	node.gasAmounts = []int32{20000, 30000, 30000}
+	handler := newTestTransferHandler(t, node, "1destA:70,1destB:30", false)
+
+	err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	assert.Len(t, node.built, 3)
+	tx := node.txs["tx-1"].tx
+	assert.Equal(t, int32(30000), tx.Unsigned.GasAmount)
+	assert.Equal(t, "13997900000000000000", tx.Unsigned.FixedOutputs[0].AttoAlphAmount)
+	assert.Equal(t, "5999100000000000000", tx.Unsigned.FixedOutputs[1].AttoAlphAmount)
+}
+
+func TestTransferMinAmount(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25", "9.99", "0", "10")
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	decisions := func(address string, decision string) float64 {
+		return testutil.ToFloat64(handler.metrics.transferDecision.With(
+			prometheus.Labels{"address": address, "decision": decision}))
+	}
+	expected := []struct {
+		address  string
+		decision string
+	}{
+		{"mining-address-0", "swept"},
+		{"mining-address-1", "skipped"},
+		{"mining-address-2", "skipped"},
+		{"mining-address-3", "swept"},
+	}
+	before := make([]float64, len(expected))
+	for i, e := range expected {
+		before[i] = decisions(e.address, e.decision)
+	}
+
+	err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tx-1", "tx-2"}, node.submitted)
+	assert.Equal(t, int32(0), node.txs["tx-1"].result.FromGroup)
+	assert.Equal(t, int32(3), node.txs["tx-2"].result.FromGroup)
+	for i, e := range expected {
+		assert.Equal(t, 1.0, decisions(e.address, e.decision)-before[i], e.address)
+	}
+	assert.Equal(t, "9990000000000000000", node.wallets["mining"].balances["mining-address-1"].String())
+}
+
+func TestTransferDryRun(t *testing.T) {
+	for _, payout := range []string{"1dest", "1destA:70,1destB:30"} {
+		t.Run(payout, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.addWallet("mining", "secret", "25", "0", "12.5")
+			handler := newTestTransferHandler(t, node, payout, true)
+			dryRunTxs := func(fromGroup string) float64 {
+				return testutil.ToFloat64(handler.metrics.dryRunTxs.With(
+					prometheus.Labels{"from_group": fromGroup, "to_group": "0"}))
+			}
+			before := []float64{dryRunTxs("0"), dryRunTxs("2")}
+
+			err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+			assert.Nil(t, err)
+			assert.Equal(t, 0, node.signed)
+			assert.Empty(t, node.submitted)
+			assert.Equal(t, 1.0, dryRunTxs("0")-before[0])
+			assert.Equal(t, 1.0, dryRunTxs("2")-before[1])
+			assert.Equal(t, "25000000000000000000", node.wallets["mining"].balances["mining-address-0"].String())
+			assert.Equal(t, "12500000000000000000", node.wallets["mining"].balances["mining-address-2"].String())
+		})
+	}
+}
+
+func TestTransferShutdownGrace(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		grace        time.Duration
+		confirmAfter int
+		status       transferStatus
+	}{
+		{name: "confirmed within grace", grace: time.Second, confirmAfter: 20, status: transferStatusConfirmed},
+		{name: "pending after grace", grace: 20 * time.Millisecond, confirmAfter: 1000000, status: transferStatusSubmitted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.confirmAfter = tc.confirmAfter
+			node.addWallet("mining", "secret", "25", "25")
+			ledger, err := newTransferLedger(filepath.Join(t.TempDir(), "ledger.db"), time.UTC)
+			assert.Nil(t, err)
+			defer ledger.Close()
+			handler := newTestTransferHandler(t, node, "1dest", false)
+			handler.ledger = ledger
+			handler.shutdownGrace = tc.grace
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- handler.transfer(ctx, logrus.NewEntry(handler.log)) }()
+			assert.Eventually(t, func() bool {
+				node.mu.Lock()
+				defer node.mu.Unlock()
+				return len(node.submitted) == 2
+			}, time.Second, time.Millisecond)
+			cancel()
+			err = <-done
+
+			records, listErr := ledger.list()
+			assert.Nil(t, listErr)
+			assert.Len(t, records, 2)
+			for _, record := range records {
+				assert.Equal(t, tc.status, record.Status)
+			}
+			if tc.status == transferStatusConfirmed {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestTransferImmediateOnFirstStart(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25")
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	handler.immediate = true
+	handler.pause()
+	handle := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- handler.handle(ctx, logrus.NewEntry(handler.log)) }()
+		assert.Eventually(t, func() bool { return !handler.getNextRun().IsZero() }, time.Second, time.Millisecond)
+		cancel()
+		assert.Nil(t, <-done)
+		handler.setNextRun(time.Time{})
+	}
+
+	// A paused handler skips the immediate run, as it skips the planned ones
+	handle()
+	assert.Empty(t, node.submitted)
+
+	// A restart by the supervisor doesn't run it again
+	handler.resume()
+	handle()
+	assert.Empty(t, node.submitted)
+}
+
+func TestTransferReconfigure(t *testing.T) {
+	node := newFakeNode(t)
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.handle(ctx, logrus.NewEntry(handler.log))
+	assert.Eventually(t, func() bool { return !handler.getNextRun().IsZero() }, time.Second, 10*time.Millisecond)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), handler.getNextRun(), time.Minute)
+
+	payout, err := parsePayoutSpec("1dest:60,2dest:40")
+	assert.Nil(t, err)
+	schedule, err := newTransferSchedule("", "UTC", 3*time.Hour)
+	assert.Nil(t, err)
+	assert.NotNil(t, handler.reconfigure(payout, "abc", "0", keepReservePerAddress, schedule))
+	assert.Nil(t, handler.reconfigure(payout, "5000000000000000000", "1", keepReservePerWallet, schedule))
+
+	// The next run is planned again with the new schedule
+	assert.Eventually(t, func() bool {
+		return handler.getNextRun().After(time.Now().Add(2 * time.Hour))
+	}, time.Second, 10*time.Millisecond)
+	handler.settingsLock.RLock()
+	defer handler.settingsLock.RUnlock()
+	assert.Equal(t, payout, handler.settings.payout)
+	assert.Equal(t, "5ALPH", handler.settings.transferMinAmount.PrettyString())
+	assert.Equal(t, keepReservePerWallet, handler.settings.keepReserveScope)
+}
+
+func TestTransferReconfigureDuringRun(t *testing.T) {
+	node := newFakeNode(t)
+	node.confirmAfter = 1000000
+	node.addWallet("mining", "secret", "25")
+	handler := newTestTransferHandler(t, node, "1dest", false)
+	handler.shutdownGrace = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- handler.transfer(ctx, logrus.NewEntry(handler.log)) }()
+	assert.Eventually(t, func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return len(node.submitted) == 1
+	}, time.Second, time.Millisecond)
+
+	// The run waiting for its tx to be confirmed doesn't hold the new settings up
+	payout, err := parsePayoutSpec("2dest")
+	assert.Nil(t, err)
+	schedule, err := newTransferSchedule("", "UTC", time.Hour)
+	assert.Nil(t, err)
+	reconfigured := make(chan error)
+	go func() {
+		reconfigured <- handler.reconfigure(payout, "5000000000000000000", "0", keepReservePerAddress, schedule)
+	}()
+	select {
+	case err := <-reconfigured:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("reconfigure is blocked by the transfer run")
+	}
+	assert.True(t, handler.isRunning())
+	assert.Equal(t, "1dest", node.txs["tx-1"].tx.Unsigned.FixedOutputs[0].Address)
+
+	cancel()
+	assert.NotNil(t, <-done)
+}
+
+func TestTransferChecksPayoutGroups(t *testing.T) {
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret", "25")
+	handler := newTestTransferHandler(t, node, testAddress1, false)
+	handler.checkPayoutGroups = true
+
+	node.groups[testAddress1] = 0
+	err := handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Equal(t, errorClassFatal, classifyError(err))
+	assert.Empty(t, node.submitted)
+
+	delete(node.groups, testAddress1)
+	err = handler.transfer(context.Background(), logrus.NewEntry(handler.log))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tx-1"}, node.submitted)
+	assert.True(t, handler.payoutChecked)
+}
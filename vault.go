@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vaultRequestTimeout = 10 * time.Second
+	// vaultMinRenewDelay keeps a token with a very short ttl from being renewed in a busy loop.
+	vaultMinRenewDelay = time.Second
+)
+
+// vaultError is an error answered by vault, along with its HTTP status code.
+type vaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("vault answered %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.Join(e.Errors, ", "))
+}
+
+// vaultAuth is the auth part of the vault responses to a login or a token renewal.
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// vaultClient reads secrets from the KV v2 secrets engine of a HashiCorp Vault, or any server with the
+// same API, authenticated with a token, i.e. written by a vault agent, or with AppRole. The token is
+// kept valid by renewToken.
+type vaultClient struct {
+	address     string
+	namespace   string
+	kvMount     string
+	token       *secret
+	roleID      string
+	secretID    *secret
+	approlePath string
+	httpClient  *http.Client
+	log         *logrus.Logger
+	// loginToken is the token got with AppRole, empty until logged in.
+	loginToken string
+	tokenLock  *sync.Mutex
+}
+
+// newVaultClient returns the client of the vault at address, authenticated with token if roleID is
+// empty, with AppRole otherwise.
+func newVaultClient(address string, namespace string, kvMount string, token *secret, roleID string,
+	secretID *secret, approlePath string, log *logrus.Logger) (*vaultClient, error) {
+
+	err := parseHTTPURL("vault address", address)
+	if err != nil {
+		return nil, err
+	}
+	return &vaultClient{
+		address:     strings.TrimSuffix(address, "/"),
+		namespace:   namespace,
+		kvMount:     strings.Trim(kvMount, "/"),
+		token:       token,
+		roleID:      roleID,
+		secretID:    secretID,
+		approlePath: strings.Trim(approlePath, "/"),
+		httpClient:  &http.Client{Timeout: vaultRequestTimeout},
+		log:         log,
+		tokenLock:   &sync.Mutex{},
+	}, nil
+}
+
+func (v *vaultClient) usesAppRole() bool {
+	return v.roleID != ""
+}
+
+// currentToken returns the token to authenticate with, logging in with AppRole if not done yet. The
+// token file, if any, is read each time, so that a token rotated by a vault agent is used right away.
+func (v *vaultClient) currentToken(ctx context.Context) (string, error) {
+	if !v.usesAppRole() {
+		return v.token.revealString()
+	}
+	v.tokenLock.Lock()
+	defer v.tokenLock.Unlock()
+	if v.loginToken == "" {
+		err := v.login(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	return v.loginToken, nil
+}
+
+// login gets a new token with AppRole, the token lock being held.
+func (v *vaultClient) login(ctx context.Context) error {
+	secretID, err := v.secretID.revealString()
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	err = v.do(ctx, http.MethodPost, "/v1/auth/"+v.approlePath+"/login", "",
+		map[string]string{"role_id": v.roleID, "secret_id": secretID}, &response)
+	if err != nil {
+		return fmt.Errorf("can't log in to vault with AppRole: %w", err)
+	}
+	if response.Auth.ClientToken == "" {
+		return fmt.Errorf("can't log in to vault with AppRole: no token returned")
+	}
+	v.loginToken = response.Auth.ClientToken
+	v.log.Debugf("Logged in to vault %s with AppRole, token valid for %ds", v.address, response.Auth.LeaseDuration)
+	return nil
+}
+
+// request sends an authenticated request to vault. With AppRole, a token refused because expired or
+// revoked is replaced by a new login and the request sent again.
+func (v *vaultClient) request(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	token, err := v.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = v.do(ctx, method, path, token, body, result)
+	var vaultErr *vaultError
+	if !errors.As(err, &vaultErr) || vaultErr.StatusCode != http.StatusForbidden || !v.usesAppRole() {
+		return err
+	}
+
+	v.log.Debugf("Vault token refused, logging in again with AppRole")
+	v.tokenLock.Lock()
+	err = nil
+	if v.loginToken == token {
+		err = v.login(ctx)
+	}
+	token = v.loginToken
+	v.tokenLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return v.do(ctx, method, path, token, body, result)
+}
+
+func (v *vaultClient) do(ctx context.Context, method string, path string, token string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, v.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if v.namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := v.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		vaultErr := &vaultError{StatusCode: response.StatusCode}
+		var errorsResponse struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(response.Body).Decode(&errorsResponse) == nil {
+			vaultErr.Errors = errorsResponse.Errors
+		}
+		return vaultErr
+	}
+	if result == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// readSecret returns the latest version of the KV v2 secret at path, empty if there is none.
+func (v *vaultClient) readSecret(ctx context.Context, path string) (map[string]string, error) {
+	var response struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err := v.request(ctx, http.MethodGet, "/v1/"+v.kvMount+"/data/"+strings.Trim(path, "/"), nil, &response)
+	var vaultErr *vaultError
+	if errors.As(err, &vaultErr) && vaultErr.StatusCode == http.StatusNotFound {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read secret %s from vault: %w", path, err)
+	}
+	return response.Data.Data, nil
+}
+
+// lookupToken returns the remaining time to live of the current token, zero if it doesn't expire, and
+// whether it can be renewed.
+func (v *vaultClient) lookupToken(ctx context.Context) (time.Duration, bool, error) {
+	var response struct {
+		Data struct {
+			TTL       int64 `json:"ttl"`
+			Renewable bool  `json:"renewable"`
+		} `json:"data"`
+	}
+	err := v.request(ctx, http.MethodGet, "/v1/auth/token/lookup-self", nil, &response)
+	if err != nil {
+		return 0, false, fmt.Errorf("can't look up the vault token: %w", err)
+	}
+	return time.Duration(response.Data.TTL) * time.Second, response.Data.Renewable, nil
+}
+
+// renewToken keeps the token valid until ctx is done, renewing its lease when two thirds of its time
+// to live have elapsed. A token which can't be renewed anymore, i.e. having reached its max ttl, is
+// replaced by a new AppRole login, while a given token is expected to be replaced in its file.
+func (v *vaultClient) renewToken(ctx context.Context) error {
+	for {
+		ttl, renewable, err := v.lookupToken(ctx)
+		if err != nil {
+			return err
+		}
+		if ttl == 0 {
+			v.log.Infof("Vault token doesn't expire, no need to renew it.")
+			<-ctx.Done()
+			return nil
+		}
+		if !renewable && !v.usesAppRole() {
+			v.log.Warnf("Vault token can't be renewed and expires in %s, make sure it's replaced in time.", ttl)
+		}
+
+		delay := ttl * 2 / 3
+		if delay < vaultMinRenewDelay {
+			delay = vaultMinRenewDelay
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		if renewable {
+			var response struct {
+				Auth vaultAuth `json:"auth"`
+			}
+			err = v.request(ctx, http.MethodPost, "/v1/auth/token/renew-self", nil, &response)
+			if err == nil && time.Duration(response.Auth.LeaseDuration)*time.Second >= delay {
+				v.log.Debugf("Vault token renewed for %ds", response.Auth.LeaseDuration)
+				continue
+			}
+			if err != nil {
+				v.log.WithError(err).Debugf("Got an error while renewing the vault token")
+			}
+		}
+		if v.usesAppRole() {
+			v.tokenLock.Lock()
+			err = v.login(ctx)
+			v.tokenLock.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// vaultSecretProvider gives the wallet secrets kept in the KV v2 secret at path, under the lower case
+// names of their env var, i.e. wallet_password. The secrets missing in vault are the ones of fallback.
+type vaultSecretProvider struct {
+	client   *vaultClient
+	path     string
+	fallback secretProvider
+}
+
+func newVaultSecretProvider(client *vaultClient, path string, fallback secretProvider) *vaultSecretProvider {
+	return &vaultSecretProvider{
+		client:   client,
+		path:     path,
+		fallback: fallback,
+	}
+}
+
+func (p *vaultSecretProvider) secret(ctx context.Context, name string) ([]byte, error) {
+	data, err := p.client.readSecret(ctx, p.path)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[strings.ToLower(name)]
+	if !ok {
+		return p.fallback.secret(ctx, name)
+	}
+	return []byte(value), nil
+}
+
+func (env envConfig) vaultTokenSecret() *secret {
+	return newSecret("VAULT_TOKEN", env.VaultToken, env.VaultTokenFile)
+}
+
+func (env envConfig) vaultSecretIdSecret() *secret {
+	return newSecret("VAULT_SECRET_ID", env.VaultSecretId, env.VaultSecretIdFile)
+}
+
+// validateVault checks the vault settings, if vault is used.
+func (env envConfig) validateVault() error {
+	if env.VaultAddr == "" {
+		return nil
+	}
+	err := parseHTTPURL("VAULT_ADDR", env.VaultAddr)
+	if err != nil {
+		return err
+	}
+	if env.VaultSecretPath == "" {
+		return fmt.Errorf("VAULT_SECRET_PATH is mandatory with VAULT_ADDR")
+	}
+	hasToken := env.VaultToken != "" || env.VaultTokenFile != ""
+	hasSecretId := env.VaultSecretId != "" || env.VaultSecretIdFile != ""
+	if hasToken == (env.VaultRoleId != "") {
+		return fmt.Errorf("either VAULT_TOKEN or VAULT_ROLE_ID is required with VAULT_ADDR")
+	}
+	if env.VaultRoleId != "" && !hasSecretId {
+		return fmt.Errorf("VAULT_SECRET_ID is mandatory with VAULT_ROLE_ID")
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault is an in-process stand-in of the vault API used by vaultClient: AppRole login, token lookup
+// and renewal and KV v2 reads, the tokens expiring after their ttl.
+type fakeVault struct {
+	server   *httptest.Server
+	roleID   string
+	secretID string
+	ttl      time.Duration
+	secrets  map[string]map[string]string
+	tokens   map[string]time.Time
+	logins   int
+	renewals int
+	lock     sync.Mutex
+}
+
+func newFakeVault(t *testing.T, ttl time.Duration) *fakeVault {
+	v := &fakeVault{
+		roleID:   "role",
+		secretID: "secret-id",
+		ttl:      ttl,
+		secrets:  make(map[string]map[string]string),
+		tokens:   make(map[string]time.Time),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/approle/login", v.login)
+	mux.HandleFunc("/v1/auth/token/lookup-self", v.lookupSelf)
+	mux.HandleFunc("/v1/auth/token/renew-self", v.renewSelf)
+	mux.HandleFunc("/v1/secret/data/", v.readSecret)
+	v.server = httptest.NewServer(mux)
+	t.Cleanup(v.server.Close)
+	return v
+}
+
+func (v *fakeVault) issueToken() string {
+	token := fmt.Sprintf("token-%d", len(v.tokens)+1)
+	v.tokens[token] = time.Now().Add(v.ttl)
+	return token
+}
+
+func (v *fakeVault) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (v *fakeVault) writeError(w http.ResponseWriter, status int, message string) {
+	v.writeJSON(w, status, map[string][]string{"errors": {message}})
+}
+
+// authorized checks the token of the request, the lock being held.
+func (v *fakeVault) authorized(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := r.Header.Get("X-Vault-Token")
+	expiry, ok := v.tokens[token]
+	if !ok || time.Now().After(expiry) {
+		v.writeError(w, http.StatusForbidden, "permission denied")
+		return "", false
+	}
+	return token, true
+}
+
+func (v *fakeVault) login(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	var body map[string]string
+	if json.NewDecoder(r.Body).Decode(&body) != nil || body["role_id"] != v.roleID || body["secret_id"] != v.secretID {
+		v.writeError(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+	v.logins++
+	v.writeJSON(w, http.StatusOK, map[string]interface{}{"auth": vaultAuth{ClientToken: v.issueToken(),
+		LeaseDuration: int64(v.ttl / time.Second), Renewable: true}})
+}
+
+func (v *fakeVault) lookupSelf(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	token, ok := v.authorized(w, r)
+	if !ok {
+		return
+	}
+	ttl := time.Until(v.tokens[token]).Round(time.Second)
+	v.writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"ttl": int64(ttl / time.Second), "renewable": true}})
+}
+
+func (v *fakeVault) renewSelf(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	token, ok := v.authorized(w, r)
+	if !ok {
+		return
+	}
+	v.renewals++
+	v.tokens[token] = time.Now().Add(v.ttl)
+	v.writeJSON(w, http.StatusOK, map[string]interface{}{"auth": vaultAuth{ClientToken: token,
+		LeaseDuration: int64(v.ttl / time.Second), Renewable: true}})
+}
+
+func (v *fakeVault) readSecret(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, ok := v.authorized(w, r); !ok {
+		return
+	}
+	data, ok := v.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+	if !ok {
+		v.writeJSON(w, http.StatusNotFound, map[string][]string{"errors": {}})
+		return
+	}
+	v.writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": data,
+		"metadata": map[string]interface{}{"version": 1}}})
+}
+
+// revoke expires all the tokens, as if revoked or past their max ttl.
+func (v *fakeVault) revoke() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for token := range v.tokens {
+		v.tokens[token] = time.Now().Add(-time.Second)
+	}
+}
+
+func (v *fakeVault) counts() (int, int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.logins, v.renewals
+}
+
+func TestVaultSecretProviderToken(t *testing.T) {
+	vault := newFakeVault(t, time.Hour)
+	vault.secrets["mining"] = map[string]string{"wallet_password": "vault-secret"}
+	token := vault.issueToken()
+	client, err := newVaultClient(vault.server.URL, "", "secret", newSecret("VAULT_TOKEN", token, ""), "",
+		nil, "approle", newTestLogger())
+	assert.Nil(t, err)
+
+	provider := newVaultSecretProvider(client, "mining", envConfig{WalletPassword: "env-secret",
+		WalletMnemonicPassphrase: "env-passphrase"}.walletSecrets())
+	ctx := context.Background()
+	password, err := revealSecret(ctx, provider, walletPasswordSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "vault-secret", password)
+	// The secrets missing in vault fall back to the env vars
+	passphrase, err := revealSecret(ctx, provider, walletMnemonicPassphraseSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "env-passphrase", passphrase)
+
+	vault.revoke()
+	_, err = provider.secret(ctx, walletPasswordSecret)
+	assert.NotNil(t, err)
+}
+
+func TestVaultSecretProviderAppRole(t *testing.T) {
+	vault := newFakeVault(t, time.Hour)
+	vault.secrets["mining"] = map[string]string{"wallet_password": "secret"}
+	client, err := newVaultClient(vault.server.URL, "", "secret", nil, vault.roleID,
+		newSecret("VAULT_SECRET_ID", vault.secretID, ""), "approle", newTestLogger())
+	assert.Nil(t, err)
+
+	// The mining wallet is unlocked with the password kept in vault
+	node := newFakeNode(t)
+	node.addWallet("mining", "secret").locked = true
+	handler, err := newMiningHandler(node.client(), "mining", newVaultSecretProvider(client, "mining",
+		envConfig{}.walletSecrets()), false, nil, newTestLogger())
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wallet, err := handler.createAndUnlockWallet(ctx, newTestLogger().WithField("test", t.Name()))
+	assert.Nil(t, err)
+	assert.NotNil(t, wallet)
+	assert.False(t, node.wallets["mining"].locked)
+
+	// An expired token is replaced by a new login
+	vault.revoke()
+	password, err := revealSecret(ctx, newVaultSecretProvider(client, "mining", envConfig{}.walletSecrets()),
+		walletPasswordSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", password)
+	logins, _ := vault.counts()
+	assert.Equal(t, 2, logins)
+
+	client.secretID = newSecret("VAULT_SECRET_ID", "wrong", "")
+	vault.revoke()
+	_, err = client.readSecret(ctx, "mining")
+	assert.NotNil(t, err)
+}
+
+func TestVaultRenewToken(t *testing.T) {
+	vault := newFakeVault(t, 2*time.Second)
+	client, err := newVaultClient(vault.server.URL, "", "secret", nil, vault.roleID,
+		newSecret("VAULT_SECRET_ID", vault.secretID, ""), "approle", newTestLogger())
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, client.renewToken(ctx))
+	logins, renewals := vault.counts()
+	assert.Equal(t, 1, logins)
+	assert.GreaterOrEqual(t, renewals, 2)
+
+	// The token is still valid, past its initial ttl
+	ttl, _, err := client.lookupToken(context.Background())
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+}
+
+func TestValidateVault(t *testing.T) {
+	env := envConfig{VaultAddr: "http://vault:8200", VaultSecretPath: "mining", VaultToken: "token"}
+	assert.Nil(t, env.validateVault())
+	env.VaultRoleId = "role"
+	assert.NotNil(t, env.validateVault())
+	env.VaultToken = ""
+	assert.NotNil(t, env.validateVault())
+	env.VaultSecretIdFile = "/run/secrets/vault-secret-id"
+	assert.Nil(t, env.validateVault())
+	env.VaultSecretPath = ""
+	assert.NotNil(t, env.validateVault())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// webhookConfig is a webhook of the notifications config. Template is a go template of the JSON body,
+// the event being the dot, the event itself being sent as is if not set.
+type webhookConfig struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Secret     string            `json:"secret"`
+	SecretFile string            `json:"secretFile"`
+	Template   string            `json:"template"`
+	Events     []eventType       `json:"events"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// templateFuncs are the functions available in the templates of the notifications.
+var templateFuncs = template.FuncMap{
+	// json encodes a value in JSON, i.e. to quote a string in a JSON body
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// webhookSink posts the events as JSON to a url, signed with HMAC-SHA256 if a secret is set: the
+// X-Companion-Signature header is sha256=<hex of the HMAC of "<X-Companion-Timestamp>.<body>">.
+type webhookSink struct {
+	url        string
+	secret     *secret
+	template   *template.Template
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+func newWebhookSink(config webhookConfig) (*webhookSink, error) {
+	sink := &webhookSink{
+		url:        config.URL,
+		headers:    config.Headers,
+		httpClient: &http.Client{Timeout: notificationTimeout},
+	}
+	if config.Secret != "" || config.SecretFile != "" {
+		sink.secret = newSecret("webhook secret", config.Secret, config.SecretFile)
+	}
+	if config.Template != "" {
+		t, err := template.New(config.Name).Funcs(templateFuncs).Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("template of %s is not valid: %w", config.Name, err)
+		}
+		sink.template = t
+	}
+	return sink, nil
+}
+
+// body returns the JSON body of the event.
+func (s *webhookSink) body(e event) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(e)
+	}
+	var body bytes.Buffer
+	err := s.template.Execute(&body, e)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("template doesn't give valid JSON for event %s: %s", e.Type, body.String())
+	}
+	return body.Bytes(), nil
+}
+
+func (s *webhookSink) deliver(ctx context.Context, e event) error {
+	body, err := s.body(e)
+	if err != nil {
+		return permanent(err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Companion-Event", string(e.Type))
+	for name, value := range s.headers {
+		request.Header.Set(name, value)
+	}
+	if s.secret != nil {
+		key, err := s.secret.reveal()
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set("X-Companion-Timestamp", timestamp)
+		request.Header.Set("X-Companion-Signature", "sha256="+signPayload(key, timestamp, body))
+		wipe(key)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// The reason of the refusal, if any, helps figuring out what's wrong with the template
+	reason, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	return httpDeliveryError(response.StatusCode, strings.TrimSpace(string(reason)))
+}
+
+// httpDeliveryError returns the error of a delivery answered with status, nil if successful. Only the
+// server errors and the rate limiting are worth retrying.
+func httpDeliveryError(status int, reason string) error {
+	if status < 300 {
+		return nil
+	}
+	err := fmt.Errorf("answered %d %s %s", status, http.StatusText(status), reason)
+	if status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout {
+		return err
+	}
+	return permanent(err)
+}
+
+// signPayload returns the hex HMAC-SHA256 of "<timestamp>.<body>" with key.
+func signPayload(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}